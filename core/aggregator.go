@@ -1,12 +1,22 @@
 package main
 
 import (
-	"encoding/base64"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -34,10 +44,10 @@ type Config struct {
 	Metadata    map[string]string `json:"metadata,omitempty"`
 
 	// REALITY protocol fields
-	PublicKey     string `json:"public_key,omitempty"`
-	ShortID       string `json:"short_id,omitempty"`
-	ServerName    string `json:"server_name,omitempty"`
-	StaleBehavior string `json:"stale_behavior,omitempty"`
+	PublicKey  string `json:"public_key,omitempty"`
+	ShortID    string `json:"short_id,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+	SpiderX    string `json:"spider_x,omitempty"` // initial REALITY crawler path (spx)
 
 	// XHTTP protocol fields
 	HTTPMethod       string `json:"http_method,omitempty"`
@@ -49,6 +59,42 @@ type Config struct {
 	TLSServerName string `json:"tls_server_name,omitempty"`
 	AllowInsecure bool   `json:"allow_insecure,omitempty"`
 
+	// Trojan-Go shadowsocks layer from encryption=ss;method;password
+	TrojanSSMethod   string `json:"trojan_ss_method,omitempty"`
+	TrojanSSPassword string `json:"trojan_ss_password,omitempty"`
+
+	// Plugin is the SIP002 Shadowsocks plugin, e.g. obfs-local;obfs=http,
+	// also split into the plugin name and its options
+	Plugin     string            `json:"plugin,omitempty"`
+	PluginName string            `json:"plugin_name,omitempty"`
+	PluginOpts map[string]string `json:"plugin_opts,omitempty"`
+
+	// Hysteria2 obfuscation (salamander) from the obfs and obfs-password
+	// link parameters
+	Obfs         string `json:"obfs,omitempty"`
+	ObfsPassword string `json:"obfs_password,omitempty"`
+
+	// ShadowsocksR protocol and obfs plugins with their parameters
+	SSRProtocol      string `json:"ssr_protocol,omitempty"`
+	SSRProtocolParam string `json:"ssr_protocol_param,omitempty"`
+	SSRObfs          string `json:"ssr_obfs,omitempty"`
+	SSRObfsParam     string `json:"ssr_obfs_param,omitempty"`
+
+	// TUIC congestion control (cubic, new_reno, bbr) and UDP relay mode
+	// (native, quic)
+	CongestionControl string `json:"congestion_control,omitempty"`
+	UDPRelayMode      string `json:"udp_relay_mode,omitempty"`
+
+	// PinnedCertSHA256 is the hex certificate hash from a pinSHA256 link parameter
+	PinnedCertSHA256 string `json:"pinned_cert_sha256,omitempty"`
+
+	// ALPN is the comma-separated TLS ALPN list from an alpn link parameter
+	ALPN string `json:"alpn,omitempty"`
+
+	// PacketEncoding is the VLESS UDP packet encoding (xudp or packet) from
+	// a packetEncoding link parameter
+	PacketEncoding string `json:"packet_encoding,omitempty"`
+
 	// Advanced protocol options
 	AlterId        int    `json:"alter_id,omitempty"` // VMess alter ID
 	Flow           string `json:"flow,omitempty"`     // VLESS flow (xtls-rprx-vision)
@@ -57,9 +103,136 @@ type Config struct {
 	SkipCertVerify bool   `json:"skip_cert_verify,omitempty"`
 	TransportType  string `json:"transport_type,omitempty"` // tcp, mux, grpc, ws, http
 
+	// gRPC transport fields
+	GRPCServiceName string `json:"grpc_service_name,omitempty"`
+	GRPCMode        string `json:"grpc_mode,omitempty"` // gun, multi
+
+	// mKCP transport fields: the obfuscation seed and the packet header
+	// disguise (none, srtp, utp, wechat-video, dtls, wireguard)
+	KCPSeed       string `json:"kcp_seed,omitempty"`
+	KCPHeaderType string `json:"kcp_header_type,omitempty"`
+
 	// Performance and metadata
-	ParseTime        int64  `json:"parse_time_ns,omitempty"`
-	ValidationStatus string `json:"validation_status,omitempty"`
+	ParseTime        int64     `json:"parse_time_ns,omitempty"`
+	ValidationStatus string    `json:"validation_status,omitempty"`
+	CertNotAfter     time.Time `json:"cert_not_after,omitempty"` // set by the TLS check
+
+	// metaMu guards Metadata for concurrent enrichment
+	metaMu sync.RWMutex
+}
+
+// SetMeta sets a metadata value, safe for concurrent use
+func (c *Config) SetMeta(key, value string) {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]string)
+	}
+	c.Metadata[key] = value
+}
+
+// GetMeta returns a metadata value, safe for concurrent use
+func (c *Config) GetMeta(key string) string {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+
+	return c.Metadata[key]
+}
+
+// Clone returns a copy of the config with its own Metadata map. Exported
+// fields are copied reflectively so the copy stays complete as fields are
+// added, while the metadata lock is left fresh.
+func (c *Config) Clone() *Config {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+
+	clone := &Config{}
+	src := reflect.ValueOf(c).Elem()
+	dst := reflect.ValueOf(clone).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if field := dst.Field(i); field.CanSet() {
+			field.Set(src.Field(i))
+		}
+	}
+
+	if c.Metadata != nil {
+		clone.Metadata = make(map[string]string, len(c.Metadata))
+		for key, value := range c.Metadata {
+			clone.Metadata[key] = value
+		}
+	}
+	if c.PluginOpts != nil {
+		clone.PluginOpts = make(map[string]string, len(c.PluginOpts))
+		for key, value := range c.PluginOpts {
+			clone.PluginOpts[key] = value
+		}
+	}
+
+	return clone
+}
+
+// Key returns a stable fingerprint of the config used for dedup and map
+// lookups. Unlike ID, which is a short human-facing label, Key covers every
+// field that changes how a client connects, and ignores display-only fields
+// such as Name and Source.
+func (c *Config) Key() string {
+	// tcp is the default transport, so an unset transport is the same node
+	transport := c.TransportType
+	if transport == "" {
+		transport = "tcp"
+	}
+
+	// Hostnames are case-insensitive; only the key folds case, so output
+	// keeps the host as published
+	canonical := strings.Join([]string{
+		c.Protocol,
+		strings.ToLower(c.Server),
+		strconv.Itoa(c.Port),
+		c.UUID,
+		c.Password,
+		c.Method,
+		c.Cipher,
+		transport,
+		c.Security,
+		c.Flow,
+		strings.ToLower(c.ServerName),
+		strings.ToLower(c.TLSServerName),
+		c.PublicKey,
+		c.ShortID,
+		c.HTTPMethod,
+		strings.ToLower(c.HTTPHost),
+		c.HTTPPath,
+		c.GRPCServiceName,
+		c.GRPCMode,
+		strings.ToLower(c.PinnedCertSHA256),
+		c.TrojanSSMethod,
+		c.TrojanSSPassword,
+	}, "|")
+
+	// Appended only when set, so keys of configs without obfuscation stay
+	// the same as those already stored
+	if c.Obfs != "" {
+		canonical += "|" + c.Obfs + "|" + c.ObfsPassword
+	}
+	if c.CongestionControl != "" || c.UDPRelayMode != "" {
+		canonical += "|" + c.CongestionControl + "|" + c.UDPRelayMode
+	}
+	if c.KCPSeed != "" || c.KCPHeaderType != "" {
+		canonical += "|" + c.KCPSeed + "|" + c.KCPHeaderType
+	}
+	if c.SSRProtocol != "" || c.SSRObfs != "" {
+		canonical += "|" + strings.Join([]string{c.SSRProtocol, c.SSRProtocolParam, c.SSRObfs, c.SSRObfsParam}, "|")
+	}
+	if c.Plugin != "" {
+		canonical += "|plugin=" + c.Plugin
+	}
+	if c.AlterId != 0 {
+		canonical += "|aid=" + strconv.Itoa(c.AlterId)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:])
 }
 
 // ConfigSource represents a source to fetch configs from
@@ -71,6 +244,42 @@ type ConfigSource struct {
 	Auth     string `yaml:"auth,omitempty"`
 	Timeout  int    `yaml:"timeout,omitempty"`  // seconds
 	Interval int    `yaml:"interval,omitempty"` // seconds between updates
+	Priority int    `yaml:"priority,omitempty"` // higher is more trusted when ranking output
+
+	// Headers are sent with every request to the source, e.g. a Referer
+	// or an API key the source requires
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// MaxBodyBytes reads only this many leading bytes of a huge plain
+	// source, dropping the partial last line (0 = whole body)
+	MaxBodyBytes int64 `yaml:"max_body_bytes,omitempty"`
+
+	// Pagination for API-style sources whose JSON pages link to the next page
+	Paginate  bool   `yaml:"paginate,omitempty"`
+	NextPath  string `yaml:"next_path,omitempty"`  // dotted JSON path of the next page URL, default "next"
+	ItemsPath string `yaml:"items_path,omitempty"` // dotted JSON path of the share link array, default "configs"
+	MaxPages  int    `yaml:"max_pages,omitempty"`  // page limit, default 10
+}
+
+// stdinSourcesFile is the -sources value that reads links from stdin
+const stdinSourcesFile = "-"
+
+// stdinSource is the synthetic source for links piped on stdin
+func stdinSource() ConfigSource {
+	return ConfigSource{Name: "stdin", URL: stdinSourcesFile, Type: "plain", Enabled: true}
+}
+
+// SourceSettings holds CLI defaults embedded in the sources file
+type SourceSettings struct {
+	Format      string `yaml:"format,omitempty"`
+	Max         int    `yaml:"max,omitempty"`
+	Concurrency int    `yaml:"concurrency,omitempty"` // parallel source fetches
+}
+
+// sourcesDocument is the wrapped form of the sources file
+type sourcesDocument struct {
+	Settings SourceSettings `yaml:"settings"`
+	Sources  []ConfigSource `yaml:"sources"`
 }
 
 // FilterRule represents a filtering rule
@@ -85,19 +294,81 @@ type FilterRule struct {
 // Aggregator manages config fetching and processing
 type Aggregator struct {
 	sources      []ConfigSource
+	settings     SourceSettings
 	rules        []FilterRule
 	cache        *Cache
 	maxConfigs   int
+	minSources   int
+	concurrency  int
+	noCache      bool
 	httpClient   *resty.Client
+	parser       *ProtocolParser
 	configs      map[string]*Config
+	order        []string // config keys in insertion order
 	configsMutex sync.RWMutex
+	duplicates   int
+
+	// unsupported counts recognized but unmodelled schemes by name
+	unsupported   map[string]int
+	unsupportedMu sync.Mutex
+
+	// parseErrors counts links that failed to parse, by source name
+	parseErrors   map[string]int
+	parseErrorsMu sync.Mutex
+
+	// emptyRetryWait is the delay before re-fetching a source that returned
+	// no configs
+	emptyRetryWait time.Duration
+
+	// stdin supplies links when the sources file is "-"
+	stdin io.Reader
+
+	// progress is called as each source finishes fetching
+	progress func(FetchProgress)
+
+	// geo resolves server countries when a geo database is loaded
+	geo *GeoResolver
+
+	// health persists consecutive source failures so dead sources are skipped
+	health *SourceState
+
+	// failFast aborts the fetch on the first source error
+	failFast bool
+
+	// verbose logs each link that fails to parse
+	verbose bool
+
+	// dumpRawDir receives each source's raw response body when set
+	dumpRawDir string
+}
+
+// FetchProgress reports a source that finished fetching
+type FetchProgress struct {
+	Source    string
+	Completed int
+	Total     int
+	Err       error
 }
 
+// emptyResultRetries is how many times a source yielding no configs is re-fetched
+const emptyResultRetries = 2
+
+// maxSubscriptionDepth is how many subscription URLs deep a plain source
+// whose body is just another subscription URL is followed
+const maxSubscriptionDepth = 3
+
 // NewAggregator creates a new aggregator instance
 func NewAggregator(sourcesFile, rulesFile string, maxConfigs int) (*Aggregator, error) {
-	sources, err := loadSources(sourcesFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load sources: %w", err)
+	var sources []ConfigSource
+	var settings SourceSettings
+	if sourcesFile == stdinSourcesFile {
+		sources = []ConfigSource{stdinSource()}
+	} else {
+		var err error
+		sources, settings, err = loadSources(sourcesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load sources: %w", err)
+		}
 	}
 
 	rules, err := loadRules(rulesFile)
@@ -113,21 +384,159 @@ func NewAggregator(sourcesFile, rulesFile string, maxConfigs int) (*Aggregator,
 		SetRetryWaitTime(1 * time.Second)
 
 	return &Aggregator{
-		sources:    sources,
-		rules:      rules,
-		cache:      cache,
-		maxConfigs: maxConfigs,
-		httpClient: httpClient,
-		configs:    make(map[string]*Config),
+		sources:     sources,
+		settings:    settings,
+		rules:       rules,
+		cache:       cache,
+		maxConfigs:  maxConfigs,
+		concurrency: settings.Concurrency,
+		httpClient:  httpClient,
+		parser:      NewProtocolParser(),
+		configs:     make(map[string]*Config),
+		unsupported: make(map[string]int),
+		parseErrors: make(map[string]int),
+
+		emptyRetryWait: 2 * time.Second,
+		stdin:          os.Stdin,
 	}, nil
 }
 
+// Settings returns the defaults declared in the sources file
+func (a *Aggregator) Settings() SourceSettings {
+	return a.settings
+}
+
+// SetGeoResolver enables country enrichment of fetched configs. A nil
+// resolver leaves Country empty and country rules inert.
+func (a *Aggregator) SetGeoResolver(geo *GeoResolver) {
+	a.geo = geo
+}
+
+// Close releases the geo database, if one is loaded
+func (a *Aggregator) Close() error {
+	if a.geo == nil {
+		return nil
+	}
+	return a.geo.Close()
+}
+
+// SetSourceState enables tracking of consecutive source failures across
+// runs. Sources in their backoff period are skipped and the state is saved
+// after each fetch.
+func (a *Aggregator) SetSourceState(state *SourceState) {
+	a.health = state
+}
+
+// SetProgress sets a callback invoked once per completed source. Calls are
+// serialized, so the callback need not be safe for concurrent use.
+func (a *Aggregator) SetProgress(progress func(FetchProgress)) {
+	a.progress = progress
+}
+
+// SourcePriorities returns the configured priority of each source by name
+func (a *Aggregator) SourcePriorities() map[string]int {
+	priorities := make(map[string]int, len(a.sources))
+	for _, source := range a.sources {
+		priorities[source.Name] = source.Priority
+	}
+	return priorities
+}
+
+// SetMaxConfigs overrides the maximum number of configs to collect
+func (a *Aggregator) SetMaxConfigs(maxConfigs int) {
+	a.maxConfigs = maxConfigs
+}
+
+// SetMinSources keeps only configs seen in at least n distinct sources.
+// Agreement can only be judged once every source is in, so values above 1
+// disable stopping early at max configs.
+func (a *Aggregator) SetMinSources(n int) {
+	a.minSources = n
+}
+
+// SetNoCache makes the aggregator bypass the cache for both reads and writes
+func (a *Aggregator) SetNoCache(noCache bool) {
+	a.noCache = noCache
+}
+
+// SetConcurrency limits how many sources are fetched in parallel (0 = unlimited)
+func (a *Aggregator) SetConcurrency(concurrency int) {
+	a.concurrency = concurrency
+}
+
+// SetFailFast makes FetchAndProcessConfigs stop at the first source error
+// and return it, rather than logging it and carrying on with other sources
+func (a *Aggregator) SetFailFast(failFast bool) {
+	a.failFast = failFast
+}
+
+// SetVerbose logs each link that fails to parse along with the reason
+func (a *Aggregator) SetVerbose(verbose bool) {
+	a.verbose = verbose
+}
+
+// SetDumpRawDir writes each fetched source body to dir/<source>.raw before
+// parsing; an empty dir disables dumping
+func (a *Aggregator) SetDumpRawDir(dir string) {
+	a.dumpRawDir = dir
+}
+
 // FetchAndProcessConfigs fetches configs from all sources and applies filtering
 func (a *Aggregator) FetchAndProcessConfigs() ([]*Config, error) {
+	// Collected configs and the duplicate, unsupported scheme and parse error
+	// counts describe the latest run only
+	a.configsMutex.Lock()
+	a.configs = make(map[string]*Config)
+	a.order = nil
+	a.duplicates = 0
+	a.configsMutex.Unlock()
+	a.unsupportedMu.Lock()
+	a.unsupported = make(map[string]int)
+	a.unsupportedMu.Unlock()
+	a.parseErrorsMu.Lock()
+	a.parseErrors = make(map[string]int)
+	a.parseErrorsMu.Unlock()
+
 	var wg sync.WaitGroup
-	configsChan := make(chan *Config, 1000)
+	configsChan := make(chan *Config, a.channelBufferSize())
 	errorsChan := make(chan error, len(a.sources))
 
+	// Limit parallel fetches when a concurrency is configured
+	var sem chan struct{}
+	if a.concurrency > 0 {
+		sem = make(chan struct{}, a.concurrency)
+	}
+
+	// done is closed once max configs is reached so producers stop early
+	done := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(done) }) }
+	defer stop()
+
+	total := 0
+	for _, source := range a.sources {
+		if source.Enabled {
+			total++
+		}
+	}
+	var progressMu sync.Mutex
+	completed := 0
+	report := func(name string, err error) {
+		if a.progress == nil {
+			return
+		}
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		completed++
+		a.progress(FetchProgress{Source: name, Completed: completed, Total: total, Err: err})
+	}
+
+	// firstErr holds the error that stopped a fail-fast fetch; failed is
+	// closed once it is set
+	var firstErr error
+	var failOnce sync.Once
+	failed := make(chan struct{})
+
 	// Fetch from all sources concurrently
 	for _, source := range a.sources {
 		if !source.Enabled {
@@ -137,10 +546,41 @@ func (a *Aggregator) FetchAndProcessConfigs() ([]*Config, error) {
 		wg.Add(1)
 		go func(src ConfigSource) {
 			defer wg.Done()
-			if err := a.fetchFromSource(src, configsChan); err != nil {
+			if a.health != nil {
+				if skip, failures := a.health.Skip(src.Name, time.Now()); skip {
+					log.Printf("Skipping %s after %d consecutive failures\n", src.Name, failures)
+					report(src.Name, fmt.Errorf("skipped after %d consecutive failures", failures))
+					return
+				}
+			}
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-done:
+					return
+				}
+			}
+			err := a.fetchFromSource(src, configsChan, done)
+			if err != nil {
 				log.Printf("Error fetching from %s: %v\n", src.Name, err)
 				errorsChan <- err
+				if a.failFast {
+					failOnce.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+					stop()
+				}
+			}
+			if a.health != nil {
+				if err != nil {
+					a.health.RecordFailure(src.Name, time.Now())
+				} else {
+					a.health.RecordSuccess(src.Name)
+				}
 			}
+			report(src.Name, err)
 		}(source)
 	}
 
@@ -151,112 +591,568 @@ func (a *Aggregator) FetchAndProcessConfigs() ([]*Config, error) {
 		close(errorsChan)
 	}()
 
-	// Collect configs and apply deduplication
-	seen := make(map[string]bool)
-	var config *Config
+	// Collect configs and apply deduplication, remembering which sources
+	// carried each node and the priority of the source whose version won
+	dedup := newDedupStore()
+	sourcesByKey := make(map[string]map[string]bool)
+	priorities := a.SourcePriorities()
+	limitReached := false
 
-	for config = range configsChan {
-		// Skip duplicates
-		configKey := fmt.Sprintf("%s:%d:%s", config.Server, config.Port, config.Protocol)
-		if seen[configKey] {
+collect:
+	for {
+		var config *Config
+		select {
+		case cfg, ok := <-configsChan:
+			if !ok {
+				break collect
+			}
+			config = cfg
+		case <-failed:
+			// Don't wait for in-flight fetches; they exit on done and
+			// the channels close behind them
+			return nil, firstErr
+		}
+
+		// Once max configs is reached keep draining until the producers
+		// have seen done and exited, so the channel can be closed and no
+		// goroutine is left blocked on a send
+		if limitReached {
+			continue
+		}
+
+		configKey := config.Key()
+		if sourcesByKey[configKey] == nil {
+			sourcesByKey[configKey] = make(map[string]bool)
+		}
+		sourcesByKey[configKey][config.Source] = true
+
+		// Skip duplicates, unless this copy comes from a more trusted
+		// source than the one kept so far, whatever the arrival order
+		keep, duplicate := dedup.Claim(configKey, priorities[config.Source])
+		if duplicate {
+			a.duplicates++
+		}
+		if !keep {
 			continue
 		}
-		seen[configKey] = true
 
 		// Apply filtering rules
-		if a.shouldIncludeConfig(config) {
+		if !a.shouldIncludeConfig(config) {
+			// The winning copy decides, so drop a less trusted one kept earlier
+			a.configsMutex.Lock()
+			delete(a.configs, configKey)
+			a.configsMutex.Unlock()
+		} else {
 			a.configsMutex.Lock()
-			a.configs[config.ID] = config
+			if _, exists := a.configs[configKey]; !exists {
+				a.order = append(a.order, configKey)
+			}
+			a.configs[configKey] = config
 			a.configsMutex.Unlock()
 
-			// Stop if we've reached max configs
-			if len(a.configs) >= a.maxConfigs {
-				break
+			// Stop collecting once we've reached max configs
+			if a.minSources <= 1 && len(a.configs) >= a.maxConfigs {
+				limitReached = true
+				stop()
 			}
 		}
 	}
 
-	a.configsMutex.RLock()
-	defer a.configsMutex.RUnlock()
+	// Every fetch has finished once configsChan is closed
+	if a.health != nil {
+		if err := a.health.Save(); err != nil {
+			log.Printf("Failed to save source state: %v\n", err)
+		}
+	}
 
-	result := make([]*Config, 0, len(a.configs))
-	for _, cfg := range a.configs {
-		result = append(result, cfg)
+	// A fail-fast error can race the channel close
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	a.configsMutex.Lock()
+	defer a.configsMutex.Unlock()
+
+	if a.minSources > 1 {
+		for key := range a.configs {
+			if len(sourcesByKey[key]) < a.minSources {
+				delete(a.configs, key)
+			}
+		}
+	}
+
+	result := a.orderedConfigs()
+	if len(result) > a.maxConfigs {
+		result = result[:a.maxConfigs]
 	}
 
 	return result, nil
 }
 
-func (a *Aggregator) fetchFromSource(source ConfigSource, configsChan chan<- *Config) error {
+// orderedConfigs returns the collected configs grouped by the order of
+// sources in the sources file, each source's configs in the order it listed
+// them, so the output is stable whenever the sources are. Callers must hold
+// configsMutex.
+func (a *Aggregator) orderedConfigs() []*Config {
+	sourceIndex := make(map[string]int, len(a.sources))
+	for i, source := range a.sources {
+		sourceIndex[source.Name] = i
+	}
+
+	result := make([]*Config, 0, len(a.configs))
+	emitted := make(map[string]bool, len(a.configs))
+	for _, key := range a.order {
+		if cfg, ok := a.configs[key]; ok && !emitted[key] {
+			emitted[key] = true
+			result = append(result, cfg)
+		}
+	}
+
+	// Sources are fetched concurrently, so arrival order interleaves them;
+	// a stable sort by source restores a deterministic order
+	sort.SliceStable(result, func(i, j int) bool {
+		return sourceIndex[result[i].Source] < sourceIndex[result[j].Source]
+	})
+
+	return result
+}
+
+// channelBufferSize sizes the collection channel relative to maxConfigs so
+// producers rarely block, without allocating for unbounded limits
+func (a *Aggregator) channelBufferSize() int {
+	const defaultBuffer = 1000
+	const maxBuffer = 10000
+
+	if a.maxConfigs <= 0 {
+		return defaultBuffer
+	}
+	if a.maxConfigs > maxBuffer {
+		return maxBuffer
+	}
+	return a.maxConfigs
+}
+
+// Duplicates returns how many duplicate configs were dropped during the last fetch
+func (a *Aggregator) Duplicates() int {
+	return a.duplicates
+}
+
+// Unsupported returns how many links of each recognized but unsupported
+// scheme were seen
+func (a *Aggregator) Unsupported() map[string]int {
+	a.unsupportedMu.Lock()
+	defer a.unsupportedMu.Unlock()
+
+	counts := make(map[string]int, len(a.unsupported))
+	for scheme, n := range a.unsupported {
+		counts[scheme] = n
+	}
+	return counts
+}
+
+// countUnsupported records a link whose scheme is recognized but unsupported
+func (a *Aggregator) countUnsupported(link string) {
+	scheme, _, _ := strings.Cut(link, "://")
+
+	a.unsupportedMu.Lock()
+	a.unsupported[strings.ToLower(scheme)]++
+	a.unsupportedMu.Unlock()
+}
+
+// ParseErrors returns how many links from each source failed to parse
+func (a *Aggregator) ParseErrors() map[string]int {
+	a.parseErrorsMu.Lock()
+	defer a.parseErrorsMu.Unlock()
+
+	counts := make(map[string]int, len(a.parseErrors))
+	for source, n := range a.parseErrors {
+		counts[source] = n
+	}
+	return counts
+}
+
+// countParseError records a link from source that failed to parse
+func (a *Aggregator) countParseError(source string) {
+	a.parseErrorsMu.Lock()
+	a.parseErrors[source]++
+	a.parseErrorsMu.Unlock()
+}
+
+func (a *Aggregator) fetchFromSource(source ConfigSource, configsChan chan<- *Config, done <-chan struct{}) error {
+	if source.URL == stdinSourcesFile {
+		return a.fetchFromStdin(source, configsChan, done)
+	}
+
 	// Check cache first
-	if cached := a.cache.Get(source.Name); cached != nil {
-		log.Printf("Using cached configs from %s\n", source.Name)
-		if configs, ok := cached.([]*Config); ok {
-			for _, cfg := range configs {
-				configsChan <- cfg
+	if !a.noCache {
+		if cached := a.cache.Get(source.Name); cached != nil {
+			log.Printf("Using cached configs from %s\n", source.Name)
+			if configs, ok := cached.([]*Config); ok {
+				sendConfigs(configs, configsChan, done)
 			}
+			return nil
+		}
+	}
+
+	if a.verbose && len(source.Headers) > 0 {
+		log.Printf("Fetching %s with headers %s\n", source.Name, redactHeaders(source.Headers))
+	}
+
+	// A 200 with an empty or truncated body is usually transient, so retry a
+	// few times before accepting it, and never cache an empty result
+	var configs []*Config
+	for attempt := 0; ; attempt++ {
+		var err error
+		configs, err = a.fetchSourceConfigs(source)
+		if err != nil {
+			return err
 		}
+
+		if len(configs) > 0 || attempt >= emptyResultRetries {
+			break
+		}
+
+		log.Printf("Source %s returned no configs, retrying (%d/%d)\n", source.Name, attempt+1, emptyResultRetries)
+		select {
+		case <-time.After(a.emptyRetryWait):
+		case <-done:
+			return nil
+		}
+	}
+
+	if len(configs) == 0 {
+		log.Printf("Source %s returned no configs after %d retries\n", source.Name, emptyResultRetries)
 		return nil
 	}
 
-	resp, err := a.httpClient.R().Get(source.URL)
+	if a.geo != nil {
+		a.geo.Resolve(configs)
+	}
+
+	// Cache the configs
+	if !a.noCache {
+		a.cache.Set(source.Name, configs)
+	}
+
+	// Send to channel
+	sendConfigs(configs, configsChan, done)
+
+	return nil
+}
+
+// fetchFromStdin parses links piped on stdin. Stdin can only be read once,
+// so the result is neither retried nor cached.
+func (a *Aggregator) fetchFromStdin(source ConfigSource, configsChan chan<- *Config, done <-chan struct{}) error {
+	body, err := io.ReadAll(a.stdin)
 	if err != nil {
-		return fmt.Errorf("failed to fetch from %s: %w", source.Name, err)
+		return fmt.Errorf("failed to read stdin: %w", err)
+	}
+
+	a.dumpRaw(source.Name, body)
+	configs, err := a.parseSourceBody(source, body)
+	if err != nil {
+		return err
+	}
+
+	if a.geo != nil {
+		a.geo.Resolve(configs)
+	}
+
+	sendConfigs(configs, configsChan, done)
+	return nil
+}
+
+// sourceRequest starts a request to rawURL. The source's custom headers are
+// only sent to the origin of the configured URL, never to a next page or
+// nested subscription hosted elsewhere.
+func (a *Aggregator) sourceRequest(source ConfigSource, rawURL string) *resty.Request {
+	req := a.httpClient.R()
+	if sameOrigin(source.URL, rawURL) {
+		req.SetHeaders(source.Headers)
+	}
+	return req
+}
+
+// sameOrigin reports whether two URLs share a scheme and host
+func sameOrigin(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// fetchBody downloads the raw body at rawURL, the source's URL or one of its
+// next pages or nested subscriptions
+func (a *Aggregator) fetchBody(source ConfigSource, rawURL string) ([]byte, error) {
+	if source.MaxBodyBytes > 0 {
+		return a.fetchBodyPrefix(source, rawURL)
+	}
+
+	resp, err := a.sourceRequest(source, rawURL).Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch from %s: %w", source.Name, err)
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("unexpected status code from %s: %d", source.Name, resp.StatusCode())
+		return nil, fmt.Errorf("unexpected status code from %s: %d", source.Name, resp.StatusCode())
 	}
 
-	var configs []*Config
+	// Captive portals and CDNs serve error pages with a 200 status
+	if looksLikeHTML(resp.Header().Get("Content-Type"), resp.Body()) {
+		return nil, fmt.Errorf("source %s returned an HTML page instead of configs", source.Name)
+	}
+
+	return resp.Body(), nil
+}
+
+// fetchBodyPrefix fetches at most MaxBodyBytes of a source. It asks for the
+// prefix with a Range header and stops reading there if the server sends
+// the whole file anyway. A cut mid-line would leave a truncated share link
+// that can still parse (e.g. with a shortened port), so the partial last
+// line is dropped.
+func (a *Aggregator) fetchBodyPrefix(source ConfigSource, rawURL string) ([]byte, error) {
+	limit := source.MaxBodyBytes
+	resp, err := a.sourceRequest(source, rawURL).
+		SetHeader("Range", fmt.Sprintf("bytes=0-%d", limit-1)).
+		SetDoNotParseResponse(true).
+		Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch from %s: %w", source.Name, err)
+	}
+	raw := resp.RawBody()
+	defer raw.Close()
+
+	status := resp.StatusCode()
+	if status != http.StatusOK && status != http.StatusPartialContent {
+		return nil, fmt.Errorf("unexpected status code from %s: %d", source.Name, status)
+	}
+
+	// Read one byte past the limit to tell a cut body from one that fits
+	body, err := io.ReadAll(io.LimitReader(raw, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from %s: %w", source.Name, err)
+	}
+
+	truncated := int64(len(body)) > limit
+	if status == http.StatusPartialContent {
+		total, ok := contentRangeTotal(resp.Header().Get("Content-Range"))
+		truncated = !ok || total > int64(len(body))
+	}
+	if truncated {
+		if int64(len(body)) > limit {
+			body = body[:limit]
+		}
+		if end := bytes.LastIndexByte(body, '\n'); end >= 0 {
+			body = body[:end+1]
+		} else {
+			body = nil
+		}
+		log.Printf("Read the first %d bytes of %s (max_body_bytes)\n", len(body), source.Name)
+	}
+
+	if looksLikeHTML(resp.Header().Get("Content-Type"), body) {
+		return nil, fmt.Errorf("source %s returned an HTML page instead of configs", source.Name)
+	}
+
+	return body, nil
+}
+
+// contentRangeTotal returns the complete length from a "bytes 0-99/1234"
+// Content-Range header, reporting false when it is missing or unknown ("*")
+func contentRangeTotal(header string) (int64, bool) {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// looksLikeHTML reports whether a response body is an HTML page. A text/html
+// content type alone is not enough, since some hosts serve plain link lists
+// with it, so the body must also start with markup.
+func looksLikeHTML(contentType string, body []byte) bool {
+	head := body
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	start := strings.ToLower(strings.TrimSpace(string(head)))
+
+	if strings.HasPrefix(start, "<!doctype html") || strings.HasPrefix(start, "<html") {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(contentType), "text/html") && strings.HasPrefix(start, "<")
+}
+
+// fetchSourceConfigs fetches and parses a source, following pages for
+// paginated sources
+func (a *Aggregator) fetchSourceConfigs(source ConfigSource) ([]*Config, error) {
+	if source.Paginate {
+		return a.fetchPaginated(source)
+	}
+
+	body, err := a.fetchBody(source, source.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	// A body that is only a URL redirects to another subscription
+	for depth := 0; source.Type == "plain"; depth++ {
+		next, ok := nestedSubscriptionURL(body)
+		if !ok {
+			break
+		}
+		if depth >= maxSubscriptionDepth {
+			return nil, fmt.Errorf("source %s nests subscription URLs more than %d deep", source.Name, maxSubscriptionDepth)
+		}
+
+		log.Printf("Source %s points to another subscription, following %s\n", source.Name, next)
+		if body, err = a.fetchBody(source, next); err != nil {
+			return nil, err
+		}
+	}
+
+	a.dumpRaw(source.Name, body)
+	return a.parseSourceBody(source, body)
+}
+
+// nestedSubscriptionURL returns the URL a body consists of, if the whole
+// body is a single http(s) URL
+func nestedSubscriptionURL(body []byte) (string, bool) {
+	text := strings.TrimSpace(string(body))
+	if strings.ContainsAny(text, " \t\r\n") {
+		return "", false
+	}
+
+	u, err := url.Parse(text)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return text, true
+}
+
+// parseSourceBody parses a fetched body according to the source type
+func (a *Aggregator) parseSourceBody(source ConfigSource, body []byte) ([]*Config, error) {
 	switch source.Type {
 	case "base64":
-		configs, err = a.parseBase64Configs(resp.Body())
+		return a.parseBase64Configs(body, source.Name)
 	case "json":
-		configs, err = a.parseJSONConfigs()
+		return a.parseJSONConfigs(body, source.Name)
 	case "plain":
-		configs, err = a.parsePlainConfigs()
+		return a.parsePlainConfigs(body, source.Name)
 	default:
-		return fmt.Errorf("unknown source type: %s", source.Type)
+		return nil, fmt.Errorf("unknown source type: %s", source.Type)
 	}
+}
 
-	if err != nil {
-		return err
+// sendConfigs forwards configs to the collector, giving up as soon as done is
+// closed so producers never outlive the collection loop
+func sendConfigs(configs []*Config, configsChan chan<- *Config, done <-chan struct{}) {
+	for _, cfg := range configs {
+		select {
+		case configsChan <- cfg:
+		case <-done:
+			return
+		}
 	}
+}
 
-	// Cache the configs
-	a.cache.Set(source.Name, configs)
-
-	// Send to channel
-	for _, cfg := range configs {
-		configsChan <- cfg
+// parseBase64Configs decodes a base64 subscription body and parses the
+// share links inside it. Many providers serve unpadded or URL-safe base64,
+// often wrapped across lines, so all four encodings are tried once the
+// whitespace is removed.
+func (a *Aggregator) parseBase64Configs(data []byte, sourceName string) ([]*Config, error) {
+	decoded, ok := decodeBase64Strict(strings.Join(strings.Fields(string(data)), ""))
+	if !ok {
+		return nil, fmt.Errorf("failed to decode base64 from %s", sourceName)
 	}
 
-	return nil
+	return a.parsePlainConfigs([]byte(decoded), sourceName)
 }
 
-func (a *Aggregator) parseBase64Configs(data []byte) ([]*Config, error) {
-	decoded, err := base64.StdEncoding.DecodeString(string(data))
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode base64: %w", err)
+// parseJSONConfigs parses a JSON body that is an array of config objects, a
+// single config object, or an object whose proxies or outbounds key holds
+// the array. Clash proxies and Sing-box outbounds are read with their own
+// field names. Malformed entries are skipped with a warning.
+func (a *Aggregator) parseJSONConfigs(data []byte, sourceName string) ([]*Config, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON from %s: %w", sourceName, err)
 	}
 
-	var _ []byte = decoded
-	return a.parsePlainConfigs()
-}
+	var entries []interface{}
+	dialect := jsonDialectShareLink
+	switch v := doc.(type) {
+	case []interface{}:
+		entries = v
+	case map[string]interface{}:
+		entries = []interface{}{v}
+		for _, list := range jsonListDialects {
+			if items, ok := v[list.key].([]interface{}); ok {
+				entries, dialect = items, list.dialect
+				break
+			}
+		}
+	default:
+		return nil, fmt.Errorf("JSON from %s is neither an object nor an array", sourceName)
+	}
 
-func (a *Aggregator) parseJSONConfigs() ([]*Config, error) {
-	// This would parse JSON format configs
-	// Implementation depends on the JSON structure
 	var configs []*Config
-	// TODO: Implement JSON parsing
+	for i, entry := range entries {
+		if !isJSONProxyEntry(entry, dialect) {
+			continue
+		}
+
+		raw, err := json.Marshal(shareLinkJSONFields(entry, dialect))
+		if err != nil {
+			continue
+		}
+
+		parsed, err := a.parser.parseJSONConfig(string(raw), sourceName)
+		if err != nil {
+			a.countParseError(sourceName)
+			log.Printf("Warning: skipping JSON entry %d from %s: %v\n", i, sourceName, err)
+			continue
+		}
+		configs = append(configs, a.parser.expand(parsed)...)
+	}
 	return configs, nil
 }
 
-func (a *Aggregator) parsePlainConfigs() ([]*Config, error) {
-	// Parse line-by-line config strings (v2ray://, ss://, etc.)
+// parsePlainConfigs parses a body of share links (vmess://, ss://, etc.),
+// one per line. Blank and # comment lines are skipped, and a line that fails
+// to parse is skipped rather than failing the whole source.
+func (a *Aggregator) parsePlainConfigs(data []byte, sourceName string) ([]*Config, error) {
 	var configs []*Config
-	// TODO: Implement plain config parsing
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parsed, err := a.parser.ParseConfigs(line, sourceName)
+		if errors.Is(err, ErrUnsupportedScheme) {
+			a.countUnsupported(line)
+			continue
+		}
+		if err != nil {
+			a.countParseError(sourceName)
+			if a.verbose {
+				log.Printf("Skipping unparseable link from %s: %v\n", sourceName, err)
+			}
+			continue
+		}
+		configs = append(configs, parsed...)
+	}
 	return configs, nil
 }
 
@@ -288,21 +1184,134 @@ func (a *Aggregator) shouldIncludeConfig(config *Config) bool {
 	return true
 }
 
-func loadSources(sourcesFile string) ([]ConfigSource, error) {
+// loadSources reads the sources file, which is either a bare list of sources
+// or a document with top-level settings and sources keys
+func loadSources(sourcesFile string) ([]ConfigSource, SourceSettings, error) {
 	data, err := os.ReadFile(sourcesFile)
 	if err != nil {
-		return nil, err
+		return nil, SourceSettings{}, err
 	}
 
-	var sources []ConfigSource
-	if err := yaml.Unmarshal(data, &sources); err != nil {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, SourceSettings{}, err
+	}
+
+	// Empty file
+	if len(root.Content) == 0 {
+		return nil, SourceSettings{}, nil
+	}
+
+	if root.Content[0].Kind == yaml.SequenceNode {
+		var sources []ConfigSource
+		if err := root.Decode(&sources); err != nil {
+			return nil, SourceSettings{}, err
+		}
+		return sources, SourceSettings{}, checkSources(sources)
+	}
+
+	var doc sourcesDocument
+	if err := root.Decode(&doc); err != nil {
+		return nil, SourceSettings{}, err
+	}
+
+	return doc.Sources, doc.Settings, checkSources(doc.Sources)
+}
+
+// checkSources rejects source options that do not apply to the source type
+func checkSources(sources []ConfigSource) error {
+	for _, source := range sources {
+		// The prefix is cut at the last newline, which would leave nothing
+		// of a single-line base64 or JSON body
+		if source.MaxBodyBytes > 0 && source.Type != "plain" {
+			return fmt.Errorf("source %s: max_body_bytes only applies to plain sources, not %s", source.Name, source.Type)
+		}
+	}
+	return nil
+}
+
+// loadRules loads and merges the rules files in spec, a comma-separated list
+// of paths or globs. Files are applied in order and a rule replaces an
+// earlier rule with the same name in place, keeping its position.
+func loadRules(spec string) ([]FilterRule, error) {
+	files, err := expandRulesFiles(spec)
+	if err != nil {
 		return nil, err
 	}
 
-	return sources, nil
+	var merged []FilterRule
+	for _, file := range files {
+		rules, err := loadRulesFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		merged = mergeRules(merged, rules)
+	}
+
+	return merged, nil
+}
+
+// expandRulesFiles splits a -rules value into file paths, expanding globs.
+// Glob matches are sorted; a glob matching nothing or a missing path is an error.
+func expandRulesFiles(spec string) ([]string, error) {
+	var files []string
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.ContainsAny(entry, "*?[") {
+			if _, err := os.Stat(entry); err != nil {
+				return nil, err
+			}
+			files = append(files, entry)
+			continue
+		}
+
+		matches, err := filepath.Glob(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rules pattern %q: %w", entry, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no rules files match %q", entry)
+		}
+		files = append(files, matches...)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no rules files given")
+	}
+
+	return files, nil
+}
+
+// mergeRules applies overrides on top of base. Named rules replace the base
+// rule with the same name; everything else is appended.
+func mergeRules(base, overrides []FilterRule) []FilterRule {
+	index := make(map[string]int, len(base))
+	for i, rule := range base {
+		if rule.Name != "" {
+			index[rule.Name] = i
+		}
+	}
+
+	for _, rule := range overrides {
+		if i, ok := index[rule.Name]; ok && rule.Name != "" {
+			base[i] = rule
+			continue
+		}
+		if rule.Name != "" {
+			index[rule.Name] = len(base)
+		}
+		base = append(base, rule)
+	}
+
+	return base
 }
 
-func loadRules(rulesFile string) ([]FilterRule, error) {
+// loadRulesFile reads a single JSON rules file
+func loadRulesFile(rulesFile string) ([]FilterRule, error) {
 	data, err := os.ReadFile(rulesFile)
 	if err != nil {
 		return nil, err
@@ -315,3 +1324,29 @@ func loadRules(rulesFile string) ([]FilterRule, error) {
 
 	return rules, nil
 }
+
+// SaveRules writes rules as indented JSON. Output is deterministic so
+// hand-edited rule files produce clean diffs when the tool rewrites them.
+func SaveRules(path string, rules []FilterRule) error {
+	if rules == nil {
+		rules = []FilterRule{}
+	}
+
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode rules: %w", err)
+	}
+	data = append(data, '\n')
+
+	// Write to a temporary file first so a failed write never truncates the rules
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write rules: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace rules file: %w", err)
+	}
+
+	return nil
+}
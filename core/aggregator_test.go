@@ -0,0 +1,1124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeTestFile writes content to a file in a temporary directory
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// TestLoadSourcesBareList tests loading a sources file that is a bare list
+func TestLoadSourcesBareList(t *testing.T) {
+	path := writeTestFile(t, "sources.yaml", `
+- name: source-a
+  url: https://example.com/a
+  type: base64
+  enabled: true
+- name: source-b
+  url: https://example.com/b
+  type: plain
+  enabled: false
+`)
+
+	sources, settings, err := loadSources(path)
+	if err != nil {
+		t.Fatalf("Failed to load bare-list sources: %v", err)
+	}
+
+	if len(sources) != 2 {
+		t.Fatalf("Expected 2 sources, got %d", len(sources))
+	}
+
+	if sources[0].Name != "source-a" || sources[1].Type != "plain" {
+		t.Errorf("Unexpected sources: %+v", sources)
+	}
+
+	if settings != (SourceSettings{}) {
+		t.Errorf("Expected empty settings for bare list, got %+v", settings)
+	}
+}
+
+// TestLoadSourcesWrapped tests loading a sources file with a settings block
+func TestLoadSourcesWrapped(t *testing.T) {
+	path := writeTestFile(t, "sources.yaml", `
+settings:
+  format: singbox
+  max: 200
+  concurrency: 4
+sources:
+  - name: source-a
+    url: https://example.com/a
+    type: base64
+    enabled: true
+`)
+
+	sources, settings, err := loadSources(path)
+	if err != nil {
+		t.Fatalf("Failed to load wrapped sources: %v", err)
+	}
+
+	if len(sources) != 1 || sources[0].Name != "source-a" {
+		t.Errorf("Unexpected sources: %+v", sources)
+	}
+
+	expected := SourceSettings{Format: "singbox", Max: 200, Concurrency: 4}
+	if settings != expected {
+		t.Errorf("Expected settings %+v, got %+v", expected, settings)
+	}
+}
+
+// TestLoadSourcesMaxBodyBytesPlainOnly tests that max_body_bytes is rejected
+// for sources that are not line-based
+func TestLoadSourcesMaxBodyBytesPlainOnly(t *testing.T) {
+	for _, sourceType := range []string{"base64", "json"} {
+		path := writeTestFile(t, "sources.yaml", `
+- name: single-line
+  url: https://example.com/sub
+  type: `+sourceType+`
+  enabled: true
+  max_body_bytes: 1024
+`)
+
+		if _, _, err := loadSources(path); err == nil || !strings.Contains(err.Error(), "max_body_bytes") {
+			t.Errorf("Expected a max_body_bytes error for a %s source, got %v", sourceType, err)
+		}
+	}
+}
+
+// TestConfigKeyDistinct tests that distinct configs get distinct keys
+func TestConfigKeyDistinct(t *testing.T) {
+	base := &Config{Protocol: "vless", Server: "server.com", Port: 443, UUID: "uuid-1"}
+	variants := []*Config{
+		{Protocol: "vless", Server: "other.com", Port: 443, UUID: "uuid-1"},
+		{Protocol: "vless", Server: "server.com", Port: 8443, UUID: "uuid-1"},
+		{Protocol: "vless", Server: "server.com", Port: 443, UUID: "uuid-2"},
+		{Protocol: "trojan", Server: "server.com", Port: 443, Password: "uuid-1"},
+		{Protocol: "vless", Server: "server.com", Port: 443, UUID: "uuid-1", PublicKey: "pbk"},
+	}
+
+	for _, variant := range variants {
+		if variant.Key() == base.Key() {
+			t.Errorf("Expected distinct keys for %+v and %+v", base, variant)
+		}
+	}
+}
+
+// TestConfigKeyPluginAndAlterId tests that nodes differing only in their
+// Shadowsocks plugin or VMess alterId get distinct keys, while configs
+// without either keep the same key as before
+func TestConfigKeyPluginAndAlterId(t *testing.T) {
+	parser := NewProtocolParser()
+	obfs, err := parser.ParseConfig("ss://aes-256-gcm:pass@ss.example.com:8388?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Da.com#A", "test-source")
+	if err != nil {
+		t.Fatalf("Failed to parse obfs link: %v", err)
+	}
+	v2ray, err := parser.ParseConfig("ss://aes-256-gcm:pass@ss.example.com:8388?plugin=v2ray-plugin%3Bmode%3Dwebsocket%3Bhost%3Db.com#B", "test-source")
+	if err != nil {
+		t.Fatalf("Failed to parse v2ray-plugin link: %v", err)
+	}
+	if obfs.Key() == v2ray.Key() {
+		t.Errorf("Expected distinct keys for plugins %q and %q", obfs.Plugin, v2ray.Plugin)
+	}
+
+	aead := &Config{Protocol: "vmess", Server: "vmess.example.com", Port: 443, UUID: "uuid-1", Cipher: "auto"}
+	legacy := &Config{Protocol: "vmess", Server: "vmess.example.com", Port: 443, UUID: "uuid-1", Cipher: "auto", AlterId: 64}
+	if aead.Key() == legacy.Key() {
+		t.Errorf("Expected distinct keys for alterId 0 and 64")
+	}
+
+	// The key of a config with neither must not change
+	plain := &Config{Protocol: "ss", Server: "ss.example.com", Port: 8388, Method: "aes-256-gcm", Cipher: "aes-256-gcm", Password: "pass"}
+	if got := plain.Key(); got != "159134b3cb1ac8f4027820e8107d74c96701083f1ca12de1d2e1e7b1aece4113" {
+		t.Errorf("Expected the key of a config without plugin or alterId unchanged, got %s", got)
+	}
+}
+
+// TestConfigKeyIdentical tests that identical configs share a key
+func TestConfigKeyIdentical(t *testing.T) {
+	a := &Config{ID: "vless-1", Protocol: "vless", Server: "server.com", Port: 443, UUID: "uuid-1", Name: "Node A", Source: "source-a"}
+	b := &Config{ID: "vless-2", Protocol: "vless", Server: "server.com", Port: 443, UUID: "uuid-1", Name: "Node B", Source: "source-b"}
+
+	if a.Key() != b.Key() {
+		t.Errorf("Expected identical configs to share a key, got %s and %s", a.Key(), b.Key())
+	}
+}
+
+// TestHostCaseDeduplicated tests that configs differing only in host case dedup to one
+func TestHostCaseDeduplicated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "vless://uuid-1@Example.COM:443?security=tls&sni=Example.COM")
+		fmt.Fprintln(w, "vless://uuid-1@example.com:443?security=tls&sni=example.com")
+	}))
+	defer server.Close()
+
+	sources := []ConfigSource{{Name: "mixed-case", URL: server.URL, Type: "plain", Enabled: true}}
+	agg := newTestAggregator(t, sources, 100)
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if len(configs) != 1 {
+		t.Fatalf("Expected 1 config after dedup, got %d", len(configs))
+	}
+
+	if configs[0].Server != "Example.COM" {
+		t.Errorf("Expected original host case Example.COM, got %s", configs[0].Server)
+	}
+}
+
+// TestConcurrentSetMeta tests concurrent metadata writes (run with -race)
+func TestConcurrentSetMeta(t *testing.T) {
+	cfg := &Config{Protocol: "vless", Server: "server.com", Port: 443}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key-%d", n)
+			cfg.SetMeta(key, "value")
+			_ = cfg.GetMeta(key)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 50; i++ {
+		if cfg.GetMeta(fmt.Sprintf("key-%d", i)) != "value" {
+			t.Errorf("Expected key-%d to be set", i)
+		}
+	}
+}
+
+// newTestAggregator builds an aggregator for the given sources with no rules
+func newTestAggregator(t *testing.T, sources []ConfigSource, maxConfigs int) *Aggregator {
+	t.Helper()
+
+	data, err := yaml.Marshal(sources)
+	if err != nil {
+		t.Fatalf("Failed to encode sources: %v", err)
+	}
+
+	sourcesFile := writeTestFile(t, "sources.yaml", string(data))
+	rulesFile := writeTestFile(t, "rules.json", "[]")
+
+	agg, err := NewAggregator(sourcesFile, rulesFile, maxConfigs)
+	if err != nil {
+		t.Fatalf("Failed to create aggregator: %v", err)
+	}
+	return agg
+}
+
+// TestNoCacheRefetches tests that -no-cache re-requests a source despite a warm cache
+func TestNoCacheRefetches(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		fmt.Fprintln(w, "vless://uuid@server.com:443")
+	}))
+	defer server.Close()
+
+	sources := []ConfigSource{{Name: "stub", URL: server.URL, Type: "plain", Enabled: true}}
+
+	cached := newTestAggregator(t, sources, 100)
+	for i := 0; i < 2; i++ {
+		if _, err := cached.FetchAndProcessConfigs(); err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Fatalf("Expected cached aggregator to fetch once, got %d", got)
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	uncached := newTestAggregator(t, sources, 100)
+	uncached.SetNoCache(true)
+	for i := 0; i < 2; i++ {
+		if _, err := uncached.FetchAndProcessConfigs(); err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Errorf("Expected -no-cache aggregator to fetch twice, got %d", got)
+	}
+}
+
+// TestFetchResetsPerRunState tests that a second fetch on the same
+// aggregator returns only that run's configs and duplicate count
+func TestFetchResetsPerRunState(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			fmt.Fprintln(w, "vless://uuid@one.com:443\nvless://uuid@one.com:443\nvless://uuid@two.com:443")
+			return
+		}
+		fmt.Fprintln(w, "vless://uuid@three.com:443")
+	}))
+	defer server.Close()
+
+	agg := newTestAggregator(t, []ConfigSource{{Name: "stub", URL: server.URL, Type: "plain", Enabled: true}}, 100)
+	agg.SetNoCache(true)
+
+	if _, err := agg.FetchAndProcessConfigs(); err != nil {
+		t.Fatalf("First fetch failed: %v", err)
+	}
+	if agg.Duplicates() != 1 {
+		t.Fatalf("Expected 1 duplicate in the first fetch, got %d", agg.Duplicates())
+	}
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Second fetch failed: %v", err)
+	}
+	if len(configs) != 1 || configs[0].Server != "three.com" {
+		servers := make([]string, 0, len(configs))
+		for _, cfg := range configs {
+			servers = append(servers, cfg.Server)
+		}
+		t.Errorf("Expected only three.com from the second fetch, got %v", servers)
+	}
+	if agg.Duplicates() != 0 {
+		t.Errorf("Expected no duplicates in the second fetch, got %d", agg.Duplicates())
+	}
+}
+
+// makeTestConfigs creates n distinct configs for a source
+func makeTestConfigs(source string, n int) []*Config {
+	configs := make([]*Config, 0, n)
+	for i := 0; i < n; i++ {
+		configs = append(configs, &Config{
+			ID:       fmt.Sprintf("%s-%d", source, i),
+			Protocol: "vless",
+			Server:   fmt.Sprintf("server-%d.%s.com", i, source),
+			Port:     443,
+			UUID:     fmt.Sprintf("uuid-%d", i),
+			Name:     fmt.Sprintf("%s %d", source, i),
+			Source:   source,
+		})
+	}
+	return configs
+}
+
+// TestEarlyMaxNoDeadlock tests that capping below a large config set doesn't block producers (run with -race)
+func TestEarlyMaxNoDeadlock(t *testing.T) {
+	sources := []ConfigSource{
+		{Name: "big-a", URL: "http://127.0.0.1:1", Type: "plain", Enabled: true},
+		{Name: "big-b", URL: "http://127.0.0.1:1", Type: "plain", Enabled: true},
+	}
+
+	agg := newTestAggregator(t, sources, 50)
+	agg.cache.Set("big-a", makeTestConfigs("big-a", 2500))
+	agg.cache.Set("big-b", makeTestConfigs("big-b", 2500))
+
+	done := make(chan []*Config, 1)
+	go func() {
+		configs, err := agg.FetchAndProcessConfigs()
+		if err != nil {
+			t.Errorf("Fetch failed: %v", err)
+		}
+		done <- configs
+	}()
+
+	select {
+	case configs := <-done:
+		if len(configs) != 50 {
+			t.Errorf("Expected 50 configs, got %d", len(configs))
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("FetchAndProcessConfigs deadlocked after reaching max configs")
+	}
+}
+
+// TestEarlyMaxNoGoroutineLeak tests that producers exit after an early-max generation
+func TestEarlyMaxNoGoroutineLeak(t *testing.T) {
+	sources := []ConfigSource{
+		{Name: "big-a", URL: "http://127.0.0.1:1", Type: "plain", Enabled: true},
+		{Name: "big-b", URL: "http://127.0.0.1:1", Type: "plain", Enabled: true},
+		{Name: "big-c", URL: "http://127.0.0.1:1", Type: "plain", Enabled: true},
+	}
+
+	agg := newTestAggregator(t, sources, 10)
+	for _, source := range sources {
+		agg.cache.Set(source.Name, makeTestConfigs(source.Name, 3000))
+	}
+
+	before := runtime.NumGoroutine()
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(configs) != 10 {
+		t.Errorf("Expected 10 configs, got %d", len(configs))
+	}
+
+	// Allow the channel-closing goroutine to finish
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Leaked goroutines after early max: before=%d after=%d", before, after)
+	}
+}
+
+// TestEmptyResultRetried tests that an empty 200 is retried and not cached
+func TestEmptyResultRetried(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			return
+		}
+		fmt.Fprintln(w, "vless://uuid-1@server1.com:443")
+		fmt.Fprintln(w, "trojan://pass@server2.com:443")
+	}))
+	defer server.Close()
+
+	sources := []ConfigSource{{Name: "flaky", URL: server.URL, Type: "plain", Enabled: true}}
+	agg := newTestAggregator(t, sources, 100)
+	agg.emptyRetryWait = 10 * time.Millisecond
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if len(configs) != 2 {
+		t.Errorf("Expected the full result with 2 configs, got %d", len(configs))
+	}
+
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Errorf("Expected 2 requests (empty then full), got %d", got)
+	}
+
+	cached, ok := agg.cache.Get("flaky").([]*Config)
+	if !ok || len(cached) != 2 {
+		t.Errorf("Expected the full result to be cached, got %v", agg.cache.Get("flaky"))
+	}
+}
+
+// TestSaveRulesRoundTrip tests that saved rules load back unchanged and are written deterministically
+func TestSaveRulesRoundTrip(t *testing.T) {
+	rules := []FilterRule{
+		{Name: "Block ads", Type: "domain", Pattern: "ads.example.com", Action: "exclude", Enabled: true},
+		{Name: "Iran only", Type: "country", Pattern: "IR", Action: "include", Enabled: false},
+	}
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := SaveRules(path, rules); err != nil {
+		t.Fatalf("Failed to save rules: %v", err)
+	}
+
+	loaded, err := loadRules(path)
+	if err != nil {
+		t.Fatalf("Failed to load saved rules: %v", err)
+	}
+
+	if !reflect.DeepEqual(loaded, rules) {
+		t.Errorf("Expected %+v, got %+v", rules, loaded)
+	}
+
+	first, _ := os.ReadFile(path)
+	if err := SaveRules(path, loaded); err != nil {
+		t.Fatalf("Failed to re-save rules: %v", err)
+	}
+	second, _ := os.ReadFile(path)
+
+	if string(first) != string(second) {
+		t.Errorf("Expected identical output on re-save, got:\n%s\nand:\n%s", first, second)
+	}
+
+	if !strings.HasPrefix(string(first), "[\n  {") || !strings.HasSuffix(string(first), "\n") {
+		t.Errorf("Expected indented JSON with a trailing newline, got:\n%s", first)
+	}
+}
+
+// TestLoadRulesMerge tests that later rules files override earlier rules by name
+func TestLoadRulesMerge(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.json")
+	overrides := filepath.Join(dir, "rules.d", "extra.json")
+
+	if err := os.MkdirAll(filepath.Dir(overrides), 0755); err != nil {
+		t.Fatalf("Failed to create rules dir: %v", err)
+	}
+	if err := os.WriteFile(base, []byte(`[
+		{"name": "Block ads", "type": "domain", "pattern": "ads.example.com", "action": "exclude", "enabled": true},
+		{"name": "Include VMess", "type": "protocol", "pattern": "vmess", "action": "include", "enabled": true}
+	]`), 0644); err != nil {
+		t.Fatalf("Failed to write base rules: %v", err)
+	}
+	if err := os.WriteFile(overrides, []byte(`[
+		{"name": "Block ads", "type": "domain", "pattern": "ads.example.com", "action": "exclude", "enabled": false},
+		{"name": "Iran only", "type": "country", "pattern": "IR", "action": "include", "enabled": true}
+	]`), 0644); err != nil {
+		t.Fatalf("Failed to write override rules: %v", err)
+	}
+
+	rules, err := loadRules(base + "," + filepath.Join(dir, "rules.d", "*.json"))
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+
+	expected := []FilterRule{
+		{Name: "Block ads", Type: "domain", Pattern: "ads.example.com", Action: "exclude", Enabled: false},
+		{Name: "Include VMess", Type: "protocol", Pattern: "vmess", Action: "include", Enabled: true},
+		{Name: "Iran only", Type: "country", Pattern: "IR", Action: "include", Enabled: true},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, rules)
+	}
+
+	if _, err := loadRules(filepath.Join(dir, "missing", "*.json")); err == nil {
+		t.Errorf("Expected error for a glob matching no files")
+	}
+}
+
+// TestStdinSource tests that -sources - parses links piped on stdin
+func TestStdinSource(t *testing.T) {
+	rulesFile := writeTestFile(t, "rules.json", "[]")
+
+	agg, err := NewAggregator("-", rulesFile, 100)
+	if err != nil {
+		t.Fatalf("Failed to create stdin aggregator: %v", err)
+	}
+	agg.stdin = strings.NewReader(strings.Join([]string{
+		"vless://uuid-1@server1.com:443?security=tls&sni=server1.com",
+		"trojan://pass@server2.com:443?sni=server2.com",
+		"ss://aes-256-gcm:pass@server3.com:8388",
+	}, "\n"))
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if len(configs) != 3 {
+		t.Fatalf("Expected 3 configs from stdin, got %d", len(configs))
+	}
+
+	sub, err := NewSubscriptionGenerator("clash-meta").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	for _, server := range []string{"server1.com", "server2.com", "server3.com"} {
+		if !strings.Contains(sub, server) {
+			t.Errorf("Expected generated output to include %s", server)
+		}
+	}
+}
+
+// TestProgressPerSource tests that the progress callback fires once per completed source
+func TestProgressPerSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "vless://uuid@%s.com:443\n", r.URL.Path[1:])
+	}))
+	defer server.Close()
+
+	sources := []ConfigSource{
+		{Name: "a", URL: server.URL + "/a", Type: "plain", Enabled: true},
+		{Name: "b", URL: server.URL + "/b", Type: "plain", Enabled: true},
+		{Name: "c", URL: server.URL + "/c", Type: "plain", Enabled: true},
+		{Name: "disabled", URL: server.URL + "/d", Type: "plain", Enabled: false},
+	}
+
+	agg := newTestAggregator(t, sources, 100)
+
+	var events []FetchProgress
+	agg.SetProgress(func(p FetchProgress) {
+		events = append(events, p)
+	})
+
+	if _, err := agg.FetchAndProcessConfigs(); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 progress events, got %d: %+v", len(events), events)
+	}
+
+	seen := make(map[string]bool)
+	for i, event := range events {
+		if event.Completed != i+1 || event.Total != 3 {
+			t.Errorf("Expected progress %d/3, got %d/%d", i+1, event.Completed, event.Total)
+		}
+		if seen[event.Source] {
+			t.Errorf("Progress reported twice for %s", event.Source)
+		}
+		seen[event.Source] = true
+	}
+}
+
+// TestUnsupportedSchemeCounted tests that wireguard links are counted as unsupported rather than failing the source
+func TestUnsupportedSchemeCounted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "wireguard://privatekey@wg.example.com:51820?publickey=abc")
+		fmt.Fprintln(w, "vless://uuid@server.com:443")
+	}))
+	defer server.Close()
+
+	sources := []ConfigSource{{Name: "mixed", URL: server.URL, Type: "plain", Enabled: true}}
+	agg := newTestAggregator(t, sources, 100)
+	agg.SetNoCache(true)
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if len(configs) != 1 {
+		t.Errorf("Expected the vless config to be kept, got %d configs", len(configs))
+	}
+
+	if got := agg.Unsupported()["wireguard"]; got != 1 {
+		t.Errorf("Expected 1 unsupported wireguard link, got %d", got)
+	}
+
+	// A second run counts afresh rather than adding to the first
+	if _, err := agg.FetchAndProcessConfigs(); err != nil {
+		t.Fatalf("Second fetch failed: %v", err)
+	}
+	if got := agg.Unsupported()["wireguard"]; got != 1 {
+		t.Errorf("Expected 1 unsupported wireguard link after a second run, got %d", got)
+	}
+
+	if _, err := agg.parser.ParseConfig("wireguard://key@wg.example.com:51820", "test"); !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("Expected ErrUnsupportedScheme, got %v", err)
+	}
+}
+
+// TestMinSourcesAgreement tests that -min-sources keeps only nodes mirrored by enough sources
+func TestMinSourcesAgreement(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "vless://uuid-shared@shared.com:443")
+		if r.URL.Path == "/a" {
+			fmt.Fprintln(w, "vless://uuid-lonely@lonely.com:443")
+		}
+	}))
+	defer server.Close()
+
+	sources := []ConfigSource{
+		{Name: "a", URL: server.URL + "/a", Type: "plain", Enabled: true},
+		{Name: "b", URL: server.URL + "/b", Type: "plain", Enabled: true},
+		{Name: "c", URL: server.URL + "/c", Type: "plain", Enabled: true},
+	}
+
+	agg := newTestAggregator(t, sources, 100)
+	agg.SetMinSources(2)
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if len(configs) != 1 {
+		t.Fatalf("Expected only the shared node, got %d configs", len(configs))
+	}
+
+	if configs[0].Server != "shared.com" {
+		t.Errorf("Expected shared.com to be kept, got %s", configs[0].Server)
+	}
+}
+
+// TestHTMLErrorPageRejected tests that an HTML page served with 200 is a source error and is not cached
+func TestHTMLErrorPageRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintln(w, "<!DOCTYPE html><html><body>Access denied vless://uuid@trap.com:443</body></html>")
+	}))
+	defer server.Close()
+
+	source := ConfigSource{Name: "portal", URL: server.URL, Type: "plain", Enabled: true}
+	agg := newTestAggregator(t, []ConfigSource{source}, 100)
+
+	configsChan := make(chan *Config, 10)
+	if err := agg.fetchFromSource(source, configsChan, make(chan struct{})); err == nil {
+		t.Errorf("Expected an error for an HTML page")
+	}
+
+	if len(configsChan) != 0 {
+		t.Errorf("Expected no configs from an HTML page, got %d", len(configsChan))
+	}
+
+	if cached := agg.cache.Get("portal"); cached != nil {
+		t.Errorf("Expected HTML response not to be cached, got %v", cached)
+	}
+
+	if looksLikeHTML("text/html", []byte("vless://uuid@server.com:443")) {
+		t.Errorf("A link list served as text/html should not be treated as HTML")
+	}
+}
+
+// TestFetchOrderStable tests that fetched configs come back in source order on every run
+func TestFetchOrderStable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(w, "vless://uuid-%d@%s-%d.com:443\n", i, r.URL.Path[1:], i)
+		}
+	}))
+	defer server.Close()
+
+	sources := []ConfigSource{
+		{Name: "b", URL: server.URL + "/b", Type: "plain", Enabled: true},
+		{Name: "a", URL: server.URL + "/a", Type: "plain", Enabled: true},
+		{Name: "c", URL: server.URL + "/c", Type: "plain", Enabled: true},
+	}
+
+	var expected []string
+	for _, source := range []string{"b", "a", "c"} {
+		for i := 0; i < 5; i++ {
+			expected = append(expected, fmt.Sprintf("%s-%d.com", source, i))
+		}
+	}
+
+	for run := 0; run < 5; run++ {
+		agg := newTestAggregator(t, sources, 100)
+		configs, err := agg.FetchAndProcessConfigs()
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+
+		var servers []string
+		for _, cfg := range configs {
+			servers = append(servers, cfg.Server)
+		}
+
+		if !reflect.DeepEqual(servers, expected) {
+			t.Fatalf("Run %d: expected %v, got %v", run, expected, servers)
+		}
+	}
+}
+
+// TestFailFastReturnsSourceError tests that -fail-fast aborts the fetch with the failing source's error
+func TestFailFastReturnsSourceError(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// The healthy source stalls until the test ends, so a fetch that
+		// waited for it rather than failing fast would time out
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	sources := []ConfigSource{
+		{Name: "broken", URL: server.URL + "/broken", Type: "plain", Enabled: true},
+		{Name: "slow", URL: server.URL + "/slow", Type: "plain", Enabled: true},
+	}
+	agg := newTestAggregator(t, sources, 100)
+	agg.SetNoCache(true)
+	agg.SetFailFast(true)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := agg.FetchAndProcessConfigs()
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "broken") {
+			t.Errorf("Expected the broken source's error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected fetch to fail fast on the broken source")
+	}
+}
+
+// TestMaxBodyBytes tests that a max_body_bytes source parses only the prefix and drops the cut last line
+func TestMaxBodyBytes(t *testing.T) {
+	lines := []string{
+		"vless://uuid-1@one.com:443",
+		"vless://uuid-2@two.com:443",
+		"vless://uuid-3@three.com:443",
+		"vless://uuid-4@four.com:443",
+	}
+	body := strings.Join(lines, "\n") + "\n"
+	// Cut inside the third line, right after "three.com:4"
+	limit := int64(strings.Index(body, "three.com:4") + len("three.com:4"))
+
+	for _, honorRange := range []bool{true, false} {
+		var rangeHeader string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rangeHeader = r.Header.Get("Range")
+			if !honorRange {
+				fmt.Fprint(w, body)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", limit-1, len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+			fmt.Fprint(w, body[:limit])
+		}))
+
+		source := ConfigSource{Name: "huge", URL: server.URL, Type: "plain", Enabled: true, MaxBodyBytes: limit}
+		agg := newTestAggregator(t, []ConfigSource{source}, 100)
+		agg.SetNoCache(true)
+
+		configs, err := agg.FetchAndProcessConfigs()
+		server.Close()
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+
+		if expected := fmt.Sprintf("bytes=0-%d", limit-1); rangeHeader != expected {
+			t.Errorf("Expected Range header %q, got %q", expected, rangeHeader)
+		}
+
+		var servers []string
+		for _, cfg := range configs {
+			servers = append(servers, cfg.Server)
+		}
+		if !reflect.DeepEqual(servers, []string{"one.com", "two.com"}) {
+			t.Errorf("Expected only the complete lines before the cut (honorRange=%v), got %v", honorRange, servers)
+		}
+	}
+}
+
+// TestNestedSubscriptionURL tests following a source whose body is only the
+// URL of another subscription, and stopping at a self-referencing one
+func TestNestedSubscriptionURL(t *testing.T) {
+	sourceB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "vless://uuid-1@one.example.com:443#One")
+		fmt.Fprintln(w, "vless://uuid-2@two.example.com:443#Two")
+	}))
+	defer sourceB.Close()
+
+	sourceA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "\n%s/sub\n", sourceB.URL)
+	}))
+	defer sourceA.Close()
+
+	agg := newTestAggregator(t, []ConfigSource{{Name: "a", URL: sourceA.URL, Type: "plain", Enabled: true}}, 100)
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("Expected source B's 2 configs through source A, got %d", len(configs))
+	}
+	if configs[0].Server != "one.example.com" || configs[1].Server != "two.example.com" {
+		t.Errorf("Expected source B's servers, got %s and %s", configs[0].Server, configs[1].Server)
+	}
+
+	var loop *httptest.Server
+	loop = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, loop.URL)
+	}))
+	defer loop.Close()
+
+	source := ConfigSource{Name: "loop", URL: loop.URL, Type: "plain", Enabled: true}
+	if _, err := agg.fetchSourceConfigs(source); err == nil {
+		t.Errorf("Expected a self-referencing subscription to stop with an error")
+	}
+
+	if _, ok := nestedSubscriptionURL([]byte("https://a.example.com/sub\nvless://uuid@b.example.com:443")); ok {
+		t.Errorf("A body with links after the URL is not a nested subscription")
+	}
+}
+
+// TestDedupKeepsHigherPriority tests that a duplicate from a higher-priority
+// source replaces one a lower-priority source delivered first
+func TestDedupKeepsHigherPriority(t *testing.T) {
+	low := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "vless://uuid-1@shared.example.com:443#Low")
+	}))
+	defer low.Close()
+
+	high := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprintln(w, "vless://uuid-1@shared.example.com:443#High")
+	}))
+	defer high.Close()
+
+	agg := newTestAggregator(t, []ConfigSource{
+		{Name: "low", URL: low.URL, Type: "plain", Enabled: true, Priority: 1},
+		{Name: "high", URL: high.URL, Type: "plain", Enabled: true, Priority: 10},
+	}, 100)
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(configs) != 1 {
+		t.Fatalf("Expected duplicates to collapse to 1 config, got %d", len(configs))
+	}
+	if configs[0].Source != "high" || configs[0].Name != "High" {
+		t.Errorf("Expected the high-priority source's copy, got %q from %q", configs[0].Name, configs[0].Source)
+	}
+}
+
+// TestParseErrorsPerRun tests that each fetch counts its own parse errors
+func TestParseErrorsPerRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "not a share link")
+		fmt.Fprintln(w, "vless://uuid@server.com:443")
+	}))
+	defer server.Close()
+
+	sources := []ConfigSource{{Name: "broken", URL: server.URL, Type: "plain", Enabled: true}}
+	agg := newTestAggregator(t, sources, 100)
+	agg.SetNoCache(true)
+
+	for run := 1; run <= 2; run++ {
+		if _, err := agg.FetchAndProcessConfigs(); err != nil {
+			t.Fatalf("Fetch %d failed: %v", run, err)
+		}
+		if n := agg.ParseErrors()["broken"]; n != 1 {
+			t.Errorf("Expected 1 parse error after run %d, got %d", run, n)
+		}
+	}
+}
+
+// TestParsePlainConfigs tests that blank, comment and unparseable lines are
+// skipped, with the failures logged only when verbose
+func TestParsePlainConfigs(t *testing.T) {
+	body := []byte(strings.Join([]string{
+		"# exported nodes",
+		"vless://uuid-1@one.example.com:443#One",
+		"",
+		"   ",
+		"not a share link",
+		"  trojan://pass@two.example.com:443#Two  ",
+	}, "\n"))
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	agg := newTestAggregator(t, nil, 100)
+	configs, err := agg.parsePlainConfigs(body, "test")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(configs) != 2 || configs[0].Server != "one.example.com" || configs[1].Server != "two.example.com" {
+		t.Fatalf("Expected the 2 valid links, got %d configs", len(configs))
+	}
+	if configs[0].Source != "test" {
+		t.Errorf("Expected source test, got %q", configs[0].Source)
+	}
+	if n := agg.ParseErrors()["test"]; n != 1 {
+		t.Errorf("Expected 1 parse error, got %d", n)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected no parse failure logs without verbose, got %q", logs.String())
+	}
+
+	agg.SetVerbose(true)
+	if _, err := agg.parsePlainConfigs(body, "test"); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if !strings.Contains(logs.String(), "Skipping unparseable link from test") {
+		t.Errorf("Expected the failed line to be logged when verbose, got %q", logs.String())
+	}
+}
+
+// TestSourceHeaders tests that a source's custom headers are sent, and that
+// credential values are redacted when logged
+func TestSourceHeaders(t *testing.T) {
+	var referer, apiKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		referer = r.Header.Get("Referer")
+		apiKey = r.Header.Get("X-Api-Key")
+		fmt.Fprintln(w, "vless://uuid-1@one.example.com:443#One")
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	agg := newTestAggregator(t, []ConfigSource{{
+		Name:    "headers",
+		URL:     server.URL,
+		Type:    "plain",
+		Enabled: true,
+		Headers: map[string]string{"Referer": "https://example.com/", "X-Api-Key": "s3cr3t"},
+	}}, 100)
+	agg.SetVerbose(true)
+
+	if _, err := agg.FetchAndProcessConfigs(); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if referer != "https://example.com/" || apiKey != "s3cr3t" {
+		t.Errorf("Expected the configured headers, got Referer %q and X-Api-Key %q", referer, apiKey)
+	}
+
+	if strings.Contains(logs.String(), "s3cr3t") {
+		t.Errorf("Expected the API key to be redacted in logs, got %q", logs.String())
+	}
+	if !strings.Contains(logs.String(), "Referer: https://example.com/, X-Api-Key: REDACTED") {
+		t.Errorf("Expected the headers logged with the key redacted, got %q", logs.String())
+	}
+}
+
+// TestParseJSONConfigs tests array, single object and proxies/outbounds
+// bodies, with malformed entries skipped
+func TestParseJSONConfigs(t *testing.T) {
+	vless := `{"protocol": "vless", "server": "one.example.com", "port": 443, "uuid": "uuid-1", "name": "One"}`
+	trojan := `{"type": "trojan", "server": "two.example.com", "port": 443, "password": "pass", "name": "Two"}`
+	malformed := `{"protocol": "vless", "server": "bad.example.com"}`
+
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{"array", "[" + vless + "," + malformed + "," + trojan + "]", []string{"one.example.com", "two.example.com"}},
+		{"object", vless, []string{"one.example.com"}},
+		{"proxies", `{"proxies": [` + trojan + `,` + malformed + `]}`, []string{"two.example.com"}},
+		{"outbounds", `{"log": {}, "outbounds": [{"type": "vless", "tag": "One", "server": "one.example.com", "server_port": 443, "uuid": "uuid-1"}, {"type": "direct", "tag": "direct"}]}`, []string{"one.example.com"}},
+	}
+
+	for _, tt := range tests {
+		agg := newTestAggregator(t, nil, 100)
+		configs, err := agg.parseJSONConfigs([]byte(tt.body), "json-source")
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tt.name, err)
+		}
+
+		var servers []string
+		for _, cfg := range configs {
+			servers = append(servers, cfg.Server)
+			if cfg.Source != "json-source" {
+				t.Errorf("%s: expected source json-source, got %q", tt.name, cfg.Source)
+			}
+		}
+		if !reflect.DeepEqual(servers, tt.expected) {
+			t.Errorf("%s: expected servers %v, got %v", tt.name, tt.expected, servers)
+		}
+	}
+
+	agg := newTestAggregator(t, nil, 100)
+	if _, err := agg.parseJSONConfigs([]byte(`"just a string"`), "json-source"); err == nil {
+		t.Errorf("Expected an error for a JSON body that is neither an object nor an array")
+	}
+}
+
+// TestParseBase64Configs tests decoding a multi-line subscription served as
+// padded, unpadded, URL-safe and line-wrapped base64
+func TestParseBase64Configs(t *testing.T) {
+	subscription := strings.Join([]string{
+		"vless://uuid-1@one.example.com:443?security=tls&sni=one.example.com&alpn=h2,http/1.1#One",
+		"trojan://pass@two.example.com:443?sni=two.example.com#Two~",
+		"ss://aes-256-gcm:secret@three.example.com:8388#Three",
+	}, "\n")
+
+	std := base64.StdEncoding.EncodeToString([]byte(subscription))
+	if !strings.ContainsAny(std, "+/") || !strings.HasSuffix(std, "=") {
+		t.Fatalf("Expected the subscription to need padding and the standard-only +/ characters, got %s", std)
+	}
+	var wrapped strings.Builder
+	for i := 0; i < len(std); i += 76 {
+		wrapped.WriteString(std[i:min(i+76, len(std))] + "\r\n")
+	}
+
+	bodies := map[string]string{
+		"std":     std,
+		"raw":     base64.RawStdEncoding.EncodeToString([]byte(subscription)),
+		"url":     base64.URLEncoding.EncodeToString([]byte(subscription)),
+		"raw-url": base64.RawURLEncoding.EncodeToString([]byte(subscription)),
+		"wrapped": wrapped.String(),
+	}
+
+	agg := newTestAggregator(t, nil, 100)
+	for name, body := range bodies {
+		configs, err := agg.parseBase64Configs([]byte(body), "b64")
+		if err != nil {
+			t.Errorf("%s: parse failed: %v", name, err)
+			continue
+		}
+
+		var servers []string
+		for _, cfg := range configs {
+			servers = append(servers, cfg.Server)
+		}
+		expected := []string{"one.example.com", "two.example.com", "three.example.com"}
+		if !reflect.DeepEqual(servers, expected) {
+			t.Errorf("%s: expected servers %v, got %v", name, expected, servers)
+		}
+	}
+
+	if _, err := agg.parseBase64Configs([]byte("not base64 at all!"), "b64"); err == nil {
+		t.Errorf("Expected an error for a body that is not base64")
+	}
+}
+
+// TestDumpRaw tests that -dump-raw writes each source's exact response body
+// before parsing, even when none of it parses
+func TestDumpRaw(t *testing.T) {
+	bodies := map[string]string{
+		"good":      "vless://uuid-1@one.example.com:443#One\r\n\r\n",
+		"bad/slash": "<not> a subscription \x00\xff",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/good", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, bodies["good"])
+	})
+	mux.HandleFunc("/bad", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, bodies["bad/slash"])
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	agg := newTestAggregator(t, []ConfigSource{
+		{Name: "good", URL: server.URL + "/good", Type: "plain", Enabled: true},
+		{Name: "bad/slash", URL: server.URL + "/bad", Type: "plain", Enabled: true},
+	}, 100)
+	agg.emptyRetryWait = 0
+
+	dir := filepath.Join(t.TempDir(), "raw")
+	agg.SetDumpRawDir(dir)
+	if _, err := agg.FetchAndProcessConfigs(); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	for name, body := range bodies {
+		data, err := os.ReadFile(rawDumpPath(dir, name))
+		if err != nil {
+			t.Fatalf("Expected a raw dump for %s: %v", name, err)
+		}
+		if string(data) != body {
+			t.Errorf("Expected the dump of %s to match the served bytes %q, got %q", name, body, data)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "bad_slash.raw")); err != nil {
+		t.Errorf("Expected a slash in the source name to stay inside the dump directory: %v", err)
+	}
+}
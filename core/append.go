@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// appendNewConfigs appends a share link line to path for each config whose
+// fingerprint is not already in the file, creating the file if needed. The
+// file itself is the seen set, so repeated runs grow a curated list without
+// rewriting earlier lines. New names are made unique against existing ones.
+// It returns the configs appended.
+func appendNewConfigs(path string, configs []*Config) ([]*Config, error) {
+	var existing []*Config
+	if _, err := os.Stat(path); err == nil {
+		if existing, err = readInputConfigs(path); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool, len(existing))
+	for _, cfg := range existing {
+		seen[cfg.Key()] = true
+	}
+
+	var added []*Config
+	for _, cfg := range configs {
+		key := cfg.Key()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		added = append(added, cfg)
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	// Existing names come first so only the new configs are renamed
+	uniquifyNames(append(existing, added...))
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if needsNewline, err := missingTrailingNewline(path); err != nil {
+		return nil, err
+	} else if needsNewline {
+		w.WriteString("\n")
+	}
+	for _, cfg := range added {
+		w.WriteString(cfg.String() + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	return added, nil
+}
+
+// dropSeenWithin removes configs the store first saw within window before
+// now, so a node that keeps reappearing is only emitted once per window,
+// then records every config as seen at now
+func dropSeenWithin(store *ConfigStore, configs []*Config, window time.Duration, now time.Time) ([]*Config, error) {
+	recent, err := store.FirstSeenSince(now.Add(-window))
+	if err != nil {
+		return nil, err
+	}
+
+	fresh := make([]*Config, 0, len(configs))
+	for _, cfg := range configs {
+		if !recent[cfg.Key()] {
+			fresh = append(fresh, cfg)
+		}
+	}
+
+	if err := store.Upsert(configs, now); err != nil {
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// missingTrailingNewline reports whether a non-empty file does not end in a
+// newline, so appended lines would join its last line
+func missingTrailingNewline(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read output file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return false, err
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return false, fmt.Errorf("failed to read output file: %w", err)
+	}
+	return last[0] != '\n', nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAppendOnlyNewConfigs tests that a second append run adds only the node it has not seen
+func TestAppendOnlyNewConfigs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "curated.txt")
+	parser := NewProtocolParser()
+
+	parse := func(links ...string) []*Config {
+		t.Helper()
+		var configs []*Config
+		for _, link := range links {
+			cfg, err := parser.ParseConfig(link, "test")
+			if err != nil {
+				t.Fatalf("Failed to parse %s: %v", link, err)
+			}
+			configs = append(configs, cfg)
+		}
+		return configs
+	}
+
+	first := parse("vless://uuid-a@a.example.com:443?security=tls&sni=a.example.com#Node",
+		"trojan://pass@b.example.com:443?sni=b.example.com#Trojan")
+	added, err := appendNewConfigs(path, first)
+	if err != nil {
+		t.Fatalf("First append failed: %v", err)
+	}
+	if len(added) != 2 {
+		t.Fatalf("Expected 2 configs on the first run, got %d", len(added))
+	}
+
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+
+	second := parse("vless://uuid-a@a.example.com:443?security=tls&sni=a.example.com#Node",
+		"vless://uuid-c@c.example.com:443?security=tls&sni=c.example.com#Node")
+	added, err = appendNewConfigs(path, second)
+	if err != nil {
+		t.Fatalf("Second append failed: %v", err)
+	}
+	if len(added) != 1 || added[0].Server != "c.example.com" {
+		t.Fatalf("Expected only c.example.com to be appended, got %v", added)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !strings.HasPrefix(string(after), string(before)) {
+		t.Errorf("Expected existing lines to be left untouched, got:\n%s", after)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(after)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines after the second run, got %d:\n%s", len(lines), after)
+	}
+	if !strings.HasSuffix(lines[2], "#Node%202") {
+		t.Errorf("Expected the new same-named node to be renamed Node 2, got %s", lines[2])
+	}
+}
+
+// TestDropSeenWithinWindow tests that a node first seen within the dedup
+// window is skipped while one first seen before it is emitted again
+func TestDropSeenWithinWindow(t *testing.T) {
+	store, err := OpenConfigStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	recent := &Config{ID: "recent", Protocol: "vless", Server: "recent.example.com", Port: 443, UUID: "uuid-r", Name: "Recent"}
+	old := &Config{ID: "old", Protocol: "trojan", Server: "old.example.com", Port: 443, Password: "pass", Name: "Old"}
+	unseen := &Config{ID: "new", Protocol: "ss", Server: "new.example.com", Port: 8388, Method: "aes-256-gcm", Password: "pass"}
+
+	if err := store.Upsert([]*Config{recent}, now.Add(-2*time.Hour)); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+	if err := store.Upsert([]*Config{old}, now.Add(-48*time.Hour)); err != nil {
+		t.Fatalf("Upsert failed: %v", err)
+	}
+
+	// A renamed copy is still the same node
+	renamed := recent.Clone()
+	renamed.Name = "Recent (renamed)"
+
+	fresh, err := dropSeenWithin(store, []*Config{renamed, old, unseen}, 24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("dropSeenWithin failed: %v", err)
+	}
+	if len(fresh) != 2 || fresh[0] != old || fresh[1] != unseen {
+		t.Fatalf("Expected the old and unseen nodes, got %d configs", len(fresh))
+	}
+
+	// The unseen node is now recorded, so the next run skips it too
+	fresh, err = dropSeenWithin(store, []*Config{unseen}, 24*time.Hour, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("dropSeenWithin failed: %v", err)
+	}
+	if len(fresh) != 0 {
+		t.Errorf("Expected a node first seen an hour ago to be skipped, got %d configs", len(fresh))
+	}
+}
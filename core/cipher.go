@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StatusInvalidCipher marks a config whose cipher does not belong to its
+// protocol, such as an ss config with the VMess cipher auto
+const StatusInvalidCipher = "invalid_cipher"
+
+// vmessCiphers are the VMess security values clients accept
+var vmessCiphers = map[string]bool{
+	"auto":              true,
+	"none":              true,
+	"zero":              true,
+	"aes-128-gcm":       true,
+	"chacha20-poly1305": true,
+}
+
+// ssMethods are the Shadowsocks methods clients accept: AEAD, 2022 and the
+// legacy stream ciphers still found in old subscriptions
+var ssMethods = map[string]bool{
+	"none":                          true,
+	"plain":                         true,
+	"aes-128-gcm":                   true,
+	"aes-192-gcm":                   true,
+	"aes-256-gcm":                   true,
+	"chacha20-ietf-poly1305":        true,
+	"xchacha20-ietf-poly1305":       true,
+	"2022-blake3-aes-128-gcm":       true,
+	"2022-blake3-aes-256-gcm":       true,
+	"2022-blake3-chacha20-poly1305": true,
+	"aes-128-cfb":                   true,
+	"aes-192-cfb":                   true,
+	"aes-256-cfb":                   true,
+	"aes-128-ctr":                   true,
+	"aes-192-ctr":                   true,
+	"aes-256-ctr":                   true,
+	"chacha20-ietf":                 true,
+	"xchacha20":                     true,
+	"rc4-md5":                       true,
+}
+
+// Ciphers spelled the other protocol's way, which clients reject but which
+// can only mean one thing
+var (
+	vmessCipherAliases = map[string]string{
+		"chacha20-ietf-poly1305": "chacha20-poly1305",
+	}
+	ssCipherAliases = map[string]string{
+		"chacha20-poly1305":  "chacha20-ietf-poly1305",
+		"xchacha20-poly1305": "xchacha20-ietf-poly1305",
+	}
+)
+
+// Validate checks that the cipher of a VMess or Shadowsocks config is one its
+// protocol supports. Obvious mistakes are normalized in place: case and
+// whitespace, and a ChaCha20 name spelled the other protocol's way. A cipher
+// that belongs to neither is flagged by setting ValidationStatus to
+// StatusInvalidCipher.
+func (c *Config) Validate() error {
+	cipher, err := c.checkCipher()
+	if err != nil {
+		c.ValidationStatus = StatusInvalidCipher
+		return err
+	}
+
+	switch c.Protocol {
+	case "vmess":
+		c.Cipher = cipher
+	case "ss", "shadowsocks":
+		c.Method = cipher
+		c.Cipher = cipher
+	}
+	return nil
+}
+
+// checkCipher returns the normalized cipher of a VMess or Shadowsocks
+// config, or an error if it does not belong to the protocol. Other
+// protocols have no cipher to check.
+func (c *Config) checkCipher() (string, error) {
+	switch c.Protocol {
+	case "vmess":
+		if c.Cipher == "" {
+			return "", nil
+		}
+		cipher := normalizeCipher(c.Cipher, vmessCipherAliases)
+		if !vmessCiphers[cipher] {
+			return "", fmt.Errorf("vmess config %s has cipher %q, which is not a VMess security", c.Name, c.Cipher)
+		}
+		return cipher, nil
+
+	case "ss", "shadowsocks":
+		method := c.Method
+		if method == "" {
+			method = c.Cipher
+		}
+		normalized := normalizeCipher(method, ssCipherAliases)
+		if !ssMethods[normalized] {
+			return "", fmt.Errorf("ss config %s has method %q, which is not a Shadowsocks method", c.Name, method)
+		}
+		return normalized, nil
+	}
+
+	return "", nil
+}
+
+// normalizeCipher lowercases and trims a cipher name, then applies aliases
+func normalizeCipher(cipher string, aliases map[string]string) string {
+	cipher = strings.ToLower(strings.TrimSpace(cipher))
+	if alias, ok := aliases[cipher]; ok {
+		return alias
+	}
+	return cipher
+}
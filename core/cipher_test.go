@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+// TestValidateCipher tests that ciphers belonging to another protocol are
+// flagged and that obvious spelling mistakes are normalized
+func TestValidateCipher(t *testing.T) {
+	mismatched := &Config{Protocol: "ss", Server: "ss.example.com", Port: 8388, Password: "p", Method: "auto", Cipher: "auto", Name: "Bad SS"}
+	if err := mismatched.Validate(); err == nil {
+		t.Errorf("Expected an error for ss with the VMess cipher auto")
+	}
+	if mismatched.ValidationStatus != StatusInvalidCipher {
+		t.Errorf("Expected status %q, got %q", StatusInvalidCipher, mismatched.ValidationStatus)
+	}
+
+	valid := &Config{Protocol: "ss", Server: "ss.example.com", Port: 8388, Password: "p", Method: " AES-256-GCM", Cipher: " AES-256-GCM", Name: "Good SS"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected aes-256-gcm to be valid for ss, got %v", err)
+	}
+	if valid.Method != "aes-256-gcm" || valid.Cipher != "aes-256-gcm" || valid.ValidationStatus != "" {
+		t.Errorf("Expected the method normalized and no status, got %q/%q status %q", valid.Method, valid.Cipher, valid.ValidationStatus)
+	}
+
+	aliased := &Config{Protocol: "ss", Method: "chacha20-poly1305", Name: "Aliased SS"}
+	if err := aliased.Validate(); err != nil || aliased.Method != "chacha20-ietf-poly1305" {
+		t.Errorf("Expected chacha20-poly1305 normalized to chacha20-ietf-poly1305, got %q (%v)", aliased.Method, err)
+	}
+
+	vmess := &Config{Protocol: "vmess", UUID: "uuid", Cipher: "aes-256-gcm", Name: "Bad VMess"}
+	if err := vmess.Validate(); err == nil || vmess.ValidationStatus != StatusInvalidCipher {
+		t.Errorf("Expected vmess with the ss cipher aes-256-gcm to be flagged, got status %q", vmess.ValidationStatus)
+	}
+}
+
+// TestInvalidCipherSkippedUnderStrictPolicy tests that a parsed link with a
+// mismatched cipher is flagged and left out of strict output
+func TestInvalidCipherSkippedUnderStrictPolicy(t *testing.T) {
+	cfg, err := NewProtocolParser().ParseConfig("ss://auto:secret@ss.example.com:8388#Auto", "test-source")
+	if err != nil {
+		t.Fatalf("Failed to parse SS URI: %v", err)
+	}
+	if cfg.ValidationStatus != StatusInvalidCipher {
+		t.Errorf("Expected status %q after parsing, got %q", StatusInvalidCipher, cfg.ValidationStatus)
+	}
+
+	if missing := missingFields(cfg, OutputPolicyStrict); len(missing) != 1 || missing[0] != "valid cipher" {
+		t.Errorf("Expected strict policy to require a valid cipher, got %v", missing)
+	}
+	if missing := missingFields(cfg, OutputPolicyLax); len(missing) != 0 {
+		t.Errorf("Expected lax policy to keep the config, got %v", missing)
+	}
+}
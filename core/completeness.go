@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Output policies controlling which configs are complete enough to publish
+const (
+	OutputPolicyStrict = "strict"
+	OutputPolicyLax    = "lax"
+)
+
+// requiredField is a field a config must carry to be published
+type requiredField struct {
+	name    string
+	present func(*Config) bool
+}
+
+var (
+	fieldServer   = requiredField{"server", func(c *Config) bool { return c.Server != "" }}
+	fieldPort     = requiredField{"port", func(c *Config) bool { return c.Port > 0 && c.Port <= 65535 }}
+	fieldUUID     = requiredField{"uuid", func(c *Config) bool { return c.UUID != "" }}
+	fieldPassword = requiredField{"password", func(c *Config) bool { return c.Password != "" }}
+	fieldMethod   = requiredField{"method", func(c *Config) bool { return c.Method != "" || c.Cipher != "" }}
+	fieldSNI      = requiredField{"sni", func(c *Config) bool { return c.TLSServerName != "" || c.ServerName != "" }}
+
+	// REALITY needs its public key, and the server name it borrows a certificate from
+	fieldRealityKey = requiredField{"pbk", func(c *Config) bool {
+		return c.PublicKey != "" || !strings.EqualFold(c.Security, "reality")
+	}}
+	fieldRealitySNI = requiredField{"sni", func(c *Config) bool {
+		return c.PublicKey == "" || c.ServerName != ""
+	}}
+
+	// VMess and Shadowsocks ciphers must belong to the protocol
+	fieldCipher = requiredField{"valid cipher", func(c *Config) bool {
+		_, err := c.checkCipher()
+		return err == nil
+	}}
+)
+
+// strictRequirements lists the fields each protocol needs under the strict policy
+var strictRequirements = map[string][]requiredField{
+	"vmess":       {fieldUUID, fieldCipher},
+	"vless":       {fieldUUID, fieldRealityKey, fieldRealitySNI},
+	"trojan":      {fieldPassword, fieldSNI},
+	"ss":          {fieldPassword, fieldMethod, fieldCipher},
+	"shadowsocks": {fieldPassword, fieldMethod, fieldCipher},
+}
+
+// missingFields returns the names of required fields a config lacks under
+// the given policy. The lax policy only requires a server and port.
+func missingFields(cfg *Config, policy string) []string {
+	required := []requiredField{fieldServer, fieldPort}
+	if policy == OutputPolicyStrict {
+		required = append(required, strictRequirements[cfg.Protocol]...)
+	}
+
+	var missing []string
+	for _, field := range required {
+		if !field.present(cfg) {
+			missing = append(missing, field.name)
+		}
+	}
+	return missing
+}
+
+// completeConfigs returns the configs that satisfy the output policy,
+// logging each one that is skipped
+func completeConfigs(configs []*Config, policy string) []*Config {
+	complete := make([]*Config, 0, len(configs))
+	for _, cfg := range configs {
+		if missing := missingFields(cfg, policy); len(missing) > 0 {
+			log.Printf("Skipping incomplete %s config %s: missing %s\n", cfg.Protocol, cfg.Name, strings.Join(missing, ", "))
+			continue
+		}
+		complete = append(complete, cfg)
+	}
+	return complete
+}
+
+// parseOutputPolicy validates an -output-policy value
+func parseOutputPolicy(policy string) (string, error) {
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	if policy != OutputPolicyStrict && policy != OutputPolicyLax {
+		return "", fmt.Errorf("unknown output policy %q (expected %s or %s)", policy, OutputPolicyStrict, OutputPolicyLax)
+	}
+	return policy, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// SourceCount is what one source yielded in count mode
+type SourceCount struct {
+	Source      string `json:"source"`
+	Configs     int    `json:"configs"`
+	ParseErrors int    `json:"parse_errors"`
+	Err         error  `json:"-"`
+}
+
+// CountSources fetches every enabled source and counts the configs each one
+// parses to, bypassing the cache, deduplication and filtering rules. The
+// counts are sorted by yield, highest first.
+func (a *Aggregator) CountSources() []SourceCount {
+	var sem chan struct{}
+	if a.concurrency > 0 {
+		sem = make(chan struct{}, a.concurrency)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var counts []SourceCount
+	for _, source := range a.sources {
+		if !source.Enabled {
+			continue
+		}
+
+		wg.Add(1)
+		go func(src ConfigSource) {
+			defer wg.Done()
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
+
+			configs, err := a.countSourceConfigs(src)
+			mu.Lock()
+			counts = append(counts, SourceCount{Source: src.Name, Configs: len(configs), Err: err})
+			mu.Unlock()
+		}(source)
+	}
+	wg.Wait()
+
+	parseErrors := a.ParseErrors()
+	for i := range counts {
+		counts[i].ParseErrors = parseErrors[counts[i].Source]
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Configs != counts[j].Configs {
+			return counts[i].Configs > counts[j].Configs
+		}
+		return counts[i].Source < counts[j].Source
+	})
+	return counts
+}
+
+// countSourceConfigs fetches and parses one source for CountSources
+func (a *Aggregator) countSourceConfigs(source ConfigSource) ([]*Config, error) {
+	if source.URL != stdinSourcesFile {
+		return a.fetchSourceConfigs(source)
+	}
+
+	body, err := io.ReadAll(a.stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stdin: %w", err)
+	}
+	a.dumpRaw(source.Name, body)
+	return a.parseSourceBody(source, body)
+}
+
+// writeSourceCounts renders counts as an aligned table, noting sources that
+// could not be fetched
+func writeSourceCounts(w io.Writer, counts []SourceCount) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tCONFIGS\tPARSE ERRORS\tSTATUS")
+	for _, c := range counts {
+		status := "ok"
+		if c.Err != nil {
+			status = c.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", c.Source, c.Configs, c.ParseErrors, status)
+	}
+	return tw.Flush()
+}
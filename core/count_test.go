@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCountSources tests per-source config and parse error counts, without
+// dedup across sources
+func TestCountSources(t *testing.T) {
+	rich := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "vless://uuid-1@one.example.com:443#One")
+		fmt.Fprintln(w, "vless://uuid-2@two.example.com:443#Two")
+		fmt.Fprintln(w, "trojan://pass@three.example.com:443#Three")
+		fmt.Fprintln(w, "not a share link")
+	}))
+	defer rich.Close()
+
+	poor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "vless://uuid-1@one.example.com:443#One")
+		fmt.Fprintln(w, "<html>")
+		fmt.Fprintln(w, "</html>")
+	}))
+	defer poor.Close()
+
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer dead.Close()
+
+	agg := newTestAggregator(t, []ConfigSource{
+		{Name: "poor", URL: poor.URL, Type: "plain", Enabled: true},
+		{Name: "dead", URL: dead.URL, Type: "plain", Enabled: true},
+		{Name: "rich", URL: rich.URL, Type: "plain", Enabled: true},
+		{Name: "off", URL: rich.URL, Type: "plain"},
+	}, 100)
+
+	counts := agg.CountSources()
+	if len(counts) != 3 {
+		t.Fatalf("Expected 3 enabled sources counted, got %d", len(counts))
+	}
+
+	expected := []SourceCount{
+		{Source: "rich", Configs: 3, ParseErrors: 1},
+		{Source: "poor", Configs: 1, ParseErrors: 2},
+		{Source: "dead", Configs: 0, ParseErrors: 0},
+	}
+	for i, want := range expected {
+		got := counts[i]
+		if got.Source != want.Source || got.Configs != want.Configs || got.ParseErrors != want.ParseErrors {
+			t.Errorf("Expected %s with %d configs and %d parse errors at %d, got %s with %d and %d",
+				want.Source, want.Configs, want.ParseErrors, i, got.Source, got.Configs, got.ParseErrors)
+		}
+	}
+	if counts[2].Err == nil {
+		t.Errorf("Expected the dead source to report its fetch error")
+	}
+
+	var sb strings.Builder
+	if err := writeSourceCounts(&sb, counts); err != nil {
+		t.Fatalf("Failed to write counts: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "rich ") {
+		t.Errorf("Expected a header and one row per source, rich first, got:\n%s", sb.String())
+	}
+}
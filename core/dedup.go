@@ -0,0 +1,35 @@
+package main
+
+import "sync"
+
+// dedupStore tracks which copy of each config key survives deduplication.
+// Claims are atomic, so producers can deduplicate concurrently instead of
+// funnelling every config through one collector.
+type dedupStore struct {
+	// kept maps a config key to the priority of the source whose copy won
+	kept sync.Map
+}
+
+// newDedupStore creates an empty dedup store
+func newDedupStore() *dedupStore {
+	return &dedupStore{}
+}
+
+// Claim records a copy of key from a source of the given priority. keep is
+// true for the first copy, and for a later copy from a more trusted source
+// than the one kept so far; duplicate is true if key had been claimed
+// before. Of concurrent claims with equal priority exactly one keeps.
+func (d *dedupStore) Claim(key string, priority int) (keep, duplicate bool) {
+	prev, loaded := d.kept.LoadOrStore(key, priority)
+	for loaded {
+		if priority <= prev.(int) {
+			return false, true
+		}
+		if d.kept.CompareAndSwap(key, prev, priority) {
+			return true, true
+		}
+		// Another claim won in between; compare against its priority
+		prev, loaded = d.kept.Load(key)
+	}
+	return true, false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestDedupStoreConcurrentClaims tests that concurrent producers keep
+// exactly one copy per key; run with -race
+func TestDedupStoreConcurrentClaims(t *testing.T) {
+	const producers = 32
+	const keys = 200
+
+	store := newDedupStore()
+	var kept [keys]atomic.Int32
+	var duplicates atomic.Int32
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < keys; k++ {
+				keep, duplicate := store.Claim(fmt.Sprintf("key-%d", k), 0)
+				if keep {
+					kept[k].Add(1)
+				}
+				if duplicate {
+					duplicates.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for k := range kept {
+		if got := kept[k].Load(); got != 1 {
+			t.Errorf("Expected exactly one survivor for key-%d, got %d", k, got)
+		}
+	}
+	if got := duplicates.Load(); got != (producers-1)*keys {
+		t.Errorf("Expected %d duplicates, got %d", (producers-1)*keys, got)
+	}
+}
+
+// TestDedupStorePriority tests that the most trusted copy wins regardless
+// of claim order
+func TestDedupStorePriority(t *testing.T) {
+	const producers = 32
+
+	store := newDedupStore()
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(priority int) {
+			defer wg.Done()
+			store.Claim("node", priority)
+		}(p)
+	}
+	wg.Wait()
+
+	if keep, _ := store.Claim("node", producers-1); keep {
+		t.Errorf("Expected a copy with the kept priority to be dropped")
+	}
+	if keep, duplicate := store.Claim("node", producers); !keep || !duplicate {
+		t.Errorf("Expected a more trusted copy to replace the kept one, got keep=%v duplicate=%v", keep, duplicate)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// configStoreSchema keeps one row per config fingerprint (Config.Key).
+// Timestamps are RFC 3339 UTC text so SQLite's date functions work on them.
+const configStoreSchema = `
+CREATE TABLE IF NOT EXISTS configs (
+	fingerprint TEXT PRIMARY KEY,
+	protocol    TEXT NOT NULL,
+	server      TEXT NOT NULL,
+	port        INTEGER NOT NULL,
+	country     TEXT NOT NULL DEFAULT '',
+	ping        INTEGER NOT NULL DEFAULT 0,
+	first_seen  TEXT NOT NULL,
+	last_seen   TEXT NOT NULL
+)`
+
+// configUpsert inserts a config or refreshes an existing row, keeping its first_seen
+const configUpsert = `
+INSERT INTO configs (fingerprint, protocol, server, port, country, ping, first_seen, last_seen)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(fingerprint) DO UPDATE SET
+	protocol  = excluded.protocol,
+	server    = excluded.server,
+	port      = excluded.port,
+	country   = excluded.country,
+	ping      = excluded.ping,
+	last_seen = excluded.last_seen`
+
+// ConfigStore persists aggregated configs to SQLite for historical tracking
+type ConfigStore struct {
+	db *sql.DB
+}
+
+// OpenConfigStore opens (creating if needed) the SQLite database at path.
+// ":memory:" gives a private in-memory database.
+func OpenConfigStore(path string) (*ConfigStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// SQLite allows a single writer, and every connection to ":memory:"
+	// would otherwise see its own empty database
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(configStoreSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	return &ConfigStore{db: db}, nil
+}
+
+// Upsert records configs as seen at now, in a single transaction
+func (s *ConfigStore) Upsert(configs []*Config, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(configUpsert)
+	if err != nil {
+		return fmt.Errorf("failed to prepare upsert: %w", err)
+	}
+	defer stmt.Close()
+
+	seen := now.UTC().Format(time.RFC3339)
+	for _, cfg := range configs {
+		if _, err := stmt.Exec(cfg.Key(), cfg.Protocol, cfg.Server, cfg.Port, cfg.Country, cfg.Ping, seen, seen); err != nil {
+			return fmt.Errorf("failed to upsert %s: %w", cfg.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit configs: %w", err)
+	}
+
+	return nil
+}
+
+// ReachableSince returns the last recorded ping of every config seen
+// reachable (non-zero ping) at or after since, keyed by fingerprint
+func (s *ConfigStore) ReachableSince(since time.Time) (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT fingerprint, ping FROM configs WHERE ping > 0 AND last_seen >= ?`,
+		since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reachable configs: %w", err)
+	}
+	defer rows.Close()
+
+	reachable := make(map[string]int)
+	for rows.Next() {
+		var fingerprint string
+		var ping int
+		if err := rows.Scan(&fingerprint, &ping); err != nil {
+			return nil, fmt.Errorf("failed to read reachable config: %w", err)
+		}
+		reachable[fingerprint] = ping
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read reachable configs: %w", err)
+	}
+
+	return reachable, nil
+}
+
+// FirstSeenSince returns the fingerprints of configs first seen at or after
+// since
+func (s *ConfigStore) FirstSeenSince(since time.Time) (map[string]bool, error) {
+	rows, err := s.db.Query(`SELECT fingerprint FROM configs WHERE first_seen >= ?`,
+		since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recent configs: %w", err)
+	}
+	defer rows.Close()
+
+	recent := make(map[string]bool)
+	for rows.Next() {
+		var fingerprint string
+		if err := rows.Scan(&fingerprint); err != nil {
+			return nil, fmt.Errorf("failed to read recent config: %w", err)
+		}
+		recent[fingerprint] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recent configs: %w", err)
+	}
+
+	return recent, nil
+}
+
+// Close closes the underlying database
+func (s *ConfigStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestConfigStoreUpsert tests that re-exporting updates rows and keeps first_seen
+func TestConfigStoreUpsert(t *testing.T) {
+	store, err := OpenConfigStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(6 * time.Hour)
+
+	a := &Config{ID: "a", Protocol: "vless", Server: "a.com", Port: 443, UUID: "uuid-a", Country: "DE", Ping: 120}
+	b := &Config{ID: "b", Protocol: "trojan", Server: "b.com", Port: 443, Password: "pass"}
+
+	if err := store.Upsert([]*Config{a, b}, first); err != nil {
+		t.Fatalf("First export failed: %v", err)
+	}
+
+	a.Ping = 80
+	c := &Config{ID: "c", Protocol: "ss", Server: "c.com", Port: 8388, Method: "aes-256-gcm", Password: "pass"}
+	if err := store.Upsert([]*Config{a, c}, second); err != nil {
+		t.Fatalf("Second export failed: %v", err)
+	}
+
+	var count int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM configs`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count rows: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 rows, got %d", count)
+	}
+
+	var ping int
+	var country, firstSeen, lastSeen string
+	row := store.db.QueryRow(`SELECT ping, country, first_seen, last_seen FROM configs WHERE fingerprint = ?`, a.Key())
+	if err := row.Scan(&ping, &country, &firstSeen, &lastSeen); err != nil {
+		t.Fatalf("Failed to read row: %v", err)
+	}
+
+	if ping != 80 {
+		t.Errorf("Expected ping to be updated to 80, got %d", ping)
+	}
+	if country != "DE" {
+		t.Errorf("Expected country DE, got %s", country)
+	}
+	if firstSeen != "2024-01-01T00:00:00Z" {
+		t.Errorf("Expected first_seen to be kept, got %s", firstSeen)
+	}
+	if lastSeen != "2024-01-01T06:00:00Z" {
+		t.Errorf("Expected last_seen to be updated, got %s", lastSeen)
+	}
+
+	row = store.db.QueryRow(`SELECT last_seen FROM configs WHERE fingerprint = ?`, b.Key())
+	if err := row.Scan(&lastSeen); err != nil {
+		t.Fatalf("Failed to read row: %v", err)
+	}
+	if lastSeen != "2024-01-01T00:00:00Z" {
+		t.Errorf("Expected unseen config to keep its last_seen, got %s", lastSeen)
+	}
+}
@@ -196,3 +196,51 @@ func (isf *IranSpecificFilter) isUnstableServer(server string) bool {
 
 	return false
 }
+
+// learnBlacklist appends a domain exclude rule for each unreachable server
+// not already excluded, returning the updated rule set
+func learnBlacklist(rules []FilterRule, failed []*Config) []FilterRule {
+	excluded := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Type == "domain" && rule.Action == "exclude" {
+			excluded[rule.Pattern] = true
+		}
+	}
+
+	for _, cfg := range failed {
+		if cfg.Server == "" || excluded[cfg.Server] {
+			continue
+		}
+		excluded[cfg.Server] = true
+
+		rules = append(rules, FilterRule{
+			Name:    "Learned unreachable " + cfg.Server,
+			Type:    "domain",
+			Pattern: cfg.Server,
+			Action:  "exclude",
+			Enabled: true,
+		})
+	}
+
+	return rules
+}
+
+// excludeConfigs returns configs without those in drop
+func excludeConfigs(configs []*Config, drop []*Config) []*Config {
+	if len(drop) == 0 {
+		return configs
+	}
+
+	dropped := make(map[*Config]bool, len(drop))
+	for _, cfg := range drop {
+		dropped[cfg] = true
+	}
+
+	kept := make([]*Config, 0, len(configs))
+	for _, cfg := range configs {
+		if !dropped[cfg] {
+			kept = append(kept, cfg)
+		}
+	}
+	return kept
+}
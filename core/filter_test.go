@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestFilterConfigsPreservesOrder tests that filtering keeps the relative order of surviving configs
+func TestFilterConfigsPreservesOrder(t *testing.T) {
+	configs := []*Config{
+		{Protocol: "vless", Server: "e.com", Port: 443},
+		{Protocol: "vless", Server: "blocked.com", Port: 443},
+		{Protocol: "trojan", Server: "a.com", Port: 443},
+		{Protocol: "vless", Server: "ssh.com", Port: 22},
+		{Protocol: "ss", Server: "c.com", Port: 8388},
+	}
+
+	engine := NewFilterEngine([]FilterRule{
+		{Name: "Block", Type: "domain", Pattern: "blocked.com", Action: "exclude", Enabled: true},
+	})
+
+	filtered := engine.FilterConfigs(configs)
+
+	var servers []string
+	for _, cfg := range filtered {
+		servers = append(servers, cfg.Server)
+	}
+
+	expected := []string{"e.com", "a.com", "c.com"}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Errorf("Expected order %v, got %v", expected, servers)
+	}
+
+	again := engine.FilterConfigs(filtered)
+	if !reflect.DeepEqual(again, filtered) {
+		t.Errorf("Expected filtering to be idempotent")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoResolver looks up the country of config servers in a MaxMind database
+type GeoResolver struct {
+	db       *maxminddb.Reader
+	lookupIP func(host string) ([]net.IP, error)
+}
+
+// geoRecord is the subset of a GeoLite2/GeoIP2 country record we read
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// NewGeoResolver opens a GeoLite2/GeoIP2 country or city database
+func NewGeoResolver(path string) (*GeoResolver, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open geo database %s: %w", path, err)
+	}
+
+	return &GeoResolver{db: db, lookupIP: net.LookupIP}, nil
+}
+
+// Country returns the ISO country code of a server address or hostname,
+// or "" if it cannot be resolved
+func (g *GeoResolver) Country(server string) string {
+	ip := net.ParseIP(server)
+	if ip == nil {
+		ips, err := g.lookupIP(server)
+		if err != nil || len(ips) == 0 {
+			return ""
+		}
+		ip = ips[0]
+	}
+
+	var record geoRecord
+	if err := g.db.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+// Resolve sets Country on configs that don't have one yet
+func (g *GeoResolver) Resolve(configs []*Config) {
+	for _, cfg := range configs {
+		if cfg.Country == "" {
+			cfg.Country = g.Country(cfg.Server)
+		}
+	}
+}
+
+// Close releases the database
+func (g *GeoResolver) Close() error {
+	return g.db.Close()
+}
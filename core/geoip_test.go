@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestBogusGeoDatabaseNonFatal tests that an unreadable geo database still produces a subscription
+func TestBogusGeoDatabaseNonFatal(t *testing.T) {
+	bogus := writeTestFile(t, "GeoLite2-Country.mmdb", "not a maxmind database")
+
+	if _, err := NewGeoResolver(bogus); err == nil {
+		t.Fatalf("Expected an error opening a corrupt database")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "vless://uuid@server.com:443")
+	}))
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.GeoIP = bogus
+
+	agg := newTestAggregator(t, []ConfigSource{{Name: "stub", URL: server.URL, Type: "plain", Enabled: true}}, 100)
+	configureAggregator(agg, &opts)
+
+	if agg.geo != nil {
+		t.Errorf("Expected geo enrichment to be disabled")
+	}
+	if err := agg.Close(); err != nil {
+		t.Errorf("Expected Close without a geo database to succeed, got %v", err)
+	}
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if len(configs) != 1 || configs[0].Country != "" {
+		t.Fatalf("Expected 1 config with no country, got %+v", configs)
+	}
+
+	sub, err := NewSubscriptionGenerator("clash-meta").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	if !strings.Contains(sub, "server.com") {
+		t.Errorf("Expected subscription to include server.com")
+	}
+}
@@ -1,10 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
 )
 
+// updateGolden rewrites golden files under testdata with the current output
+var updateGolden = flag.Bool("update", false, "update golden files")
+
 // TestEndToEndPipeline tests the complete pipeline: parse -> filter -> generate
 func TestEndToEndPipeline(t *testing.T) {
 	NewProtocolParser()
@@ -42,7 +59,7 @@ func TestEndToEndPipeline(t *testing.T) {
 	}
 
 	// Generate Clash format
-	clashGen := NewSubscriptionGenerator("clash")
+	clashGen := NewSubscriptionGenerator("clash-meta")
 	clashSub, err := clashGen.Generate(configs)
 	if err != nil {
 		t.Fatalf("Failed to generate Clash subscription: %v", err)
@@ -109,7 +126,7 @@ func TestParseAndGenerateClash(t *testing.T) {
 		configs = append(configs, cfg)
 	}
 
-	gen := NewSubscriptionGenerator("clash")
+	gen := NewSubscriptionGenerator("clash-meta")
 	sub, err := gen.Generate(configs)
 	if err != nil {
 		t.Fatalf("Failed to generate Clash: %v", err)
@@ -147,7 +164,7 @@ func TestREALITYProtocolGeneration(t *testing.T) {
 	configs := []*Config{config}
 
 	// Test Clash generation with REALITY
-	clashGen := NewSubscriptionGenerator("clash")
+	clashGen := NewSubscriptionGenerator("clash-meta")
 	clashSub, err := clashGen.Generate(configs)
 	if err != nil {
 		t.Fatalf("Failed to generate Clash with REALITY: %v", err)
@@ -187,7 +204,7 @@ func TestXHTTPProtocolGeneration(t *testing.T) {
 	configs := []*Config{config}
 
 	// Test Clash generation with XHTTP
-	clashGen := NewSubscriptionGenerator("clash")
+	clashGen := NewSubscriptionGenerator("clash-meta")
 	clashSub, err := clashGen.Generate(configs)
 	if err != nil {
 		t.Fatalf("Failed to generate Clash with XHTTP: %v", err)
@@ -209,6 +226,52 @@ func TestXHTTPProtocolGeneration(t *testing.T) {
 	}
 }
 
+// TestVLESSFlowRequiresSecurity tests that flow is only emitted with TLS or REALITY
+func TestVLESSFlowRequiresSecurity(t *testing.T) {
+	plain := &Config{
+		ID:       "vless-plain",
+		Protocol: "vless",
+		Server:   "plain.example.com",
+		Port:     443,
+		UUID:     "uuid-plain",
+		Flow:     "xtls-rprx-vision",
+		Name:     "Plain VLESS",
+	}
+
+	gen := NewSubscriptionGenerator("clash-meta")
+	sub, err := gen.Generate([]*Config{plain})
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+
+	if strings.Contains(sub, "flow:") {
+		t.Errorf("Clash output should not include flow without tls/reality security")
+	}
+
+	reality := &Config{
+		ID:         "vless-reality",
+		Protocol:   "vless",
+		Server:     "reality.example.com",
+		Port:       443,
+		UUID:       "uuid-reality",
+		Flow:       "xtls-rprx-vision",
+		Security:   "reality",
+		PublicKey:  "abc123def456",
+		ShortID:    "sid123",
+		ServerName: "real.example.com",
+		Name:       "REALITY VLESS",
+	}
+
+	sub, err = gen.Generate([]*Config{reality})
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+
+	if !strings.Contains(sub, "flow: xtls-rprx-vision") {
+		t.Errorf("Clash output should keep flow for REALITY config")
+	}
+}
+
 // TestVMessGeneration tests VMess protocol generation
 func TestVMessGeneration(t *testing.T) {
 	config := &Config{
@@ -298,7 +361,7 @@ func TestSubscriptionMetadata(t *testing.T) {
 		Source:   "test-source",
 	}
 
-	gen := NewSubscriptionGenerator("clash")
+	gen := NewSubscriptionGenerator("clash-meta")
 	sub, _ := gen.Generate([]*Config{config})
 
 	// Should include the name
@@ -318,16 +381,16 @@ func TestLargeConfigSet(t *testing.T) {
 	var configs []*Config
 	for i := 0; i < 100; i++ {
 		configs = append(configs, &Config{
-			ID:       "config" + string(rune(i)),
+			ID:       fmt.Sprintf("config%d", i),
 			Protocol: "vless",
 			Server:   "server.com",
 			Port:     443 + i,
-			UUID:     "uuid-" + string(rune(i)),
-			Name:     "Config " + string(rune(i)),
+			UUID:     fmt.Sprintf("uuid-%d", i),
+			Name:     fmt.Sprintf("Config %d", i),
 		})
 	}
 
-	gen := NewSubscriptionGenerator("clash")
+	gen := NewSubscriptionGenerator("clash-meta")
 	sub, err := gen.Generate(configs)
 
 	if err != nil {
@@ -401,16 +464,16 @@ func BenchmarkClashGeneration(b *testing.B) {
 	var configs []*Config
 	for i := 0; i < 100; i++ {
 		configs = append(configs, &Config{
-			ID:       "config-" + string(rune(i)),
+			ID:       fmt.Sprintf("config-%d", i),
 			Protocol: "vless",
 			Server:   "server.com",
 			Port:     443,
-			UUID:     "uuid-" + string(rune(i)),
-			Name:     "Config " + string(rune(i)),
+			UUID:     fmt.Sprintf("uuid-%d", i),
+			Name:     fmt.Sprintf("Config %d", i),
 		})
 	}
 
-	gen := NewSubscriptionGenerator("clash")
+	gen := NewSubscriptionGenerator("clash-meta")
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -423,12 +486,12 @@ func BenchmarkSingboxGeneration(b *testing.B) {
 	var configs []*Config
 	for i := 0; i < 100; i++ {
 		configs = append(configs, &Config{
-			ID:       "config-" + string(rune(i)),
+			ID:       fmt.Sprintf("config-%d", i),
 			Protocol: "vless",
 			Server:   "server.com",
 			Port:     443,
-			UUID:     "uuid-" + string(rune(i)),
-			Name:     "Config " + string(rune(i)),
+			UUID:     fmt.Sprintf("uuid-%d", i),
+			Name:     fmt.Sprintf("Config %d", i),
 		})
 	}
 
@@ -461,3 +524,1057 @@ func BenchmarkEndToEnd(b *testing.B) {
 		gen.Generate(configs)
 	}
 }
+
+// TestTrailingNewlinePolicy tests that every format applies the same trailing newline policy
+func TestTrailingNewlinePolicy(t *testing.T) {
+	configs := []*Config{
+		{ID: "vless-1", Protocol: "vless", Server: "server.com", Port: 443, UUID: "uuid", Name: "Node"},
+	}
+
+	for _, format := range []string{"clash", "singbox", "v2ray", "raw"} {
+		gen := NewSubscriptionGenerator(format)
+		sub, err := gen.Generate(configs)
+		if err != nil {
+			t.Fatalf("Failed to generate %s: %v", format, err)
+		}
+		if !strings.HasSuffix(sub, "\n") || strings.HasSuffix(sub, "\n\n") {
+			t.Errorf("Expected %s output to end with exactly one newline, got %q", format, sub)
+		}
+
+		gen.SetTrailingNewline(false)
+		sub, err = gen.Generate(configs)
+		if err != nil {
+			t.Fatalf("Failed to generate %s: %v", format, err)
+		}
+		if strings.HasSuffix(sub, "\n") {
+			t.Errorf("Expected %s output without trailing newline when disabled", format)
+		}
+	}
+}
+
+// TestOutputPolicyTrojanSNI tests that a trojan config without SNI is skipped under strict policy but emitted under lax
+func TestOutputPolicyTrojanSNI(t *testing.T) {
+	configs := []*Config{
+		{ID: "trojan-1", Protocol: "trojan", Server: "nosni.com", Port: 443, Password: "pass", Name: "NoSNI"},
+		{ID: "trojan-2", Protocol: "trojan", Server: "withsni.com", Port: 443, Password: "pass", TLSServerName: "withsni.com", Name: "WithSNI"},
+	}
+
+	gen := NewSubscriptionGenerator("clash")
+	gen.SetOutputPolicy(OutputPolicyStrict)
+	sub, err := gen.Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	if strings.Contains(sub, "nosni.com") {
+		t.Errorf("Strict policy should skip trojan without sni")
+	}
+	if !strings.Contains(sub, "withsni.com") {
+		t.Errorf("Strict policy should keep complete trojan")
+	}
+
+	gen.SetOutputPolicy(OutputPolicyLax)
+	sub, err = gen.Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	if !strings.Contains(sub, "nosni.com") || !strings.Contains(sub, "withsni.com") {
+		t.Errorf("Lax policy should emit both trojan configs")
+	}
+}
+
+// TestOutputPolicyRealityKey tests that strict policy requires a REALITY public key
+func TestOutputPolicyRealityKey(t *testing.T) {
+	incomplete := &Config{Protocol: "vless", Server: "server.com", Port: 443, UUID: "uuid", Security: "reality"}
+	if missing := missingFields(incomplete, OutputPolicyStrict); len(missing) != 1 || missing[0] != "pbk" {
+		t.Errorf("Expected missing pbk, got %v", missing)
+	}
+
+	if missing := missingFields(incomplete, OutputPolicyLax); len(missing) != 0 {
+		t.Errorf("Expected lax policy to accept the config, got missing %v", missing)
+	}
+}
+
+// makeRawBenchConfigs creates n configs for raw output benchmarks
+func makeRawBenchConfigs(n int) []*Config {
+	configs := make([]*Config, 0, n)
+	for i := 0; i < n; i++ {
+		configs = append(configs, &Config{
+			ID:       fmt.Sprintf("config-%d", i),
+			Protocol: "vless",
+			Server:   fmt.Sprintf("server-%d.example.com", i),
+			Port:     443,
+			UUID:     fmt.Sprintf("uuid-%d", i),
+			Name:     fmt.Sprintf("Config %d", i),
+		})
+	}
+	return configs
+}
+
+// TestRawStreamMatchesGenerate tests that streamed raw output matches the in-memory result
+func TestRawStreamMatchesGenerate(t *testing.T) {
+	configs := makeRawBenchConfigs(3)
+	gen := NewSubscriptionGenerator("raw")
+
+	sub, err := gen.Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate raw: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := gen.GenerateTo(&buf, configs); err != nil {
+		t.Fatalf("Failed to stream raw: %v", err)
+	}
+
+	if buf.String() != sub {
+		t.Errorf("Expected streamed output %q, got %q", sub, buf.String())
+	}
+
+	if lines := strings.Split(strings.TrimSuffix(sub, "\n"), "\n"); len(lines) != 3 {
+		t.Errorf("Expected 3 links, got %d", len(lines))
+	}
+}
+
+// BenchmarkRawGeneration benchmarks raw output for 5000 configs, built in
+// memory versus streamed to a writer
+func BenchmarkRawGeneration(b *testing.B) {
+	configs := makeRawBenchConfigs(5000)
+	gen := NewSubscriptionGenerator("raw")
+
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			gen.Generate(configs)
+		}
+	})
+
+	b.Run("writer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			gen.GenerateTo(io.Discard, configs)
+		}
+	})
+}
+
+// TestSingboxFrontDetour tests that non-front outbounds chain through the front via detour
+func TestSingboxFrontDetour(t *testing.T) {
+	configs := []*Config{
+		{ID: "front-1", Protocol: "trojan", Server: "front.com", Port: 443, Password: "p", Name: "Front"},
+		{ID: "vless-1", Protocol: "vless", Server: "a.com", Port: 443, UUID: "uuid-a", Name: "Node A"},
+		{ID: "vless-2", Protocol: "vless", Server: "b.com", Port: 443, UUID: "uuid-b", Name: "Node B"},
+	}
+
+	gen := NewSubscriptionGenerator("singbox")
+	gen.SetFront("front-1")
+
+	sub, err := gen.Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate Sing-box: %v", err)
+	}
+
+	outbounds := strings.Split(sub, `{"type":`)[1:]
+	if len(outbounds) != 3 {
+		t.Fatalf("Expected 3 outbounds, got %d in %s", len(outbounds), sub)
+	}
+
+	for _, outbound := range outbounds {
+		isFront := strings.Contains(outbound, `"tag":"Front"`)
+		hasDetour := strings.Contains(outbound, `"detour":"Front"`)
+		if isFront && hasDetour {
+			t.Errorf("Front outbound should not detour through itself: %s", outbound)
+		}
+		if !isFront && !hasDetour {
+			t.Errorf("Expected outbound to detour through Front: %s", outbound)
+		}
+	}
+}
+
+// TestSingboxPinnedCertificate tests that a pinSHA256 link parameter reaches the Sing-box TLS block
+func TestSingboxPinnedCertificate(t *testing.T) {
+	const pin = "c5a1a0d1b2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9"
+
+	parser := NewProtocolParser()
+	links := []string{
+		"trojan://pass@pinned.example.com:443?sni=pinned.example.com&pinSHA256=" + pin + "#Pinned%20Trojan",
+		"vless://uuid-pin@pinned.example.com:443?security=tls&sni=pinned.example.com&pinSHA256=" + pin + "#Pinned%20VLESS",
+	}
+
+	for _, link := range links {
+		cfg, err := parser.ParseConfig(link, "test")
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", link, err)
+		}
+		if cfg.PinnedCertSHA256 != pin {
+			t.Errorf("Expected pin %s, got %q", pin, cfg.PinnedCertSHA256)
+		}
+
+		reparsed, err := parser.ParseConfig(cfg.String(), "round-trip")
+		if err != nil {
+			t.Fatalf("Failed to re-parse %s: %v", cfg.String(), err)
+		}
+		if reparsed.Key() != cfg.Key() {
+			t.Errorf("Expected pin to survive the share link round trip, got %s", cfg.String())
+		}
+
+		gen := NewSubscriptionGenerator("singbox")
+		sub, err := gen.Generate([]*Config{reparsed})
+		if err != nil {
+			t.Fatalf("Failed to generate Sing-box: %v", err)
+		}
+
+		expected := `"server_name":"pinned.example.com","certificate_public_key_sha256":["` + pin + `"],"alpn":["h2","http/1.1"]}`
+		if !strings.Contains(sub, expected) {
+			t.Errorf("Expected pinned TLS block %s, got %s", expected, sub)
+		}
+	}
+}
+
+// TestFormatFieldWhitelist tests that fields a format does not support are
+// left out of its output, though its generator writes them when the format
+// has no whitelist
+func TestFormatFieldWhitelist(t *testing.T) {
+	const pin = "c5a1a0d1b2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9"
+
+	parser := NewProtocolParser()
+	cfg, err := parser.ParseConfig("vless://uuid-w@white.example.com:443?type=tcp&reality=yes&security=reality&pbk=key&sid=ab&sni=white.example.com&spx=%2Fcrawl&pinSHA256="+pin+"#Whitelist", "test")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if cfg.SpiderX != "/crawl" {
+		t.Errorf("Expected spiderX /crawl, got %q", cfg.SpiderX)
+	}
+	if link := cfg.String(); !strings.Contains(link, "spx=") {
+		t.Errorf("Expected spx in the share link, got %s", link)
+	}
+
+	clash, err := NewSubscriptionGenerator("clash-meta").Generate([]*Config{cfg})
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+	if strings.Contains(clash, pin) {
+		t.Errorf("Expected pinned certificate to be absent from Clash output, got %s", clash)
+	}
+
+	singbox, err := NewSubscriptionGenerator("singbox").Generate([]*Config{cfg})
+	if err != nil {
+		t.Fatalf("Failed to generate Sing-box: %v", err)
+	}
+	if !strings.Contains(singbox, pin) {
+		t.Errorf("Expected pinned certificate in Sing-box output, got %s", singbox)
+	}
+
+	for format, sub := range map[string]string{"clash-meta": clash, "singbox": singbox} {
+		if strings.Contains(sub, "crawl") {
+			t.Errorf("Expected spiderX to be absent from %s output, got %s", format, sub)
+		}
+	}
+
+	// Without a whitelist entry the Clash generator writes every field it knows
+	saved := formatFields["clash-meta"]
+	delete(formatFields, "clash-meta")
+	defer func() { formatFields["clash-meta"] = saved }()
+
+	unfiltered, err := NewSubscriptionGenerator("clash-meta").Generate([]*Config{cfg})
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+	if !strings.Contains(unfiltered, "fingerprint: "+pin) {
+		t.Errorf("Expected the pinned certificate without a whitelist, got %s", unfiltered)
+	}
+}
+
+// TestDefaultALPN tests that TLS configs without an explicit alpn get a transport-appropriate default
+func TestDefaultALPN(t *testing.T) {
+	parser := NewProtocolParser()
+	h2, err := parser.ParseConfig("vless://uuid-h2@h2.example.com:443?security=tls&sni=h2.example.com&type=h2&host=h2.example.com&path=%2Fh2#H2", "test")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if h2.ALPN != "" {
+		t.Fatalf("Expected no explicit ALPN, got %q", h2.ALPN)
+	}
+
+	clash, err := NewSubscriptionGenerator("clash-meta").Generate([]*Config{h2})
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+	if !strings.Contains(clash, "    alpn:\n      - h2\n") {
+		t.Errorf("Expected h2 ALPN in Clash output, got %s", clash)
+	}
+	if !strings.Contains(clash, "network: h2") {
+		t.Errorf("Expected h2 network in Clash output, got %s", clash)
+	}
+
+	singbox, err := NewSubscriptionGenerator("singbox").Generate([]*Config{h2})
+	if err != nil {
+		t.Fatalf("Failed to generate Sing-box: %v", err)
+	}
+	if !strings.Contains(singbox, `"alpn":["h2"]`) {
+		t.Errorf("Expected h2 ALPN in Sing-box output, got %s", singbox)
+	}
+
+	explicit := &Config{Protocol: "trojan", Server: "t.com", Port: 443, Password: "pass", TransportType: "h2", ALPN: "http/1.1"}
+	if alpn := tlsALPN(explicit); len(alpn) != 2 || alpn[0] != "h2" || alpn[1] != "http/1.1" {
+		t.Errorf("Expected h2 to be added to an explicit h2 transport ALPN, got %v", alpn)
+	}
+
+	plain := &Config{Protocol: "vless", Server: "p.com", Port: 80, UUID: "uuid-p"}
+	if alpn := tlsALPN(plain); alpn != nil {
+		t.Errorf("Expected no ALPN without TLS, got %v", alpn)
+	}
+}
+
+// TestClashLoadBalanceGroup tests that -group-type load-balance emits the chosen strategy
+func TestClashLoadBalanceGroup(t *testing.T) {
+	configs := []*Config{
+		{ID: "vless-1", Protocol: "vless", Server: "a.com", Port: 443, UUID: "uuid-a", Name: "Node A"},
+		{ID: "vless-2", Protocol: "vless", Server: "b.com", Port: 443, UUID: "uuid-b", Name: "Node B"},
+	}
+
+	for _, strategy := range []string{LoadBalanceRoundRobin, LoadBalanceConsistentHashing} {
+		gen := NewSubscriptionGenerator("clash-meta")
+		gen.SetGroupType(GroupTypeLoadBalance, strategy)
+
+		sub, err := gen.Generate(configs)
+		if err != nil {
+			t.Fatalf("Failed to generate Clash: %v", err)
+		}
+
+		group := sub[strings.Index(sub, "proxy-groups:"):]
+		expected := "    type: load-balance\n    strategy: " + strategy + "\n"
+		if !strings.Contains(group, expected) {
+			t.Errorf("Expected load-balance group with %s strategy, got:\n%s", strategy, group)
+		}
+		if !strings.Contains(group, "      - Node A\n      - Node B\n") {
+			t.Errorf("Expected every proxy in the load-balance group, got:\n%s", group)
+		}
+	}
+
+	if _, _, err := parseGroupType(GroupTypeLoadBalance, "random"); err == nil {
+		t.Errorf("Expected error for unknown load-balance strategy")
+	}
+}
+
+// TestVMessHTTPHeaderGeneration tests that a headerType=http VMess emits its HTTP options
+func TestVMessHTTPHeaderGeneration(t *testing.T) {
+	vmessJSON := `{"v":"2","ps":"HTTP VMess","add":"vmess.example.com","port":"80","id":"12345678-1234-1234-1234-123456789012","aid":"0","net":"tcp","type":"http","host":"cdn.example.com","path":"/video"}`
+	cfg, err := NewProtocolParser().ParseConfig("vmess://"+base64.StdEncoding.EncodeToString([]byte(vmessJSON)), "test")
+	if err != nil {
+		t.Fatalf("Failed to parse VMess: %v", err)
+	}
+
+	if cfg.HTTPMethod != "GET" {
+		t.Errorf("Expected headerType http to set method GET, got %q", cfg.HTTPMethod)
+	}
+
+	clash, err := NewSubscriptionGenerator("clash").Generate([]*Config{cfg})
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+
+	expected := "    network: http\n    http-opts:\n      method: GET\n      path:\n        - /video\n      headers:\n        Host:\n          - cdn.example.com\n"
+	if !strings.Contains(clash, expected) {
+		t.Errorf("Expected http-opts in Clash output, got:\n%s", clash)
+	}
+
+	singbox, err := NewSubscriptionGenerator("singbox").Generate([]*Config{cfg})
+	if err != nil {
+		t.Fatalf("Failed to generate Sing-box: %v", err)
+	}
+
+	if !strings.Contains(singbox, `"transport":{"type":"http","method":"GET","host":["cdn.example.com"],"path":"/video"}`) {
+		t.Errorf("Expected http transport in Sing-box output, got %s", singbox)
+	}
+
+	reparsed, err := NewProtocolParser().ParseConfig(cfg.String(), "round-trip")
+	if err != nil {
+		t.Fatalf("Failed to re-parse %s: %v", cfg.String(), err)
+	}
+	if reparsed.Key() != cfg.Key() {
+		t.Errorf("Expected HTTP header settings to survive the share link round trip")
+	}
+}
+
+// TestVMessH2IgnoresHTTPHeaderType tests that headerType http only applies
+// to tcp, so an h2 VMess node yields a single Clash network key
+func TestVMessH2IgnoresHTTPHeaderType(t *testing.T) {
+	vmessJSON := `{"v":"2","ps":"H2 VMess","add":"h2.example.com","port":"443","id":"12345678-1234-1234-1234-123456789012","aid":"0","net":"h2","type":"http","host":"h2.example.com","path":"/h2","tls":"tls","sni":"h2.example.com"}`
+	cfg, err := NewProtocolParser().ParseConfig("vmess://"+base64.StdEncoding.EncodeToString([]byte(vmessJSON)), "test")
+	if err != nil {
+		t.Fatalf("Failed to parse VMess: %v", err)
+	}
+
+	if cfg.HTTPMethod != "" {
+		t.Errorf("Expected no HTTP header method for h2, got %q", cfg.HTTPMethod)
+	}
+
+	clash, err := NewSubscriptionGenerator("clash").Generate([]*Config{cfg})
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+
+	var doc struct {
+		Proxies []map[string]interface{} `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal([]byte(clash), &doc); err != nil {
+		t.Fatalf("Expected valid Clash YAML, got %v in:\n%s", err, clash)
+	}
+	if len(doc.Proxies) != 1 || doc.Proxies[0]["network"] != "h2" {
+		t.Errorf("Expected one h2 proxy, got %v", doc.Proxies)
+	}
+}
+
+// TestTrojanGoEncryptionOutput tests that the shadowsocks layer reaches Clash and is skipped for Sing-box
+func TestTrojanGoEncryptionOutput(t *testing.T) {
+	configs := []*Config{
+		{ID: "trojan-go", Protocol: "trojan", Server: "go.example.com", Port: 443, Password: "pass", TLSServerName: "go.example.com", TrojanSSMethod: "aes-128-gcm", TrojanSSPassword: "secret", Name: "Trojan-Go"},
+		{ID: "trojan-1", Protocol: "trojan", Server: "plain.example.com", Port: 443, Password: "pass", TLSServerName: "plain.example.com", Name: "Trojan"},
+	}
+
+	clash, err := NewSubscriptionGenerator("clash").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+	if !strings.Contains(clash, "    ss-opts:\n      enabled: true\n      method: aes-128-gcm\n      password: secret\n") {
+		t.Errorf("Expected ss-opts in Clash output, got:\n%s", clash)
+	}
+
+	singbox, err := NewSubscriptionGenerator("singbox").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate Sing-box: %v", err)
+	}
+	if strings.Contains(singbox, "go.example.com") {
+		t.Errorf("Expected Trojan-Go config to be skipped for Sing-box, got %s", singbox)
+	}
+	if !strings.Contains(singbox, "plain.example.com") {
+		t.Errorf("Expected plain Trojan config in Sing-box output, got %s", singbox)
+	}
+}
+
+// TestUnicodeNamesRoundTrip tests that emoji and RTL Persian names survive parsing and every generator byte-for-byte
+func TestUnicodeNamesRoundTrip(t *testing.T) {
+	names := []string{
+		"🇮🇷 سرور تهران",
+		"👨‍💻 نود ‏۱۲۳‎ #2",
+		"می‌خواهم 100% سریع",
+		"🇩🇪 Frankfurt ⚡️ | 🚀",
+		"Node: \"Tehran\" @ 2",
+	}
+	templates := []*Config{
+		{Protocol: "vless", Server: "vless.example.com", Port: 443, UUID: "uuid-u", Security: "tls", ServerName: "vless.example.com"},
+		{Protocol: "trojan", Server: "trojan.example.com", Port: 443, Password: "pass", TLSServerName: "trojan.example.com"},
+		{Protocol: "vmess", Server: "vmess.example.com", Port: 443, UUID: "vmess-uuid", Cipher: "auto"},
+		{Protocol: "ss", Server: "ss.example.com", Port: 8388, Method: "aes-256-gcm", Password: "pass"},
+	}
+
+	// Serve every link from a base64 subscription source so names also pass
+	// through fetching, decoding and collection
+	var links []string
+	for i, name := range names {
+		for _, tmpl := range templates {
+			cfg := tmpl.Clone()
+			cfg.Name = name
+			cfg.Port += i // distinct fingerprints, so dedup keeps every name
+			links = append(links, cfg.String())
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n"))))
+	}))
+	defer server.Close()
+
+	source := ConfigSource{Name: "unicode", URL: server.URL, Type: "base64", Enabled: true}
+	configs, err := newTestAggregator(t, []ConfigSource{source}, 100).FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(configs) != len(links) {
+		t.Fatalf("Expected %d configs, got %d", len(links), len(configs))
+	}
+
+	expected := make(map[string]bool, len(names))
+	for _, name := range names {
+		expected[name] = true
+	}
+
+	for _, cfg := range configs {
+		if !expected[cfg.Name] {
+			t.Errorf("Expected %s name to round trip unmangled, got %q (% x)", cfg.Protocol, cfg.Name, cfg.Name)
+			continue
+		}
+
+		singbox, err := NewSubscriptionGenerator("singbox").Generate([]*Config{cfg})
+		if err != nil {
+			t.Fatalf("Failed to generate Sing-box: %v", err)
+		}
+		var doc singboxConfig
+		if err := json.Unmarshal([]byte(singbox), &doc); err != nil || !utf8.ValidString(singbox) {
+			t.Fatalf("Expected valid Sing-box JSON, got %v in %s", err, singbox)
+		}
+		if len(doc.Outbounds) != 1 || doc.Outbounds[0].Tag != cfg.Name {
+			t.Errorf("Expected Sing-box tag %q unmangled, got %s", cfg.Name, singbox)
+		}
+	}
+
+	// Clash clients read names back through a YAML parser, where " #2"
+	// starts a comment and ": " a mapping unless the name is quoted
+	clash, err := NewSubscriptionGenerator("clash-meta").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+	var doc struct {
+		Proxies []struct {
+			Name string `yaml:"name"`
+		} `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal([]byte(clash), &doc); err != nil {
+		t.Fatalf("Failed to read Clash output as YAML: %v", err)
+	}
+	for i, proxy := range doc.Proxies {
+		if proxy.Name != configs[i].Name {
+			t.Errorf("Expected Clash name %q, got %q", configs[i].Name, proxy.Name)
+		}
+	}
+}
+
+// TestPacketEncodingRoundTrip tests that a VLESS packetEncoding reaches
+// Sing-box and Clash.Meta output and survives a share link round trip
+func TestPacketEncodingRoundTrip(t *testing.T) {
+	parser := NewProtocolParser()
+	cfg, err := parser.ParseConfig("vless://uuid-p@udp.example.com:443?security=tls&sni=udp.example.com&packetEncoding=xudp#UDP", "test")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if cfg.PacketEncoding != "xudp" {
+		t.Errorf("Expected packet encoding xudp, got %q", cfg.PacketEncoding)
+	}
+	if cfg.GetMeta("param.packetEncoding") != "" {
+		t.Errorf("Expected packetEncoding to be consumed, not passed through as metadata")
+	}
+
+	reparsed, err := parser.ParseConfig(cfg.String(), "test")
+	if err != nil {
+		t.Fatalf("Failed to reparse share link: %v", err)
+	}
+	if reparsed.PacketEncoding != "xudp" {
+		t.Errorf("Expected packet encoding to survive the share link, got %q", reparsed.PacketEncoding)
+	}
+
+	singbox, err := NewSubscriptionGenerator("singbox").Generate([]*Config{reparsed})
+	if err != nil {
+		t.Fatalf("Failed to generate Sing-box: %v", err)
+	}
+	if !strings.Contains(singbox, `"packet_encoding":"xudp"`) {
+		t.Errorf("Expected packet_encoding in Sing-box output, got %s", singbox)
+	}
+
+	meta, err := NewSubscriptionGenerator("clash-meta").Generate([]*Config{reparsed})
+	if err != nil {
+		t.Fatalf("Failed to generate Clash.Meta: %v", err)
+	}
+	if !strings.Contains(meta, "    packet-encoding: xudp\n") {
+		t.Errorf("Expected packet-encoding in Clash.Meta output, got %s", meta)
+	}
+
+	// Xray's "packet" is Sing-box's packetaddr
+	cfg.PacketEncoding = "packet"
+	singbox, err = NewSubscriptionGenerator("singbox").Generate([]*Config{cfg})
+	if err != nil {
+		t.Fatalf("Failed to generate Sing-box: %v", err)
+	}
+	if !strings.Contains(singbox, `"packet_encoding":"packetaddr"`) {
+		t.Errorf("Expected packetaddr in Sing-box output, got %s", singbox)
+	}
+}
+
+// TestSingboxRealityGolden tests that a REALITY VLESS outbound is valid JSON
+// with the fields Sing-box expects, matching testdata/singbox_reality.golden.json
+func TestSingboxRealityGolden(t *testing.T) {
+	link := "vless://12345678-1234-1234-1234-123456789012@reality.example.com:443?encryption=none&security=reality&sni=www.example.com&fp=firefox&pbk=abcdefABCDEF0123456789&sid=6ba85179&type=tcp&reality=yes&flow=xtls-rprx-vision&remark=Reality"
+	cfg, err := NewProtocolParser().ParseConfig(link, "test")
+	if err != nil {
+		t.Fatalf("Failed to parse REALITY link: %v", err)
+	}
+
+	gen := NewSubscriptionGenerator("singbox")
+	sub, err := gen.Generate([]*Config{cfg})
+	if err != nil {
+		t.Fatalf("Failed to generate Sing-box: %v", err)
+	}
+
+	var doc struct {
+		Outbounds []struct {
+			Type string `json:"type"`
+			UUID string `json:"uuid"`
+			Flow string `json:"flow"`
+			TLS  struct {
+				Enabled bool `json:"enabled"`
+				UTLS    struct {
+					Enabled     bool   `json:"enabled"`
+					Fingerprint string `json:"fingerprint"`
+				} `json:"utls"`
+				Reality struct {
+					Enabled   bool   `json:"enabled"`
+					PublicKey string `json:"public_key"`
+					ShortID   string `json:"short_id"`
+				} `json:"reality"`
+			} `json:"tls"`
+		} `json:"outbounds"`
+	}
+	if err := json.Unmarshal([]byte(sub), &doc); err != nil {
+		t.Fatalf("Expected valid JSON, got %v in %s", err, sub)
+	}
+	if len(doc.Outbounds) != 1 {
+		t.Fatalf("Expected 1 outbound, got %d", len(doc.Outbounds))
+	}
+
+	out := doc.Outbounds[0]
+	if out.Type != "vless" || out.UUID != "12345678-1234-1234-1234-123456789012" || out.Flow != "xtls-rprx-vision" {
+		t.Errorf("Expected vless with uuid and flow, got type %q uuid %q flow %q", out.Type, out.UUID, out.Flow)
+	}
+	if !out.TLS.Enabled || !out.TLS.Reality.Enabled || out.TLS.Reality.PublicKey != "abcdefABCDEF0123456789" || out.TLS.Reality.ShortID != "6ba85179" {
+		t.Errorf("Expected an enabled REALITY block with the key and short ID, got %+v", out.TLS)
+	}
+	if !out.TLS.UTLS.Enabled || out.TLS.UTLS.Fingerprint != "firefox" {
+		t.Errorf("Expected uTLS with the link's firefox fingerprint, got %+v", out.TLS.UTLS)
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(sub), "", "  "); err != nil {
+		t.Fatalf("Failed to indent output: %v", err)
+	}
+	indented.WriteByte('\n')
+
+	golden := filepath.Join("testdata", "singbox_reality.golden.json")
+	if *updateGolden {
+		if err := os.WriteFile(golden, indented.Bytes(), 0644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %v", err)
+	}
+	if indented.String() != string(expected) {
+		t.Errorf("Output differs from %s (rerun with -update to accept):\n%s", golden, indented.String())
+	}
+}
+
+// TestV2RaySubscription tests that v2ray output has one working share link
+// per config, and that base64 output is the same list encoded
+func TestV2RaySubscription(t *testing.T) {
+	configs := []*Config{
+		{ID: "vmess-1", Protocol: "vmess", Server: "vm.example.com", Port: 8443, UUID: "uuid-vm", AlterId: 2, TransportType: "ws", HTTPPath: "/ws", Security: "tls", ServerName: "vm.example.com", Name: "VMess"},
+		{ID: "vless-1", Protocol: "vless", Server: "vl.example.com", Port: 443, UUID: "uuid-vl", Security: "tls", ServerName: "vl.example.com", Name: "VLESS"},
+		{ID: "trojan-1", Protocol: "trojan", Server: "tj.example.com", Port: 443, Password: "pass", TLSServerName: "tj.example.com", Name: "Trojan"},
+		{ID: "ss-1", Protocol: "ss", Server: "ss.example.com", Port: 8388, Password: "secret", Method: "aes-256-gcm", Cipher: "aes-256-gcm", Name: "SS"},
+	}
+
+	sub, err := NewSubscriptionGenerator("v2ray").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate V2Ray subscription: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(sub), "\n")
+	if len(lines) != len(configs) {
+		t.Fatalf("Expected %d links, got %d in %s", len(configs), len(lines), sub)
+	}
+
+	schemes := []string{"vmess://", "vless://", "trojan://", "ss://"}
+	parser := NewProtocolParser()
+	for i, line := range lines {
+		if !strings.HasPrefix(line, schemes[i]) {
+			t.Errorf("Expected link %d to start with %s, got %s", i, schemes[i], line)
+		}
+		parsed, err := parser.ParseConfig(line, "v2ray")
+		if err != nil {
+			t.Errorf("Failed to re-parse %s: %v", line, err)
+			continue
+		}
+		if parsed.Server != configs[i].Server || parsed.Port != configs[i].Port {
+			t.Errorf("Expected %s:%d, got %s:%d", configs[i].Server, configs[i].Port, parsed.Server, parsed.Port)
+		}
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(lines[0], "vmess://"))
+	if err != nil {
+		t.Fatalf("Failed to decode VMess payload: %v", err)
+	}
+	var vmess map[string]string
+	if err := json.Unmarshal(payload, &vmess); err != nil {
+		t.Fatalf("Failed to decode VMess JSON: %v", err)
+	}
+	expected := map[string]string{"add": "vm.example.com", "port": "8443", "id": "uuid-vm", "aid": "2", "net": "ws", "path": "/ws", "tls": "tls"}
+	for key, value := range expected {
+		if vmess[key] != value {
+			t.Errorf("Expected VMess %s %q, got %q", key, value, vmess[key])
+		}
+	}
+
+	encoded, err := NewSubscriptionGenerator("base64").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate base64 subscription: %v", err)
+	}
+	decoded, err := DecodeBase64(strings.TrimSpace(encoded))
+	if err != nil {
+		t.Fatalf("Failed to decode base64 subscription: %v", err)
+	}
+	if decoded != strings.TrimSpace(sub) {
+		t.Errorf("Expected base64 output to encode the v2ray link list")
+	}
+}
+
+// TestSingboxValidJSON tests that Sing-box output for every protocol is valid
+// JSON with quoted keys, escaped names and a single TLS block per outbound
+func TestSingboxValidJSON(t *testing.T) {
+	configs := []*Config{
+		{ID: "vless-1", Protocol: "vless", Server: "vl.example.com", Port: 443, UUID: "uuid-vl", Flow: "xtls-rprx-vision", Security: "tls", ServerName: "vl.example.com", Name: `Quote "q" \ back`},
+		{ID: "vmess-1", Protocol: "vmess", Server: "vm.example.com", Port: 443, UUID: "uuid-vm", AlterId: 1, Cipher: "auto", HTTPMethod: "GET", HTTPHost: "cdn.example.com", Name: "VMess <&>"},
+		{ID: "trojan-1", Protocol: "trojan", Server: "tj.example.com", Port: 443, Password: "pass", TLSServerName: "tj.example.com", AllowInsecure: true, Name: "Trojan\tTab"},
+		{ID: "ss-1", Protocol: "ss", Server: "ss.example.com", Port: 8388, Password: "secret", Method: "aes-256-gcm", Plugin: "obfs-local;obfs=http", Name: "SS\nNewline"},
+		{ID: "grpc-1", Protocol: "vless", Server: "grpc.example.com", Port: 443, UUID: "uuid-g", TransportType: "grpc", GRPCServiceName: "tunnel", Name: "gRPC"},
+	}
+
+	sub, err := NewSubscriptionGenerator("singbox").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate Sing-box: %v", err)
+	}
+
+	var doc struct {
+		Outbounds []map[string]interface{} `json:"outbounds"`
+	}
+	if err := json.Unmarshal([]byte(sub), &doc); err != nil {
+		t.Fatalf("Expected valid JSON, got %v in %s", err, sub)
+	}
+	if len(doc.Outbounds) != len(configs) {
+		t.Fatalf("Expected %d outbounds, got %d", len(configs), len(doc.Outbounds))
+	}
+
+	types := []string{"vless", "vmess", "trojan", "shadowsocks", "vless"}
+	for i, out := range doc.Outbounds {
+		if out["tag"] != configs[i].Name {
+			t.Errorf("Expected tag %q, got %q", configs[i].Name, out["tag"])
+		}
+		if out["type"] != types[i] {
+			t.Errorf("Expected type %q for %s, got %q", types[i], configs[i].Name, out["type"])
+		}
+	}
+	if doc.Outbounds[0]["uuid"] != "uuid-vl" || doc.Outbounds[0]["flow"] != "xtls-rprx-vision" {
+		t.Errorf("Expected VLESS uuid and flow, got %v", doc.Outbounds[0])
+	}
+	if doc.Outbounds[1]["alter_id"] != float64(1) || doc.Outbounds[1]["security"] != "auto" {
+		t.Errorf("Expected VMess alter_id and security, got %v", doc.Outbounds[1])
+	}
+
+	tls, _ := doc.Outbounds[2]["tls"].(map[string]interface{})
+	if tls["server_name"] != "tj.example.com" || tls["insecure"] != true {
+		t.Errorf("Expected one Trojan TLS block with server_name and insecure, got %v", doc.Outbounds[2]["tls"])
+	}
+	if trojan := strings.Split(sub, `"type":"trojan"`)[1]; strings.Count(strings.Split(trojan, `"type":"shadowsocks"`)[0], `"tls":`) != 1 {
+		t.Errorf("Expected a single tls key on the Trojan outbound, got %s", sub)
+	}
+
+	if doc.Outbounds[3]["plugin"] != "obfs-local" || doc.Outbounds[3]["plugin_opts"] != "obfs=http" {
+		t.Errorf("Expected the Shadowsocks plugin, got %v", doc.Outbounds[3])
+	}
+}
+
+// TestClashYAMLEscaping tests that names and other string fields holding
+// YAML-reserved characters read back unchanged through a YAML parser
+func TestClashYAMLEscaping(t *testing.T) {
+	configs := []*Config{
+		{Protocol: "trojan", Server: "tj.example.com", Port: 443, Password: "#secret: \"x\"", TLSServerName: "*.example.com", Name: "Node: \"Tehran\" #1"},
+		{Protocol: "ss", Server: "ss.example.com", Port: 8388, Password: "!bang", Method: "aes-256-gcm", Plugin: "obfs-local;obfs=http;obfs-host=@cdn.example.com", Name: "!important 🇮🇷 سرور"},
+		{Protocol: "vless", Server: "vl.example.com", Port: 443, UUID: "uuid-vl", Security: "reality", PublicKey: "key-1", ShortID: "12345678", ServerName: "www.example.com", Name: "🚀 Fast"},
+		{Protocol: "vmess", Server: "vm.example.com", Port: 443, UUID: "uuid-vm", TransportType: "ws", HTTPPath: "/ws?ed=2048 #tag", HTTPHost: "yes", Name: "true"},
+	}
+
+	clash, err := NewSubscriptionGenerator("clash-meta").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+
+	var doc struct {
+		Proxies []struct {
+			Name        string `yaml:"name"`
+			Password    string `yaml:"password"`
+			SNI         string `yaml:"sni"`
+			RealityOpts struct {
+				ShortID string `yaml:"short-id"`
+			} `yaml:"reality-opts"`
+			PluginOpts struct {
+				Host string `yaml:"host"`
+			} `yaml:"plugin-opts"`
+			WSOpts struct {
+				Path    string            `yaml:"path"`
+				Headers map[string]string `yaml:"headers"`
+			} `yaml:"ws-opts"`
+		} `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal([]byte(clash), &doc); err != nil {
+		t.Fatalf("Failed to read Clash output as YAML: %v\n%s", err, clash)
+	}
+	if len(doc.Proxies) != len(configs) {
+		t.Fatalf("Expected %d proxies, got %d", len(configs), len(doc.Proxies))
+	}
+
+	for i, proxy := range doc.Proxies {
+		if proxy.Name != configs[i].Name {
+			t.Errorf("Expected name %q, got %q", configs[i].Name, proxy.Name)
+		}
+	}
+	if doc.Proxies[0].Password != configs[0].Password || doc.Proxies[0].SNI != "*.example.com" {
+		t.Errorf("Expected Trojan password and SNI unchanged, got %q and %q", doc.Proxies[0].Password, doc.Proxies[0].SNI)
+	}
+	if doc.Proxies[1].Password != "!bang" || doc.Proxies[1].PluginOpts.Host != "@cdn.example.com" {
+		t.Errorf("Expected SS password and plugin host unchanged, got %q and %q", doc.Proxies[1].Password, doc.Proxies[1].PluginOpts.Host)
+	}
+	if !strings.Contains(clash, `short-id: "12345678"`) || doc.Proxies[2].RealityOpts.ShortID != "12345678" {
+		t.Errorf("Expected a numeric short-id to stay a string, got:\n%s", clash)
+	}
+	if doc.Proxies[3].WSOpts.Path != configs[3].HTTPPath || doc.Proxies[3].WSOpts.Headers["Host"] != "yes" {
+		t.Errorf("Expected WebSocket path and host unchanged, got %q and %q", doc.Proxies[3].WSOpts.Path, doc.Proxies[3].WSOpts.Headers["Host"])
+	}
+}
+
+// TestClashBase64Wrap tests that -base64 Clash output decodes to the plain YAML
+func TestClashBase64Wrap(t *testing.T) {
+	configs := []*Config{
+		{Protocol: "trojan", Server: "tj.example.com", Port: 443, Password: "pass", TLSServerName: "tj.example.com", Name: "Trojan"},
+		{Protocol: "ss", Server: "ss.example.com", Port: 8388, Password: "secret", Method: "aes-256-gcm", Name: "SS"},
+	}
+
+	plain, err := NewSubscriptionGenerator("clash-meta").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+
+	gen := NewSubscriptionGenerator("clash-meta")
+	gen.SetBase64(true)
+	wrapped, err := gen.Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate base64 Clash: %v", err)
+	}
+	if strings.Contains(wrapped, "proxies:") {
+		t.Fatalf("Expected base64 output, got plain YAML")
+	}
+
+	decoded, err := DecodeBase64(strings.TrimSpace(wrapped))
+	if err != nil {
+		t.Fatalf("Failed to decode base64 Clash: %v", err)
+	}
+	if decoded != plain {
+		t.Errorf("Expected decoded output to match plain YAML\nexpected:\n%s\ngot:\n%s", plain, decoded)
+	}
+
+	// Other formats are unaffected
+	v2ray := NewSubscriptionGenerator("v2ray")
+	v2ray.SetBase64(true)
+	links, err := v2ray.Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate V2Ray: %v", err)
+	}
+	if !strings.HasPrefix(links, "trojan://") {
+		t.Errorf("Expected plain share links for v2ray, got %s", links)
+	}
+}
+
+// TestKCPTransportRoundTrip tests that an mKCP VMess link keeps its seed
+// and header through share link output, and that Clash.Meta and Sing-box,
+// which have no mKCP transport, skip it
+func TestKCPTransportRoundTrip(t *testing.T) {
+	payload := `{"v":"2","ps":"KCP","add":"kcp.example.com","port":"4443","id":"12345678-1234-1234-1234-123456789012","aid":"0","net":"kcp","type":"wechat-video","path":"s3cr3t-seed"}`
+	link := "vmess://" + base64.StdEncoding.EncodeToString([]byte(payload))
+
+	parser := NewProtocolParser()
+	cfg, err := parser.ParseConfig(link, "test-source")
+	if err != nil {
+		t.Fatalf("Failed to parse kcp VMess link: %v", err)
+	}
+	if cfg.TransportType != "kcp" || cfg.KCPSeed != "s3cr3t-seed" || cfg.KCPHeaderType != "wechat-video" {
+		t.Fatalf("Expected kcp with seed s3cr3t-seed and header wechat-video, got %s %q %q", cfg.TransportType, cfg.KCPSeed, cfg.KCPHeaderType)
+	}
+
+	sub, err := NewSubscriptionGenerator("v2ray").Generate([]*Config{cfg})
+	if err != nil {
+		t.Fatalf("Failed to generate V2Ray subscription: %v", err)
+	}
+	again, err := parser.ParseConfig(strings.TrimSpace(sub), "v2ray")
+	if err != nil {
+		t.Fatalf("Failed to re-parse %s: %v", sub, err)
+	}
+	if again.KCPSeed != cfg.KCPSeed || again.KCPHeaderType != cfg.KCPHeaderType {
+		t.Errorf("Expected seed %q and header %q, got %q and %q", cfg.KCPSeed, cfg.KCPHeaderType, again.KCPSeed, again.KCPHeaderType)
+	}
+
+	vless, err := parser.ParseConfig("vless://12345678-1234-1234-1234-123456789012@kcp.example.com:4443?type=kcp&seed=s3cr3t-seed&headerType=srtp#KCP", "test-source")
+	if err != nil {
+		t.Fatalf("Failed to parse kcp VLESS link: %v", err)
+	}
+	if !strings.Contains(vless.String(), "seed=s3cr3t-seed") {
+		t.Errorf("Expected the VLESS share link to keep the seed, got %s", vless.String())
+	}
+
+	for _, format := range []string{"clash-meta", "singbox"} {
+		out, err := NewSubscriptionGenerator(format).Generate([]*Config{cfg})
+		if err != nil {
+			t.Fatalf("Failed to generate %s: %v", format, err)
+		}
+		if strings.Contains(out, "kcp.example.com") {
+			t.Errorf("Expected %s to skip the kcp node, got %s", format, out)
+		}
+	}
+}
+
+// TestVLESSWebSocketTransport tests that ws and gRPC transports from share
+// links reach Clash and Sing-box output
+func TestVLESSWebSocketTransport(t *testing.T) {
+	parser := NewProtocolParser()
+
+	ws, err := parser.ParseConfig("vless://12345678-1234-1234-1234-123456789012@example.com:443?type=ws&path=/vpn&host=cdn.example.com#WS", "test-source")
+	if err != nil {
+		t.Fatalf("Failed to parse VLESS ws link: %v", err)
+	}
+	if ws.TransportType != "ws" || ws.HTTPPath != "/vpn" || ws.HTTPHost != "cdn.example.com" {
+		t.Fatalf("Expected ws with path /vpn and host cdn.example.com, got %s %q %q", ws.TransportType, ws.HTTPPath, ws.HTTPHost)
+	}
+
+	grpcPayload := `{"v":"2","ps":"GRPC","add":"example.com","port":"443","id":"12345678-1234-1234-1234-123456789012","aid":"0","net":"grpc","path":"svc","tls":"tls","sni":"cdn.example.com"}`
+	grpc, err := parser.ParseConfig("vmess://"+base64.StdEncoding.EncodeToString([]byte(grpcPayload)), "test-source")
+	if err != nil {
+		t.Fatalf("Failed to parse VMess gRPC link: %v", err)
+	}
+	if grpc.TransportType != "grpc" || grpc.GRPCServiceName != "svc" {
+		t.Fatalf("Expected grpc with service svc, got %s %q", grpc.TransportType, grpc.GRPCServiceName)
+	}
+
+	clash, err := NewSubscriptionGenerator("clash-meta").Generate([]*Config{ws, grpc})
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+	var doc struct {
+		Proxies []struct {
+			Network string `yaml:"network"`
+			WSOpts  struct {
+				Path    string            `yaml:"path"`
+				Headers map[string]string `yaml:"headers"`
+			} `yaml:"ws-opts"`
+			GRPCOpts struct {
+				ServiceName string `yaml:"grpc-service-name"`
+			} `yaml:"grpc-opts"`
+		} `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal([]byte(clash), &doc); err != nil {
+		t.Fatalf("Failed to parse Clash output: %v", err)
+	}
+	if len(doc.Proxies) != 2 {
+		t.Fatalf("Expected 2 proxies, got %d", len(doc.Proxies))
+	}
+	if p := doc.Proxies[0]; p.Network != "ws" || p.WSOpts.Path != "/vpn" || p.WSOpts.Headers["Host"] != "cdn.example.com" {
+		t.Errorf("Expected Clash ws-opts with path /vpn and Host cdn.example.com, got %+v", p)
+	}
+	if p := doc.Proxies[1]; p.Network != "grpc" || p.GRPCOpts.ServiceName != "svc" {
+		t.Errorf("Expected Clash grpc-opts with service svc, got %+v", p)
+	}
+
+	singbox, err := NewSubscriptionGenerator("singbox").Generate([]*Config{ws, grpc})
+	if err != nil {
+		t.Fatalf("Failed to generate Sing-box: %v", err)
+	}
+	var sb singboxConfig
+	if err := json.Unmarshal([]byte(singbox), &sb); err != nil {
+		t.Fatalf("Failed to parse Sing-box output: %v", err)
+	}
+	if len(sb.Outbounds) != 2 {
+		t.Fatalf("Expected 2 outbounds, got %d", len(sb.Outbounds))
+	}
+	if tr := sb.Outbounds[0].Transport; tr == nil || tr.Type != "ws" || tr.Path != "/vpn" || tr.Headers["Host"] != "cdn.example.com" {
+		t.Errorf("Expected Sing-box ws transport with path /vpn and Host cdn.example.com, got %+v", tr)
+	}
+	out := sb.Outbounds[1]
+	if out.Transport == nil || out.Transport.Type != "grpc" || out.Transport.ServiceName != "svc" {
+		t.Errorf("Expected Sing-box grpc transport with service svc, got %+v", out.Transport)
+	}
+	if out.TLS == nil || out.TLS.ServerName != "cdn.example.com" {
+		t.Errorf("Expected Sing-box TLS for the VMess tls link, got %+v", out.TLS)
+	}
+}
+
+// TestClashUpdateInterval tests that -update-interval adds a
+// Profile-Update-Interval hint, in whole hours, to Clash output only
+func TestClashUpdateInterval(t *testing.T) {
+	configs := []*Config{
+		{Protocol: "trojan", Server: "tj.example.com", Port: 443, Password: "pass", TLSServerName: "tj.example.com", Name: "Trojan"},
+	}
+
+	tests := []struct {
+		format   string
+		interval time.Duration
+		hint     string
+	}{
+		{"clash-meta", 12 * time.Hour, "# Profile-Update-Interval: 12\n"},
+		{"clash", 90 * time.Minute, "# Profile-Update-Interval: 2\n"},
+		{"clash-meta", 0, ""},
+		{"singbox", 12 * time.Hour, ""},
+	}
+
+	for _, tt := range tests {
+		gen := NewSubscriptionGenerator(tt.format)
+		gen.SetUpdateInterval(tt.interval)
+		out, err := gen.Generate(configs)
+		if err != nil {
+			t.Fatalf("Failed to generate %s: %v", tt.format, err)
+		}
+
+		if tt.hint == "" {
+			if strings.Contains(out, "Profile-Update-Interval") {
+				t.Errorf("Expected no update hint in %s output with interval %v, got:\n%s", tt.format, tt.interval, out)
+			}
+			continue
+		}
+		if !strings.HasPrefix(out, tt.hint) {
+			t.Errorf("Expected %s output to start with %q, got:\n%s", tt.format, tt.hint, out)
+		}
+	}
+}
+
+// TestURIJSONFormat tests that uri-json emits one share link per config as
+// a JSON array, each of which parses back
+func TestURIJSONFormat(t *testing.T) {
+	configs := []*Config{
+		{Protocol: "vless", Server: "vl.example.com", Port: 443, UUID: "12345678-1234-1234-1234-123456789012", Security: "tls", ServerName: "vl.example.com", Name: "VLESS & Co"},
+		{Protocol: "trojan", Server: "tj.example.com", Port: 443, Password: "pass", TLSServerName: "tj.example.com", Name: "Trojan"},
+		{Protocol: "ss", Server: "ss.example.com", Port: 8388, Password: "secret", Method: "aes-256-gcm", Name: "SS"},
+	}
+
+	out, err := NewSubscriptionGenerator("uri-json").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate uri-json: %v", err)
+	}
+
+	var links []string
+	if err := json.Unmarshal([]byte(out), &links); err != nil {
+		t.Fatalf("Expected a JSON string array, got %s: %v", out, err)
+	}
+	if len(links) != len(configs) {
+		t.Fatalf("Expected %d links, got %d", len(configs), len(links))
+	}
+
+	parser := NewProtocolParser()
+	for i, link := range links {
+		parsed, err := parser.ParseConfig(link, "uri-json")
+		if err != nil {
+			t.Errorf("Failed to re-parse %s: %v", link, err)
+			continue
+		}
+		if parsed.Server != configs[i].Server || parsed.Name != configs[i].Name {
+			t.Errorf("Expected %s (%s), got %s (%s)", configs[i].Server, configs[i].Name, parsed.Server, parsed.Name)
+		}
+	}
+
+	empty, err := NewSubscriptionGenerator("uri-json").Generate(nil)
+	if err != nil || strings.TrimSpace(empty) != "[]" {
+		t.Errorf("Expected an empty array for no configs, got %q (%v)", empty, err)
+	}
+}
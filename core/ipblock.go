@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// IPBlocklist flags configs whose server resolves into a blocked range, such
+// as known honeypot or bad-actor networks
+type IPBlocklist struct {
+	nets        []*net.IPNet
+	concurrency int
+	lookupIP    func(host string) ([]net.IP, error)
+}
+
+// LoadIPBlocklist reads a blocklist file with one CIDR per line. A bare IP
+// blocks that address alone; blank lines and # comments are ignored.
+func LoadIPBlocklist(path string, concurrency int) (*IPBlocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IP blocklist: %w", err)
+	}
+	defer f.Close()
+
+	var nets []*net.IPNet
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		ipNet, err := parseBlockedRange(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read IP blocklist: %w", err)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &IPBlocklist{nets: nets, concurrency: concurrency, lookupIP: net.LookupIP}, nil
+}
+
+// parseBlockedRange parses a CIDR, or a bare IP as a single-address range
+func parseBlockedRange(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP or CIDR %q", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP or CIDR %q", s)
+	}
+	return ipNet, nil
+}
+
+// Blocked reports whether any address the server resolves to is in a
+// blocked range. Servers that cannot be resolved are not blocked.
+func (b *IPBlocklist) Blocked(server string) bool {
+	ips := []net.IP{net.ParseIP(server)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = b.lookupIP(server); err != nil {
+			return false
+		}
+	}
+
+	for _, ip := range ips {
+		for _, ipNet := range b.nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check resolves the server of every config and returns those in a blocked
+// range
+func (b *IPBlocklist) Check(configs []*Config) []*Config {
+	if len(b.nets) == 0 {
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var blocked []*Config
+
+	sem := make(chan struct{}, b.concurrency)
+	for _, cfg := range configs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(cfg *Config) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if b.Blocked(cfg.Server) {
+				mu.Lock()
+				blocked = append(blocked, cfg)
+				mu.Unlock()
+			}
+		}(cfg)
+	}
+	wg.Wait()
+
+	return blocked
+}
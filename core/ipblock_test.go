@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestIPBlocklist tests that configs in a blocked CIDR are dropped and
+// others kept, including hostnames that resolve into a blocked range
+func TestIPBlocklist(t *testing.T) {
+	path := writeTestFile(t, "blocklist.txt", `
+# Known honeypots
+203.0.113.0/24
+198.51.100.7   # single address
+2001:db8::/32
+`)
+
+	blocklist, err := LoadIPBlocklist(path, 4)
+	if err != nil {
+		t.Fatalf("Failed to load blocklist: %v", err)
+	}
+	blocklist.lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "honeypot.example.com":
+			return []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("203.0.113.50")}, nil
+		case "clean.example.com":
+			return []net.IP{net.ParseIP("192.0.2.1")}, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+
+	configs := []*Config{
+		{Name: "blocked /24", Server: "203.0.113.9"},
+		{Name: "outside /24", Server: "203.0.114.9"},
+		{Name: "blocked address", Server: "198.51.100.7"},
+		{Name: "next address", Server: "198.51.100.8"},
+		{Name: "blocked IPv6", Server: "2001:db8::1"},
+		{Name: "blocked hostname", Server: "honeypot.example.com"},
+		{Name: "clean hostname", Server: "clean.example.com"},
+		{Name: "unresolvable", Server: "missing.example.com"},
+	}
+
+	kept := excludeConfigs(configs, blocklist.Check(configs))
+	var names []string
+	for _, cfg := range kept {
+		names = append(names, cfg.Name)
+	}
+
+	expected := []string{"outside /24", "next address", "clean hostname", "unresolvable"}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v kept, got %v", expected, names)
+	}
+}
+
+// TestIPBlocklistInvalidEntry tests that a malformed line is reported with
+// its line number
+func TestIPBlocklistInvalidEntry(t *testing.T) {
+	path := writeTestFile(t, "blocklist.txt", "203.0.113.0/24\n203.0.113.0/33\n")
+
+	if _, err := LoadIPBlocklist(path, 1); err == nil {
+		t.Errorf("Expected error for invalid CIDR")
+	} else if want := fmt.Sprintf("%s:2:", path); !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Expected error to start with %s, got %v", want, err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+// jsonDialect is the client whose native field names a JSON body uses
+type jsonDialect int
+
+const (
+	// jsonDialectShareLink is the share-link field naming parseJSONConfig
+	// reads: v2rayN's for VMess (add, id, ps), and server/port/uuid for the rest
+	jsonDialectShareLink jsonDialect = iota
+	// jsonDialectClash is a Clash proxies list
+	jsonDialectClash
+	// jsonDialectSingbox is a Sing-box outbounds list
+	jsonDialectSingbox
+)
+
+// jsonListDialects maps the key of an object body holding the config array
+// to the dialect of its entries, in lookup order
+var jsonListDialects = []struct {
+	key     string
+	dialect jsonDialect
+}{
+	{"proxies", jsonDialectClash},
+	{"outbounds", jsonDialectSingbox},
+}
+
+// clashFieldNames renames Clash proxy fields, per type, to the share-link
+// names. Clash already uses server, port, uuid and password.
+var clashFieldNames = map[string]map[string]string{
+	"vmess": {"name": "ps", "server": "add", "uuid": "id", "alterId": "aid", "servername": "sni", "network": "net"},
+	"vless": {"servername": "sni"},
+	"ss":    {"name": "remarks", "cipher": "method"},
+}
+
+// singboxFieldNames renames Sing-box outbound fields, per type, to the
+// share-link names. Sing-box names the VMess cipher security.
+var singboxFieldNames = map[string]map[string]string{
+	"vmess":       {"tag": "ps", "server": "add", "server_port": "port", "uuid": "id", "alter_id": "aid", "security": "cipher"},
+	"vless":       {"tag": "name", "server_port": "port"},
+	"trojan":      {"tag": "name", "server_port": "port"},
+	"shadowsocks": {"tag": "remarks", "server_port": "port"},
+}
+
+// singboxNonProxyTypes are Sing-box outbounds that route or group traffic
+// rather than describe a server
+var singboxNonProxyTypes = map[string]bool{
+	"direct":   true,
+	"block":    true,
+	"dns":      true,
+	"selector": true,
+	"urltest":  true,
+}
+
+// isJSONProxyEntry reports whether a list entry describes a server; Sing-box
+// outbounds lists also hold direct, block and group outbounds
+func isJSONProxyEntry(entry interface{}, dialect jsonDialect) bool {
+	if dialect != jsonDialectSingbox {
+		return true
+	}
+	m, ok := entry.(map[string]interface{})
+	if !ok {
+		return true
+	}
+	typ, _ := m["type"].(string)
+	return !singboxNonProxyTypes[typ]
+}
+
+// shareLinkJSONFields translates a Clash proxy or Sing-box outbound to the
+// share-link field names parseJSONConfig reads, including the TLS settings
+// both nest or flag differently. Other entries are returned unchanged.
+func shareLinkJSONFields(entry interface{}, dialect jsonDialect) interface{} {
+	m, ok := entry.(map[string]interface{})
+	if !ok || dialect == jsonDialectShareLink {
+		return entry
+	}
+
+	typ, _ := m["type"].(string)
+	names := clashFieldNames[typ]
+	if dialect == jsonDialectSingbox {
+		names = singboxFieldNames[typ]
+	}
+
+	out := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		if renamed, ok := names[key]; ok {
+			key = renamed
+		}
+		out[key] = value
+	}
+
+	switch dialect {
+	case jsonDialectClash:
+		if ws, ok := m["ws-opts"].(map[string]interface{}); ok {
+			setJSONTransport(out, "", ws["path"], ws["headers"])
+		}
+		if grpc, ok := m["grpc-opts"].(map[string]interface{}); ok {
+			setJSONTransport(out, "", grpc["grpc-service-name"], nil)
+		}
+		if enabled, _ := m["tls"].(bool); enabled {
+			setJSONTLS(out, typ, "tls")
+		}
+		if _, ok := m["reality-opts"].(map[string]interface{}); ok {
+			setJSONTLS(out, typ, "reality")
+		}
+	case jsonDialectSingbox:
+		if transport, ok := m["transport"].(map[string]interface{}); ok {
+			delete(out, "transport")
+			path := transport["path"]
+			if transport["type"] == "grpc" {
+				path = transport["service_name"]
+			}
+			setJSONTransport(out, transport["type"], path, transport["headers"])
+		}
+		if tls, ok := m["tls"].(map[string]interface{}); ok {
+			delete(out, "tls")
+			if enabled, _ := tls["enabled"].(bool); enabled {
+				security := "tls"
+				if reality, ok := tls["reality"].(map[string]interface{}); ok {
+					if enabled, _ := reality["enabled"].(bool); enabled {
+						security = "reality"
+					}
+				}
+				setJSONTLS(out, typ, security)
+			}
+			if sni, ok := tls["server_name"].(string); ok {
+				out["sni"] = sni
+			}
+		}
+	}
+
+	return out
+}
+
+// setJSONTLS records TLS the way each share-link JSON parser reads it: VMess
+// as tls=tls, VLESS as its security type. Trojan is always TLS.
+func setJSONTLS(out map[string]interface{}, typ, security string) {
+	switch typ {
+	case "vmess":
+		out["tls"] = "tls"
+	case "vless":
+		out["security"] = security
+	default:
+		delete(out, "tls")
+	}
+}
+
+// setJSONTransport records a transport the way the VMess share-link JSON
+// reads it: net for the type, path for the WebSocket path or gRPC service
+// name, and host for the Host header
+func setJSONTransport(out map[string]interface{}, network, path, headers interface{}) {
+	if network, ok := network.(string); ok && network != "" {
+		out["net"] = network
+	}
+	if path, ok := path.(string); ok && path != "" {
+		out["path"] = path
+	}
+	if headers, ok := headers.(map[string]interface{}); ok {
+		if host, ok := headers["Host"].(string); ok && host != "" {
+			out["host"] = host
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import "testing"
+
+// TestParseClashProxiesJSON tests that a Clash document's proxies are read
+// with Clash's own field names
+func TestParseClashProxiesJSON(t *testing.T) {
+	body := `{
+  "port": 7890,
+  "mode": "rule",
+  "proxies": [
+    {"name": "VMess WS", "type": "vmess", "server": "vmess.example.com", "port": 8443, "uuid": "11111111-1111-1111-1111-111111111111", "alterId": 0, "cipher": "aes-128-gcm", "tls": true, "servername": "cdn.example.com", "network": "ws", "ws-opts": {"path": "/ray", "headers": {"Host": "cdn.example.com"}}},
+    {"name": "VLESS Reality", "type": "vless", "server": "vless.example.com", "port": 443, "uuid": "22222222-2222-2222-2222-222222222222", "flow": "xtls-rprx-vision", "tls": true, "servername": "www.microsoft.com", "reality-opts": {"public-key": "pbk", "short-id": "ab"}},
+    {"name": "Trojan", "type": "trojan", "server": "trojan.example.com", "port": 443, "password": "trojan-pass", "sni": "trojan.example.com"},
+    {"name": "SS", "type": "ss", "server": "ss.example.com", "port": 8388, "cipher": "aes-256-gcm", "password": "ss-pass"}
+  ],
+  "proxy-groups": [{"name": "Proxy", "type": "select", "proxies": ["VMess WS", "SS"]}]
+}`
+
+	agg := newTestAggregator(t, nil, 100)
+	configs, err := agg.parseJSONConfigs([]byte(body), "clash-source")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(configs) != 4 {
+		t.Fatalf("Expected 4 configs, got %d", len(configs))
+	}
+
+	vmess := configs[0]
+	if vmess.Protocol != "vmess" || vmess.Server != "vmess.example.com" || vmess.Port != 8443 || vmess.UUID != "11111111-1111-1111-1111-111111111111" {
+		t.Errorf("Expected vmess vmess.example.com:8443 with its uuid, got %s %s:%d %q", vmess.Protocol, vmess.Server, vmess.Port, vmess.UUID)
+	}
+	if vmess.Name != "VMess WS" || vmess.Cipher != "aes-128-gcm" {
+		t.Errorf("Expected name VMess WS and cipher aes-128-gcm, got %q and %q", vmess.Name, vmess.Cipher)
+	}
+	if vmess.TransportType != "ws" || vmess.HTTPPath != "/ray" || vmess.HTTPHost != "cdn.example.com" || vmess.ServerName != "cdn.example.com" || !usesTLS(vmess) {
+		t.Errorf("Expected ws+tls with path /ray and host/sni cdn.example.com, got %s %q %q %q tls=%v", vmess.TransportType, vmess.HTTPPath, vmess.HTTPHost, vmess.ServerName, usesTLS(vmess))
+	}
+
+	vless := configs[1]
+	if vless.Name != "VLESS Reality" || vless.Security != "reality" || vless.ServerName != "www.microsoft.com" || vless.Flow != "xtls-rprx-vision" {
+		t.Errorf("Expected VLESS Reality with sni www.microsoft.com, got %q %q %q %q", vless.Name, vless.Security, vless.ServerName, vless.Flow)
+	}
+
+	if trojan := configs[2]; trojan.Name != "Trojan" || trojan.Password != "trojan-pass" || trojan.TLSServerName != "trojan.example.com" {
+		t.Errorf("Expected Trojan with its password and sni, got %q %q %q", trojan.Name, trojan.Password, trojan.TLSServerName)
+	}
+
+	if ss := configs[3]; ss.Name != "SS" || ss.Method != "aes-256-gcm" || ss.Password != "ss-pass" || ss.Port != 8388 {
+		t.Errorf("Expected SS with method aes-256-gcm on 8388, got %q %q %q %d", ss.Name, ss.Method, ss.Password, ss.Port)
+	}
+}
+
+// TestParseSingboxOutboundsJSON tests that a Sing-box document's outbounds
+// are read with Sing-box's own field names, skipping non-proxy outbounds
+func TestParseSingboxOutboundsJSON(t *testing.T) {
+	body := `{
+  "log": {"level": "warn"},
+  "inbounds": [{"type": "mixed", "tag": "mixed-in", "listen": "127.0.0.1", "listen_port": 2080}],
+  "outbounds": [
+    {"type": "selector", "tag": "proxy", "outbounds": ["vmess-grpc", "vless-tls", "trojan", "ss"]},
+    {"type": "vmess", "tag": "vmess-grpc", "server": "vmess.example.com", "server_port": 2053, "uuid": "11111111-1111-1111-1111-111111111111", "security": "chacha20-poly1305", "alter_id": 0, "tls": {"enabled": true, "server_name": "grpc.example.com"}, "transport": {"type": "grpc", "service_name": "gun"}},
+    {"type": "vless", "tag": "vless-tls", "server": "vless.example.com", "server_port": 8443, "uuid": "22222222-2222-2222-2222-222222222222", "tls": {"enabled": true, "server_name": "vless.example.com"}},
+    {"type": "trojan", "tag": "trojan", "server": "trojan.example.com", "server_port": 2083, "password": "trojan-pass", "tls": {"enabled": true, "server_name": "trojan.example.com"}},
+    {"type": "shadowsocks", "tag": "ss", "server": "ss.example.com", "server_port": 9000, "method": "2022-blake3-aes-128-gcm", "password": "ss-pass"},
+    {"type": "direct", "tag": "direct"},
+    {"type": "block", "tag": "block"},
+    {"type": "dns", "tag": "dns-out"}
+  ]
+}`
+
+	agg := newTestAggregator(t, nil, 100)
+	configs, err := agg.parseJSONConfigs([]byte(body), "singbox-source")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(configs) != 4 {
+		t.Fatalf("Expected 4 configs, got %d", len(configs))
+	}
+	if errs := agg.ParseErrors()["singbox-source"]; errs != 0 {
+		t.Errorf("Expected non-proxy outbounds skipped without parse errors, got %d", errs)
+	}
+
+	vmess := configs[0]
+	if vmess.Protocol != "vmess" || vmess.Server != "vmess.example.com" || vmess.Port != 2053 || vmess.UUID != "11111111-1111-1111-1111-111111111111" {
+		t.Errorf("Expected vmess vmess.example.com:2053 with its uuid, got %s %s:%d %q", vmess.Protocol, vmess.Server, vmess.Port, vmess.UUID)
+	}
+	if vmess.Name != "vmess-grpc" || vmess.Cipher != "chacha20-poly1305" {
+		t.Errorf("Expected name vmess-grpc and cipher chacha20-poly1305, got %q and %q", vmess.Name, vmess.Cipher)
+	}
+	if vmess.TransportType != "grpc" || vmess.GRPCServiceName != "gun" || vmess.ServerName != "grpc.example.com" || !usesTLS(vmess) {
+		t.Errorf("Expected grpc+tls with service gun and sni grpc.example.com, got %s %q %q tls=%v", vmess.TransportType, vmess.GRPCServiceName, vmess.ServerName, usesTLS(vmess))
+	}
+
+	if vless := configs[1]; vless.Name != "vless-tls" || vless.Port != 8443 || vless.Security != "tls" || vless.ServerName != "vless.example.com" {
+		t.Errorf("Expected vless-tls on 8443 with tls sni vless.example.com, got %q %d %q %q", vless.Name, vless.Port, vless.Security, vless.ServerName)
+	}
+
+	if trojan := configs[2]; trojan.Name != "trojan" || trojan.Port != 2083 || trojan.TLSServerName != "trojan.example.com" {
+		t.Errorf("Expected trojan on 2083 with sni trojan.example.com, got %q %d %q", trojan.Name, trojan.Port, trojan.TLSServerName)
+	}
+
+	if ss := configs[3]; ss.Name != "ss" || ss.Port != 9000 || ss.Method != "2022-blake3-aes-128-gcm" {
+		t.Errorf("Expected ss on 9000 with method 2022-blake3-aes-128-gcm, got %q %d %q", ss.Name, ss.Port, ss.Method)
+	}
+}
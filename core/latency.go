@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Validation statuses recorded by the latency tester
+const (
+	StatusReachable   = "reachable"
+	StatusUnreachable = "unreachable"
+)
+
+// LatencyTester measures TCP connect latency to config servers
+type LatencyTester struct {
+	timeout     time.Duration
+	concurrency int
+	dial        func(network, address string, timeout time.Duration) (net.Conn, error)
+	progress    *ProgressReporter
+}
+
+// NewLatencyTester creates a latency tester
+func NewLatencyTester(timeout time.Duration, concurrency int) *LatencyTester {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &LatencyTester{
+		timeout:     timeout,
+		concurrency: concurrency,
+		dial:        net.DialTimeout,
+	}
+}
+
+// SetProgress sets the reporter updated as each config finishes testing
+func (lt *LatencyTester) SetProgress(progress *ProgressReporter) {
+	lt.progress = progress
+}
+
+// Test dials every config's server, recording Ping in milliseconds and the
+// ValidationStatus. It returns the configs that could not be reached.
+func (lt *LatencyTester) Test(configs []*Config) []*Config {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []*Config
+
+	lt.progress.Start(len(configs))
+
+	sem := make(chan struct{}, lt.concurrency)
+	for _, cfg := range configs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(cfg *Config) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer lt.progress.Done()
+
+			if lt.testOne(cfg) {
+				return
+			}
+			mu.Lock()
+			failed = append(failed, cfg)
+			mu.Unlock()
+		}(cfg)
+	}
+	wg.Wait()
+
+	return failed
+}
+
+// TestChanged is Test restricted to configs missing from known, a map of
+// fingerprint to cached ping. Known configs are not dialled; they take the
+// cached ping and are marked reachable. It returns the configs that could
+// not be reached and the configs that were actually dialled.
+func (lt *LatencyTester) TestChanged(configs []*Config, known map[string]int) (failed, tested []*Config) {
+	for _, cfg := range configs {
+		if ping, ok := known[cfg.Key()]; ok {
+			cfg.Ping = ping
+			cfg.ValidationStatus = StatusReachable
+			continue
+		}
+		tested = append(tested, cfg)
+	}
+
+	return lt.Test(tested), tested
+}
+
+// testOne dials a single config and records the result
+func (lt *LatencyTester) testOne(cfg *Config) bool {
+	address := net.JoinHostPort(cfg.Server, strconv.Itoa(cfg.Port))
+
+	start := time.Now()
+	conn, err := lt.dial("tcp", address, lt.timeout)
+	if err != nil {
+		cfg.ValidationStatus = StatusUnreachable
+		return false
+	}
+	conn.Close()
+
+	ping := int(time.Since(start).Milliseconds())
+	if ping < 1 {
+		ping = 1
+	}
+	cfg.Ping = ping
+	cfg.ValidationStatus = StatusReachable
+	return true
+}
+
+// filterByLatency drops configs whose Ping exceeds max (0 = no limit).
+// Configs without a Ping, untested or unreachable, are kept unless
+// dropDead is set.
+func filterByLatency(configs []*Config, max time.Duration, dropDead bool) []*Config {
+	limit := int(max.Milliseconds())
+
+	kept := make([]*Config, 0, len(configs))
+	for _, cfg := range configs {
+		if cfg.Ping == 0 {
+			if dropDead {
+				continue
+			}
+		} else if limit > 0 && cfg.Ping > limit {
+			continue
+		}
+		kept = append(kept, cfg)
+	}
+	return kept
+}
+
+// sortByLatency returns a copy of configs ordered fastest first, with
+// untested configs (Ping 0) last. Equal pings are ordered by fingerprint so
+// the result does not depend on fetch order.
+func sortByLatency(configs []*Config) []*Config {
+	keys := make(map[*Config]string, len(configs))
+	for _, cfg := range configs {
+		keys[cfg] = cfg.Key()
+	}
+
+	sorted := make([]*Config, len(configs))
+	copy(sorted, configs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Ping != b.Ping {
+			if a.Ping == 0 || b.Ping == 0 {
+				return b.Ping == 0
+			}
+			return a.Ping < b.Ping
+		}
+		return keys[a] < keys[b]
+	})
+
+	return sorted
+}
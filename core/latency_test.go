@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closedPort returns a local port with no listener
+func closedPort(t *testing.T) int {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
+
+// TestLatencyTesterReachability tests that reachable and unreachable servers are told apart
+func TestLatencyTesterReachability(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	up := &Config{Protocol: "vless", Server: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port}
+	down := &Config{Protocol: "vless", Server: "127.0.0.1", Port: closedPort(t)}
+
+	failed := NewLatencyTester(time.Second, 2).Test([]*Config{up, down})
+
+	if len(failed) != 1 || failed[0] != down {
+		t.Fatalf("Expected only the closed port to fail, got %v", failed)
+	}
+
+	if up.Ping <= 0 || up.ValidationStatus != StatusReachable {
+		t.Errorf("Expected reachable config with ping, got ping=%d status=%q", up.Ping, up.ValidationStatus)
+	}
+
+	if down.ValidationStatus != StatusUnreachable {
+		t.Errorf("Expected status %q, got %q", StatusUnreachable, down.ValidationStatus)
+	}
+}
+
+// TestLearnBlacklistAppendsRule tests that unreachable servers become exclude rules
+func TestLearnBlacklistAppendsRule(t *testing.T) {
+	rules := []FilterRule{
+		{Name: "Existing", Type: "domain", Pattern: "known-bad.com", Action: "exclude", Enabled: true},
+	}
+	failed := []*Config{
+		{Server: "dead.example.com"},
+		{Server: "known-bad.com"},
+		{Server: "dead.example.com"},
+	}
+
+	learned := learnBlacklist(rules, failed)
+
+	if len(learned) != 2 {
+		t.Fatalf("Expected 1 new rule, got %d total: %+v", len(learned), learned)
+	}
+
+	rule := learned[1]
+	if rule.Type != "domain" || rule.Pattern != "dead.example.com" || rule.Action != "exclude" || !rule.Enabled {
+		t.Errorf("Unexpected learned rule: %+v", rule)
+	}
+}
+
+// TestSortByLatencyTiebreak tests that equal pings sort in a repeatable order regardless of input order
+func TestSortByLatencyTiebreak(t *testing.T) {
+	configs := []*Config{
+		{Protocol: "vless", Server: "a.com", Port: 443, UUID: "uuid-a", Ping: 0},
+		{Protocol: "vless", Server: "b.com", Port: 443, UUID: "uuid-b", Ping: 50},
+		{Protocol: "vless", Server: "c.com", Port: 443, UUID: "uuid-c", Ping: 0},
+		{Protocol: "vless", Server: "d.com", Port: 443, UUID: "uuid-d", Ping: 50},
+		{Protocol: "vless", Server: "e.com", Port: 443, UUID: "uuid-e", Ping: 20},
+		{Protocol: "vless", Server: "f.com", Port: 443, UUID: "uuid-f", Ping: 0},
+	}
+
+	expected := sortByLatency(configs)
+	if expected[0].Server != "e.com" {
+		t.Errorf("Expected fastest config first, got %s", expected[0].Server)
+	}
+	for _, cfg := range expected[3:] {
+		if cfg.Ping != 0 {
+			t.Errorf("Expected untested configs last, got ping %d at the end", cfg.Ping)
+		}
+	}
+
+	// Reversed and rotated inputs must sort identically
+	for shift := 0; shift < len(configs); shift++ {
+		shuffled := make([]*Config, len(configs))
+		for i := range configs {
+			shuffled[i] = configs[(len(configs)-1-i+shift)%len(configs)]
+		}
+
+		got := sortByLatency(shuffled)
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("Expected repeatable order, got %s at %d instead of %s", got[i].Server, i, expected[i].Server)
+			}
+		}
+	}
+}
+
+// TestTestChangedSkipsSeen tests that configs with a fresh reachable record are not dialled again
+func TestTestChangedSkipsSeen(t *testing.T) {
+	store, err := OpenConfigStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	seen := &Config{Protocol: "vless", Server: "seen.com", Port: 443, UUID: "uuid-seen", Ping: 42}
+	expired := &Config{Protocol: "vless", Server: "expired.com", Port: 443, UUID: "uuid-expired", Ping: 42}
+	if err := store.Upsert([]*Config{seen}, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to record seen config: %v", err)
+	}
+	if err := store.Upsert([]*Config{expired}, now.Add(-48*time.Hour)); err != nil {
+		t.Fatalf("Failed to record expired config: %v", err)
+	}
+
+	known, err := store.ReachableSince(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to load reachable configs: %v", err)
+	}
+
+	fresh := &Config{Protocol: "vless", Server: "new.com", Port: 443, UUID: "uuid-new"}
+	configs := []*Config{
+		{Protocol: "vless", Server: "seen.com", Port: 443, UUID: "uuid-seen"},
+		{Protocol: "vless", Server: "expired.com", Port: 443, UUID: "uuid-expired"},
+		fresh,
+	}
+
+	var dialled []string
+	tester := NewLatencyTester(time.Second, 1)
+	tester.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		dialled = append(dialled, address)
+		return nil, &net.OpError{Op: "dial", Err: net.UnknownNetworkError("test")}
+	}
+
+	failed, tested := tester.TestChanged(configs, known)
+
+	if len(dialled) != 2 || len(tested) != 2 || len(failed) != 2 {
+		t.Fatalf("Expected only the new and expired configs to be dialled, got %v", dialled)
+	}
+	for _, address := range dialled {
+		if address == "seen.com:443" {
+			t.Errorf("Expected previously seen config to be skipped, got %v", dialled)
+		}
+	}
+
+	if configs[0].Ping != 42 || configs[0].ValidationStatus != StatusReachable {
+		t.Errorf("Expected skipped config to keep cached ping 42, got ping=%d status=%q", configs[0].Ping, configs[0].ValidationStatus)
+	}
+	if fresh.ValidationStatus != StatusUnreachable {
+		t.Errorf("Expected status %q for the new config, got %q", StatusUnreachable, fresh.ValidationStatus)
+	}
+}
+
+// TestFilterByLatency tests dropping configs slower than the threshold,
+// keeping untested ones unless dead configs are dropped too
+func TestFilterByLatency(t *testing.T) {
+	fast := &Config{ID: "fast", Server: "fast.example.com", Ping: 200}
+	slow := &Config{ID: "slow", Server: "slow.example.com", Ping: 700}
+	edge := &Config{ID: "edge", Server: "edge.example.com", Ping: 500}
+	dead := &Config{ID: "dead", Server: "dead.example.com"}
+	configs := []*Config{fast, slow, edge, dead}
+
+	ids := func(configs []*Config) string {
+		var out []string
+		for _, cfg := range configs {
+			out = append(out, cfg.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if got := ids(filterByLatency(configs, 500*time.Millisecond, false)); got != "fast,edge,dead" {
+		t.Errorf("Expected fast,edge,dead at 500ms, got %s", got)
+	}
+	if got := ids(filterByLatency(configs, 500*time.Millisecond, true)); got != "fast,edge" {
+		t.Errorf("Expected fast,edge with dead configs dropped, got %s", got)
+	}
+	if got := ids(filterByLatency(configs, 0, true)); got != "fast,slow,edge" {
+		t.Errorf("Expected only the dead config dropped without a limit, got %s", got)
+	}
+}
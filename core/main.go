@@ -1,146 +1,1019 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
 )
 
+// defaults are the flag values when none are given
+var defaults = DefaultOptions()
+
 var (
-	Mode             = flag.String("mode", "generate", "Mode: generate, fetch, validate")
-	OutputFormat     = flag.String("format", "clash", "Output format: clash, singbox, v2ray, raw")
-	ConfigSourceFile = flag.String("sources", "config/sources.yaml", "Path to config sources file")
-	RulesFile        = flag.String("rules", "config/iran_rules.json", "Path to filtering rules file")
-	OutputFile       = flag.String("output", "subscriptions/main.txt", "Output subscription file path")
-	MaxConfigs       = flag.Int("max", 5000, "Maximum number of configs to process")
-	Verbose          = flag.Bool("v", false, "Verbose output")
+	Mode             = flag.String("mode", defaults.Mode, "Mode: generate, fetch, count, validate, qr, export-db, serve, merge, append")
+	OutputFormat     = flag.String("format", defaultFormat, "Output format: clash-meta, clash (classic, no VLESS/REALITY), singbox, v2ray, raw, base64 (share links, for v2rayNG), uri-json (share links as a JSON array), template")
+	Formats          = flag.String("formats", defaults.Formats, "Comma-separated formats to generate from one fetch, each written next to -output with the format in its name (e.g. main-clash-meta.txt); overrides -format")
+	ConfigSourceFile = flag.String("sources", defaults.Sources, "Path to config sources file, or - to read links from stdin")
+	RulesFile        = flag.String("rules", defaults.Rules, "Filtering rules files (comma-separated paths or globs; later files override rules by name)")
+	TemplateFile     = flag.String("template-file", defaults.TemplateFile, "Go text/template rendered with the configs for -format template")
+	OutputFile       = flag.String("output", defaults.Output, "Output subscription file path")
+	MaxConfigs       = flag.Int("max", defaultMaxConfigs, "Maximum number of configs to process")
+	NoCache          = flag.Bool("no-cache", defaults.NoCache, "Bypass the source cache and always fetch from sources")
+	Concurrency      = flag.Int("concurrency", defaults.Concurrency, "Maximum number of sources fetched in parallel (0 = unlimited)")
+	FailFast         = flag.Bool("fail-fast", defaults.FailFast, "Stop fetch mode at the first source error and report it")
+	MinSources       = flag.Int("min-sources", defaults.MinSources, "Keep only configs found in at least this many distinct sources")
+	DBFile           = flag.String("db", defaults.DB, "SQLite database that export-db mode upserts configs into")
+	Listen           = flag.String("listen", defaults.Listen, "Address serve mode listens on")
+	RefreshInterval  = flag.Duration("refresh-interval", defaults.RefreshInterval, "How often serve mode regenerates the subscription")
+	CacheTTL         = flag.Duration("cache-ttl", defaults.CacheTTL, "How long serve mode treats the last successful refresh as fresh")
+	StaleBehavior    = flag.String("stale-behavior", defaults.StaleBehavior, "What serve mode returns once the subscription is older than -cache-ttl: serve, error (503) or empty")
+	SourceFailLimit  = flag.Int("source-fail-threshold", defaults.SourceFailThreshold, "Skip a source for -source-backoff after this many consecutive failures (0 = never skip)")
+	SourceBackoff    = flag.Duration("source-backoff", defaults.SourceBackoff, "How long a source over -source-fail-threshold is skipped")
+	StateFile        = flag.String("state", defaults.StateFile, "File tracking consecutive source failures across runs")
+	Input            = flag.String("input", defaults.Input, "Share link or file of links to render (qr mode), or comma-separated files to combine (merge mode)")
+	OnlyIDs          = flag.String("only-ids", defaults.OnlyIDs, "Comma-separated config IDs to select from sources (qr mode)")
+	Verbose          = flag.Bool("v", defaults.Verbose, "Verbose output")
+	SortBy           = flag.String("sort", defaults.Sort, "Output order: score (composite ranking) or latency (measured connect time, fastest first)")
+	ScoreWeightSpec  = flag.String("score-weights", defaults.ScoreWeights, "Output ranking weights, e.g. latency=0.5,protocol=0.2,tls=0.2,source=0.1")
+	OutputPolicy     = flag.String("output-policy", defaults.OutputPolicy, "Output completeness policy: lax (server and port) or strict (all protocol fields, e.g. trojan sni)")
+	GroupBy          = flag.String("group-by", defaults.GroupBy, "Extra Clash select groups: none or region (one group per region, e.g. Europe, Asia, Americas)")
+	GroupType        = flag.String("group-type", defaults.GroupType, "Clash proxy group type: select or load-balance")
+	LBStrategy       = flag.String("lb-strategy", defaults.LBStrategy, "Load-balance strategy with -group-type load-balance: round-robin or consistent-hashing")
+	FrontID          = flag.String("front", defaults.Front, "Config ID that other Sing-box outbounds chain through via detour")
+	GeoIPFile        = flag.String("geoip", defaults.GeoIP, "Path to a GeoLite2/GeoIP2 country database for country enrichment")
+	TrailingNewline  = flag.Bool("trailing-newline", defaults.TrailingNewline, "End generated output with a newline")
+	Base64           = flag.Bool("base64", defaults.Base64, "Base64-encode Clash output as a whole, for managed clients that expect an encoded subscription (clash and clash-meta only)")
+	UpdateInterval   = flag.Duration("update-interval", defaults.UpdateInterval, "Tell Clash clients to refresh the subscription this often (e.g. 12h), as a Profile-Update-Interval hint and, in serve mode, header")
+	PruneFormats     = flag.Bool("prune-duplicates-across-formats", defaults.PruneFormats, "With -formats, drop configs any of the formats cannot represent (e.g. TUIC for singbox), so every output carries the same nodes")
+	TLSCheck         = flag.Bool("tls-check", defaults.TLSCheck, "Handshake with TLS configs and drop those with expired certificates")
+	IPBlocklistFile  = flag.String("ip-blocklist", defaults.IPBlocklist, "File of CIDRs (one per line, # comments); configs whose server resolves into one are dropped")
+	CertMinValidity  = flag.Duration("cert-min-validity", defaults.CertMinValidity, "With -tls-check, also drop certificates expiring within this window (e.g. 72h)")
+	OnlyChanged      = flag.Bool("validate-only-changed", defaults.ValidateOnlyChanged, "Only latency-test configs without a reachable record in -db newer than -reachability-ttl")
+	ReachabilityTTL  = flag.Duration("reachability-ttl", defaults.ReachabilityTTL, "How long a reachable record in -db is trusted by -validate-only-changed")
+	Progress         = flag.String("progress", defaults.Progress, "Latency test progress: auto (bar on a terminal, log lines otherwise), bar, log or off")
+	MaxLatency       = flag.Duration("max-latency", defaults.MaxLatency, "Latency-test configs and drop those slower than this (e.g. 500ms); unreachable ones are kept unless -drop-dead (0 = no limit)")
+	DropDead         = flag.Bool("drop-dead", defaults.DropDead, "Latency-test configs and drop those that could not be reached")
+	LearnBlacklist   = flag.Bool("learn-blacklist", defaults.LearnBlacklist, "Add unreachable servers to the rules file as domain excludes")
+	Seed             = flag.Int64("seed", 0, "Seed for reproducible dynamic pattern rotation (random when unset)")
+	Redact           = flag.Bool("redact", defaults.Redact, "Replace UUIDs, passwords and keys in generated output with placeholders, for sharing in bug reports")
+	VMessNameMax     = flag.Int("vmess-name-max", defaults.VMessNameMax, "Truncate names in VMess share links to this many bytes, ending with … (0 = no limit)")
+	ObfuscateSNI     = flag.Bool("obfuscate-sni", defaults.ObfuscateSNI, "Rewrite TLS SNIs through the security module's SNI obfuscation (unchanged in builds without cgo)")
+	GroupTag         = flag.String("group-tag", defaults.GroupTag, "Prefix config names with [tag] so v2rayNG imports them into a named group, e.g. with -format base64")
+	EmojiFlags       = flag.Bool("emoji-flags", defaults.EmojiFlags, "Prefix config names with their country's flag emoji")
+	DedupWindow      = flag.Duration("dedup-window", defaults.DedupWindow, "In append mode, skip configs first seen in -db within this window (e.g. 24h), even across runs (0 = off)")
+	StatsFile        = flag.String("stats-file", defaults.StatsFile, "Also write generation stats (counts per protocol and country, average latency) as JSON to this path")
+	DumpRaw          = flag.String("dump-raw", defaults.DumpRaw, "Write each fetched source's raw response to this directory as <source>.raw before parsing, for reproducing parse failures (cached sources are not re-fetched; add -no-cache)")
+	LogFormat        = flag.String("log-format", defaults.LogFormat, "Summary output format: text, json")
 )
 
 func main() {
 	flag.Parse()
 
-	setupLogging()
+	opts := optionsFromFlags()
+	setupLogging(opts.Verbose)
 
-	if *Verbose {
-		log.Println("Starting Iran-Proxy-Unified aggregator...")
-		log.Printf("Mode: %s | Format: %s | Max Configs: %d\n", *Mode, *OutputFormat, *MaxConfigs)
+	result, err := Run(opts)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
 	}
-
-	switch *Mode {
-	case "generate":
-		if err := handleGenerate(); err != nil {
-			log.Fatalf("Error in generate mode: %v", err)
+	if result.Validation != nil {
+		if code := result.Validation.ExitCode(); code != ExitOK {
+			os.Exit(code)
 		}
-	case "fetch":
-		if err := handleFetch(); err != nil {
-			log.Fatalf("Error in fetch mode: %v", err)
-		}
-	case "validate":
-		if err := handleValidate(); err != nil {
-			log.Fatalf("Error in validate mode: %v", err)
-		}
-	default:
-		log.Fatalf("Unknown mode: %s", *Mode)
 	}
+}
 
-	if *Verbose {
-		log.Println("Aggregator completed successfully.")
+// optionsFromFlags builds Run options from the parsed command line. Format,
+// max and concurrency are only passed on when given explicitly, so the
+// sources file's settings still apply otherwise.
+func optionsFromFlags() Options {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	opts := Options{
+		Mode:                *Mode,
+		Formats:             *Formats,
+		Sources:             *ConfigSourceFile,
+		Rules:               *RulesFile,
+		TemplateFile:        *TemplateFile,
+		Output:              *OutputFile,
+		NoCache:             *NoCache,
+		FailFast:            *FailFast,
+		MinSources:          *MinSources,
+		DB:                  *DBFile,
+		Input:               *Input,
+		OnlyIDs:             *OnlyIDs,
+		Verbose:             *Verbose,
+		DumpRaw:             *DumpRaw,
+		Listen:              *Listen,
+		RefreshInterval:     *RefreshInterval,
+		CacheTTL:            *CacheTTL,
+		StaleBehavior:       *StaleBehavior,
+		SourceFailThreshold: *SourceFailLimit,
+		SourceBackoff:       *SourceBackoff,
+		StateFile:           *StateFile,
+		Sort:                *SortBy,
+		ScoreWeights:        *ScoreWeightSpec,
+		OutputPolicy:        *OutputPolicy,
+		GroupBy:             *GroupBy,
+		GroupType:           *GroupType,
+		LBStrategy:          *LBStrategy,
+		Front:               *FrontID,
+		TrailingNewline:     *TrailingNewline,
+		Base64:              *Base64,
+		PruneFormats:        *PruneFormats,
+		UpdateInterval:      *UpdateInterval,
+		Redact:              *Redact,
+		VMessNameMax:        *VMessNameMax,
+		ObfuscateSNI:        *ObfuscateSNI,
+		EmojiFlags:          *EmojiFlags,
+		GroupTag:            *GroupTag,
+		DedupWindow:         *DedupWindow,
+		StatsFile:           *StatsFile,
+		LogFormat:           *LogFormat,
+		GeoIP:               *GeoIPFile,
+		TLSCheck:            *TLSCheck,
+		CertMinValidity:     *CertMinValidity,
+		IPBlocklist:         *IPBlocklistFile,
+		ValidateOnlyChanged: *OnlyChanged,
+		ReachabilityTTL:     *ReachabilityTTL,
+		Progress:            *Progress,
+		MaxLatency:          *MaxLatency,
+		DropDead:            *DropDead,
+		LearnBlacklist:      *LearnBlacklist,
+	}
+
+	if explicit["format"] {
+		opts.Format = *OutputFormat
+	}
+	if explicit["max"] {
+		opts.Max = *MaxConfigs
+	}
+	if explicit["concurrency"] {
+		opts.Concurrency = *Concurrency
 	}
+	// -seed=0 is a valid seed, so only an explicit flag fixes rotation
+	if explicit["seed"] {
+		opts.Seed = Seed
+	}
+
+	return opts
 }
 
-func handleGenerate() error {
-	if *Verbose {
+func handleGenerate(opts *Options) ([]*Config, error) {
+	weights, err := ParseScoreWeights(opts.ScoreWeights)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Sort != "score" && opts.Sort != "latency" {
+		return nil, fmt.Errorf("unknown sort order %q (expected score or latency)", opts.Sort)
+	}
+
+	policy, err := parseOutputPolicy(opts.OutputPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	formats, err := parseFormats(opts.Formats)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := loadTemplateFlag(opts, formats)
+	if err != nil {
+		return nil, err
+	}
+
+	groupType, strategy, err := parseGroupType(opts.GroupType, opts.LBStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	groupBy, err := parseGroupBy(opts.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := parseProgressMode(opts.Progress); err != nil {
+		return nil, err
+	}
+
+	var blocklist *IPBlocklist
+	if opts.IPBlocklist != "" {
+		if blocklist, err = LoadIPBlocklist(opts.IPBlocklist, 50); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Verbose {
 		log.Println("Loading configurations...")
 	}
 
 	// Initialize aggregator
-	agg, err := NewAggregator(*ConfigSourceFile, *RulesFile, *MaxConfigs)
+	agg, err := NewAggregator(opts.Sources, opts.Rules, opts.Max)
 	if err != nil {
-		return fmt.Errorf("failed to initialize aggregator: %w", err)
+		return nil, fmt.Errorf("failed to initialize aggregator: %w", err)
 	}
+	configureAggregator(agg, opts)
+	defer agg.Close()
 
-	if *Verbose {
+	// The sources file may set the format, so check -base64 once it is known
+	if err := checkBase64Flag(opts, formats); err != nil {
+		return nil, err
+	}
+
+	if opts.Verbose {
 		log.Println("Fetching configs from sources...")
 	}
 
 	// Fetch and process configurations
 	configs, err := agg.FetchAndProcessConfigs()
 	if err != nil {
-		return fmt.Errorf("failed to fetch configs: %w", err)
+		return nil, fmt.Errorf("failed to fetch configs: %w", err)
 	}
 
-	if *Verbose {
+	if opts.Verbose {
 		log.Printf("Fetched and processed %d configs\n", len(configs))
 	}
 
-	// Generate subscription
-	subGen := NewSubscriptionGenerator(*OutputFormat)
-	subscription, err := subGen.Generate(configs)
-	if err != nil {
-		return fmt.Errorf("failed to generate subscription: %w", err)
-	}
-
-	if *Verbose {
-		log.Printf("Generated subscription (%d bytes)\n", len(subscription))
-		log.Printf("Saving to: %s\n", *OutputFile)
+	if configs, err = postProcess(opts, configs, blocklist); err != nil {
+		return nil, err
 	}
 
 	// Ensure output directory exists
-	outputDir := filepath.Dir(*OutputFile)
+	outputDir := filepath.Dir(opts.Output)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// Save subscription to file
-	if err := os.WriteFile(*OutputFile, []byte(subscription), 0644); err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
+	// Generate one subscription per format from the same configs
+	outputs := map[string]string{opts.Format: opts.Output}
+	if len(formats) > 0 {
+		outputs = make(map[string]string, len(formats))
+		for _, format := range formats {
+			outputs[format] = formatOutputPath(opts.Output, format)
+		}
+	} else {
+		formats = []string{opts.Format}
+	}
+
+	// Apply the output policy and ranking once, so every format is
+	// generated from the same configs in the same order
+	selector := NewSubscriptionGenerator(opts.Format)
+	selector.SetOutputPolicy(policy)
+	if opts.Sort != "latency" {
+		selector.SetScorer(NewDefaultScorer(weights, agg.SourcePriorities()))
+	}
+	configs = selector.Select(configs)
+	if opts.PruneFormats {
+		configs = pruneUnsupported(configs, formats)
+	}
+
+	for _, format := range formats {
+		subGen := NewSubscriptionGenerator(format)
+		subGen.SetTrailingNewline(opts.TrailingNewline)
+		subGen.SetVMessNameLimit(opts.VMessNameMax)
+		subGen.SetBase64(opts.Base64)
+		subGen.SetUpdateInterval(opts.UpdateInterval)
+		subGen.SetTemplate(tmpl)
+		subGen.SetFront(opts.Front)
+		subGen.SetGroupType(groupType, strategy)
+		subGen.SetGroupBy(groupBy)
+		if opts.Verbose {
+			log.Printf("Saving to: %s\n", outputs[format])
+		}
+
+		// Stream subscription to file
+		if err := writeSubscription(outputs[format], subGen, configs, opts.Verbose); err != nil {
+			return nil, err
+		}
 	}
 
 	fmt.Printf("Subscription generated successfully!\n")
-	fmt.Printf("Output: %s\n", *OutputFile)
+	for _, format := range formats {
+		fmt.Printf("Output: %s\n", outputs[format])
+	}
 	fmt.Printf("Configs: %d\n", len(configs))
 
+	summary := NewSummary(configs, agg.Duplicates())
+	summary.Unsupported = agg.Unsupported()
+	if err := summary.Print(os.Stdout, opts.LogFormat); err != nil {
+		return nil, fmt.Errorf("failed to print summary: %w", err)
+	}
+
+	if opts.StatsFile != "" {
+		if err := summary.WriteFile(opts.StatsFile, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	return configs, nil
+}
+
+// postProcess applies the steps that follow a fetch: blocklist and
+// certificate checks, latency filtering and sorting, blacklist learning,
+// renaming and redaction. Generate and serve mode share it so both publish
+// the same configs.
+func postProcess(opts *Options, configs []*Config, blocklist *IPBlocklist) ([]*Config, error) {
+	if blocklist != nil {
+		if blocked := blocklist.Check(configs); len(blocked) > 0 {
+			log.Printf("Dropping %d config(s) whose server is in -ip-blocklist\n", len(blocked))
+			configs = excludeConfigs(configs, blocked)
+		}
+	}
+
+	if opts.TLSCheck {
+		flagged := NewTLSChecker(5*time.Second, opts.CertMinValidity, 50).Check(configs)
+		if len(flagged) > 0 {
+			log.Printf("Dropping %d config(s) with expired or expiring certificates\n", len(flagged))
+			configs = excludeConfigs(configs, flagged)
+		}
+	}
+
+	var failed []*Config
+	if opts.Sort == "latency" || opts.LearnBlacklist || opts.MaxLatency > 0 || opts.DropDead {
+		var err error
+		if failed, err = testLatency(opts, configs); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.MaxLatency > 0 || opts.DropDead {
+		tested := len(configs)
+		configs = filterByLatency(configs, opts.MaxLatency, opts.DropDead)
+		if dropped := tested - len(configs); dropped > 0 {
+			log.Printf("Dropping %d config(s) slower than -max-latency or unreachable\n", dropped)
+		}
+	}
+
+	if opts.Sort == "latency" {
+		configs = sortByLatency(configs)
+	}
+
+	if opts.LearnBlacklist {
+		if err := learnUnreachable(opts, failed); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.ObfuscateSNI {
+		if n := obfuscateSNIs(configs, ApplySNIObfuscation); opts.Verbose {
+			log.Printf("Obfuscated the SNI of %d configs\n", n)
+		}
+	}
+
+	if opts.EmojiFlags {
+		applyEmojiFlags(configs)
+	}
+
+	if opts.GroupTag != "" {
+		applyGroupTag(configs, opts.GroupTag)
+	}
+
+	uniquifyNames(configs)
+
+	if opts.Redact {
+		configs = redactConfigs(configs)
+	}
+
+	return configs, nil
+}
+
+// checkBase64Flag rejects -base64 unless every format is Clash, the only
+// output it wraps, so other formats do not silently ignore it
+func checkBase64Flag(opts *Options, formats []string) error {
+	if !opts.Base64 {
+		return nil
+	}
+	if len(formats) == 0 {
+		formats = []string{opts.Format}
+	}
+	for _, format := range formats {
+		if format != "clash" && format != "clash-meta" {
+			return fmt.Errorf("-base64 only applies to clash and clash-meta output, not %s", format)
+		}
+	}
 	return nil
 }
 
-func handleFetch() error {
-	log.Println("Fetching configs from sources...")
-	agg, err := NewAggregator(*ConfigSourceFile, *RulesFile, *MaxConfigs)
+// loadTemplateFlag loads -template-file when the format, or one of
+// formats, is template
+func loadTemplateFlag(opts *Options, formats []string) (*template.Template, error) {
+	if opts.Format != "template" && !slices.Contains(formats, "template") {
+		return nil, nil
+	}
+	if opts.TemplateFile == "" {
+		return nil, fmt.Errorf("-format template requires -template-file")
+	}
+	return LoadOutputTemplate(opts.TemplateFile)
+}
+
+// writeSubscription generates the subscription straight into path. Output
+// goes to a temporary file first so a failed run never truncates the last
+// good subscription.
+func writeSubscription(path string, subGen *SubscriptionGenerator, configs []*Config, verbose bool) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
 	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := subGen.GenerateTo(w, configs); err != nil {
+		return fmt.Errorf("failed to generate subscription: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	if verbose {
+		if info, err := os.Stat(path); err == nil {
+			log.Printf("Generated subscription (%d bytes)\n", info.Size())
+		}
+	}
+
+	return nil
+}
+
+// testLatency measures reachability of configs, returning those that could
+// not be reached. With -validate-only-changed, configs with a fresh reachable
+// record in -db are skipped and newly reachable ones are recorded there.
+func testLatency(opts *Options, configs []*Config) ([]*Config, error) {
+	tester := NewLatencyTester(5*time.Second, 50)
+	tester.SetProgress(NewProgressReporter(os.Stderr, opts.Progress, "configs tested"))
+	if !opts.ValidateOnlyChanged {
+		return tester.Test(configs), nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.DB), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+	store, err := OpenConfigStore(opts.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	now := time.Now()
+	known, err := store.ReachableSince(now.Add(-opts.ReachabilityTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	failed, tested := tester.TestChanged(configs, known)
+	if opts.Verbose {
+		log.Printf("Latency tested %d new or expired config(s), skipped %d known-good\n", len(tested), len(configs)-len(tested))
+	}
+
+	if err := store.Upsert(excludeConfigs(tested, failed), now); err != nil {
+		return nil, err
+	}
+
+	return failed, nil
+}
+
+// learnUnreachable appends the servers of unreachable configs to the rules
+// file as exclude rules
+func learnUnreachable(opts *Options, failed []*Config) error {
+	// Learned rules go into the last rules file so they override the rest
+	files, err := expandRulesFiles(opts.Rules)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+	target := files[len(files)-1]
+
+	rules, err := loadRulesFile(target)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	learned := learnBlacklist(rules, failed)
+	if len(learned) == len(rules) {
+		return nil
+	}
+
+	if err := SaveRules(target, learned); err != nil {
 		return err
 	}
 
+	log.Printf("Learned %d unreachable server(s) into %s\n", len(learned)-len(rules), target)
+	return nil
+}
+
+func handleFetch(opts *Options) ([]*Config, error) {
+	log.Println("Fetching configs from sources...")
+	agg, err := NewAggregator(opts.Sources, opts.Rules, opts.Max)
+	if err != nil {
+		return nil, err
+	}
+	configureAggregator(agg, opts)
+	defer agg.Close()
+	agg.SetFailFast(opts.FailFast)
+
 	configs, err := agg.FetchAndProcessConfigs()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	fmt.Printf("Successfully fetched %d configs\n", len(configs))
-	return nil
+	return configs, nil
 }
 
-func handleValidate() error {
+// handleCount prints how many configs each source yields, for curating the
+// sources list
+func handleCount(opts *Options) ([]SourceCount, error) {
+	agg, err := NewAggregator(opts.Sources, opts.Rules, opts.Max)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize aggregator: %w", err)
+	}
+	configureAggregator(agg, opts)
+	defer agg.Close()
+
+	counts := agg.CountSources()
+	if err := writeSourceCounts(os.Stdout, counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// handleServe serves the subscription over HTTP, regenerating it every
+// -refresh-interval
+func handleServe(opts *Options) error {
+	// time.NewTicker panics on a non-positive interval
+	if opts.RefreshInterval <= 0 {
+		return fmt.Errorf("-refresh-interval must be positive, got %s", opts.RefreshInterval)
+	}
+
+	weights, err := ParseScoreWeights(opts.ScoreWeights)
+	if err != nil {
+		return err
+	}
+
+	if opts.Sort != "score" && opts.Sort != "latency" {
+		return fmt.Errorf("unknown sort order %q (expected score or latency)", opts.Sort)
+	}
+
+	policy, err := parseOutputPolicy(opts.OutputPolicy)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := loadTemplateFlag(opts, nil)
+	if err != nil {
+		return err
+	}
+
+	groupType, strategy, err := parseGroupType(opts.GroupType, opts.LBStrategy)
+	if err != nil {
+		return err
+	}
+
+	groupBy, err := parseGroupBy(opts.GroupBy)
+	if err != nil {
+		return err
+	}
+
+	behavior, err := parseStaleBehavior(opts.StaleBehavior)
+	if err != nil {
+		return err
+	}
+
+	var blocklist *IPBlocklist
+	if opts.IPBlocklist != "" {
+		if blocklist, err = LoadIPBlocklist(opts.IPBlocklist, 50); err != nil {
+			return err
+		}
+	}
+
+	refresh := func() ([]byte, error) {
+		// A fresh aggregator per refresh, so its in-memory source cache
+		// never serves configs older than the refresh interval
+		agg, err := NewAggregator(opts.Sources, opts.Rules, opts.Max)
+		if err != nil {
+			return nil, err
+		}
+		configureAggregator(agg, opts)
+		defer agg.Close()
+
+		configs, err := agg.FetchAndProcessConfigs()
+		if err != nil {
+			return nil, err
+		}
+		if len(configs) == 0 {
+			return nil, fmt.Errorf("no configs fetched")
+		}
+
+		if configs, err = postProcess(opts, configs, blocklist); err != nil {
+			return nil, err
+		}
+
+		subGen := NewSubscriptionGenerator(opts.Format)
+		subGen.SetTrailingNewline(opts.TrailingNewline)
+		subGen.SetVMessNameLimit(opts.VMessNameMax)
+		subGen.SetBase64(opts.Base64)
+		subGen.SetUpdateInterval(opts.UpdateInterval)
+		subGen.SetOutputPolicy(policy)
+		subGen.SetTemplate(tmpl)
+		subGen.SetFront(opts.Front)
+		subGen.SetGroupType(groupType, strategy)
+		subGen.SetGroupBy(groupBy)
+		if opts.Sort != "latency" {
+			subGen.SetScorer(NewDefaultScorer(weights, agg.SourcePriorities()))
+		}
+
+		var buf bytes.Buffer
+		if err := subGen.GenerateTo(&buf, configs); err != nil {
+			return nil, err
+		}
+
+		log.Printf("Refreshed subscription with %d configs\n", len(configs))
+		return buf.Bytes(), nil
+	}
+
+	srv := NewSubscriptionServer(refresh, opts.CacheTTL, behavior)
+	srv.SetCacheFile(opts.Output)
+	if err := srv.LoadCache(); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+
+	if err := srv.Refresh(); err != nil {
+		log.Printf("Initial refresh failed: %v\n", err)
+	}
+	// The first refresh resolved the format from the sources file
+	opts.applySettings(SourceSettings{})
+	if err := checkBase64Flag(opts, nil); err != nil {
+		return err
+	}
+	srv.SetContentType(subscriptionContentType(opts.Format, opts.Base64))
+	if opts.UpdateInterval > 0 && (opts.Format == "clash" || opts.Format == "clash-meta") {
+		srv.SetHeader("Profile-Update-Interval", strconv.Itoa(profileUpdateHours(opts.UpdateInterval)))
+	}
+
+	ln, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return err
+	}
+
+	// SIGINT and SIGTERM drain requests and save the subscription to -output
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Serving %s subscription on %s\n", opts.Format, opts.Listen)
+	return srv.Serve(ctx, ln, opts.RefreshInterval)
+}
+
+// handleMerge combines the share link files in -input into one
+// subscription, dropping duplicate nodes before making names unique
+func handleMerge(opts *Options) ([]*Config, error) {
+	policy, err := parseOutputPolicy(opts.OutputPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := loadTemplateFlag(opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := splitMergeInputs(opts.Input)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("merge mode requires -input with one or more files")
+	}
+
+	configs, duplicates, err := mergeFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Redact {
+		configs = redactConfigs(configs)
+	}
+
+	opts.applySettings(SourceSettings{})
+	if err := checkBase64Flag(opts, nil); err != nil {
+		return nil, err
+	}
+
+	subGen := NewSubscriptionGenerator(opts.Format)
+	subGen.SetTrailingNewline(opts.TrailingNewline)
+	subGen.SetVMessNameLimit(opts.VMessNameMax)
+	subGen.SetBase64(opts.Base64)
+	subGen.SetUpdateInterval(opts.UpdateInterval)
+	subGen.SetOutputPolicy(policy)
+	subGen.SetTemplate(tmpl)
+
+	if err := os.MkdirAll(filepath.Dir(opts.Output), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := writeSubscription(opts.Output, subGen, configs, opts.Verbose); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Merged %d configs from %d files (%d duplicates dropped)\n", len(configs), len(paths), duplicates)
+	fmt.Printf("Output: %s\n", opts.Output)
+	return configs, nil
+}
+
+// handleAppend fetches configs and appends the ones not yet in -output as
+// share links, one per line, leaving existing lines untouched
+func handleAppend(opts *Options) ([]*Config, error) {
+	agg, err := NewAggregator(opts.Sources, opts.Rules, opts.Max)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize aggregator: %w", err)
+	}
+	configureAggregator(agg, opts)
+	defer agg.Close()
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch configs: %w", err)
+	}
+
+	if opts.DedupWindow > 0 {
+		fetched := len(configs)
+		if configs, err = dedupWithinWindow(opts, configs); err != nil {
+			return nil, err
+		}
+		if opts.Verbose {
+			log.Printf("Skipped %d config(s) first seen within the last %s\n", fetched-len(configs), opts.DedupWindow)
+		}
+	}
+
+	if opts.Redact {
+		configs = redactConfigs(configs)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Output), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	added, err := appendNewConfigs(opts.Output, configs)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Appended %d new configs (%d already present)\n", len(added), len(configs)-len(added))
+	fmt.Printf("Output: %s\n", opts.Output)
+	return added, nil
+}
+
+// dedupWithinWindow drops configs first seen in -db within -dedup-window
+func dedupWithinWindow(opts *Options, configs []*Config) ([]*Config, error) {
+	if err := os.MkdirAll(filepath.Dir(opts.DB), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+	store, err := OpenConfigStore(opts.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	return dropSeenWithin(store, configs, opts.DedupWindow, time.Now())
+}
+
+// handleExportDB fetches configs and upserts them into the -db SQLite
+// database, keyed by fingerprint, so runs accumulate a history
+func handleExportDB(opts *Options) ([]*Config, error) {
+	agg, err := NewAggregator(opts.Sources, opts.Rules, opts.Max)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize aggregator: %w", err)
+	}
+	configureAggregator(agg, opts)
+	defer agg.Close()
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch configs: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.DB), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	store, err := OpenConfigStore(opts.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	if err := store.Upsert(configs, time.Now()); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Exported %d configs to %s\n", len(configs), opts.DB)
+	return configs, nil
+}
+
+func handleValidate(opts *Options) *ValidationResult {
 	log.Println("Validating configuration files...")
 
-	// Validate sources file
-	if _, err := os.Stat(*ConfigSourceFile); err != nil {
-		return fmt.Errorf("sources file not found: %w", err)
+	result := validateConfigFiles(opts.Sources, opts.Rules)
+	if result.Err != nil {
+		return result
+	}
+
+	for _, warning := range result.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
 	}
 
-	// Validate rules file
-	if _, err := os.Stat(*RulesFile); err != nil {
-		return fmt.Errorf("rules file not found: %w", err)
+	if len(result.Warnings) > 0 {
+		fmt.Printf("Configuration files validated with %d warning(s)\n", len(result.Warnings))
+	} else {
+		fmt.Println("Configuration files validated successfully!")
+	}
+
+	return result
+}
+
+func handleQR(opts *Options) error {
+	configs, err := loadQRConfigs(opts)
+	if err != nil {
+		return err
+	}
+
+	if len(configs) == 0 {
+		return fmt.Errorf("no configs to render")
+	}
+	if opts.Redact {
+		configs = redactConfigs(configs)
+	}
+
+	// Terminal output unless a PNG file was requested
+	if !strings.EqualFold(filepath.Ext(opts.Output), ".png") {
+		for _, cfg := range configs {
+			code, err := EncodeQRTerminal(cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Println(cfg.Name)
+			fmt.Print(code)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Output), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for _, cfg := range configs {
+		path := opts.Output
+		if len(configs) > 1 {
+			path = strings.TrimSuffix(path, filepath.Ext(path)) + "-" + cfg.ID + ".png"
+		}
+
+		png, err := EncodeQRPNG(cfg, qrPNGSize)
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(path, png, 0644); err != nil {
+			return fmt.Errorf("failed to write QR code: %w", err)
+		}
+		fmt.Printf("QR code for %s saved to %s\n", cfg.Name, path)
 	}
 
-	fmt.Println("Configuration files validated successfully!")
 	return nil
 }
 
-func setupLogging() {
+// loadQRConfigs returns the configs to render, either from -input or by
+// selecting -only-ids from the configured sources
+func loadQRConfigs(opts *Options) ([]*Config, error) {
+	if opts.Input != "" {
+		return readInputConfigs(opts.Input)
+	}
+
+	if opts.OnlyIDs == "" {
+		return nil, fmt.Errorf("qr mode requires -input or -only-ids")
+	}
+
+	agg, err := NewAggregator(opts.Sources, opts.Rules, opts.Max)
+	if err != nil {
+		return nil, err
+	}
+	configureAggregator(agg, opts)
+	defer agg.Close()
+
+	all, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool)
+	for _, id := range strings.Split(opts.OnlyIDs, ",") {
+		wanted[strings.TrimSpace(id)] = true
+	}
+
+	var configs []*Config
+	for _, cfg := range all {
+		if wanted[cfg.ID] {
+			configs = append(configs, cfg)
+		}
+	}
+
+	return configs, nil
+}
+
+// readInputConfigs parses a single share link, or every line of a file of links
+func readInputConfigs(input string) ([]*Config, error) {
+	lines := []string{input}
+	if data, err := os.ReadFile(input); err == nil {
+		lines = strings.Split(string(data), "\n")
+	}
+
+	parser := NewProtocolParser()
+	var configs []*Config
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parsed, err := parser.ParseConfigs(line, "input")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse input %q: %w", line, err)
+		}
+		configs = append(configs, parsed...)
+	}
+
+	return configs, nil
+}
+
+// configureAggregator fills the format, max and concurrency options left
+// unset from the sources file, then passes the resulting options to the
+// aggregator
+func configureAggregator(agg *Aggregator, opts *Options) {
+	opts.applySettings(agg.Settings())
+
+	agg.SetMaxConfigs(opts.Max)
+	agg.SetConcurrency(opts.Concurrency)
+	agg.SetNoCache(opts.NoCache)
+	agg.SetMinSources(opts.MinSources)
+	agg.SetVerbose(opts.Verbose)
+	agg.SetDumpRawDir(opts.DumpRaw)
+
+	if opts.SourceFailThreshold > 0 {
+		state, err := LoadSourceState(opts.StateFile, opts.SourceFailThreshold, opts.SourceBackoff)
+		if err != nil {
+			log.Printf("Warning: source failure tracking disabled: %v\n", err)
+		} else {
+			agg.SetSourceState(state)
+		}
+	}
+
+	if opts.GeoIP != "" {
+		if geo := loadGeoResolver(opts.GeoIP); geo != nil {
+			agg.SetGeoResolver(geo)
+		}
+	}
+
+	if opts.Verbose {
+		agg.SetProgress(func(p FetchProgress) {
+			status := "ok"
+			if p.Err != nil {
+				status = "failed"
+			}
+			log.Printf("Progress: %d/%d sources fetched (%s %s)\n", p.Completed, p.Total, p.Source, status)
+		})
+	}
+}
+
+// loadGeoResolver opens the geo database. A missing or corrupt database is
+// not fatal: enrichment and country rules are skipped for this run.
+func loadGeoResolver(path string) *GeoResolver {
+	geo, err := NewGeoResolver(path)
+	if err != nil {
+		log.Printf("Warning: %v; country enrichment disabled for this run\n", err)
+		return nil
+	}
+	return geo
+}
+
+func setupLogging(verbose bool) {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	if !*Verbose {
+	if !verbose {
 		log.SetOutput(os.Stderr)
 	}
 }
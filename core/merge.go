@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// mergeFiles reads share links from each file in order and combines them.
+// Fingerprint dedup runs first, keeping the first copy of each node, then
+// the remaining distinct nodes get unique names. It returns the merged
+// configs and the number of duplicates dropped.
+func mergeFiles(paths []string) ([]*Config, int, error) {
+	var all []*Config
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return nil, 0, fmt.Errorf("failed to read merge input: %w", err)
+		}
+
+		configs, err := readInputConfigs(path)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, cfg := range configs {
+			cfg.Source = path
+		}
+		all = append(all, configs...)
+	}
+
+	merged, duplicates := dedupConfigs(all)
+	uniquifyNames(merged)
+
+	return merged, duplicates, nil
+}
+
+// dedupConfigs drops configs whose fingerprint was already seen, keeping
+// the first occurrence and the input order
+func dedupConfigs(configs []*Config) ([]*Config, int) {
+	seen := make(map[string]bool, len(configs))
+	result := make([]*Config, 0, len(configs))
+	for _, cfg := range configs {
+		key := cfg.Key()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, cfg)
+	}
+
+	return result, len(configs) - len(result)
+}
+
+// splitMergeInputs splits a comma-separated -input list for merge mode
+func splitMergeInputs(spec string) []string {
+	var paths []string
+	for _, path := range strings.Split(spec, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
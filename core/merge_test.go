@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestMergeDedupBeforeRenaming tests that true duplicates are dropped and same-named distinct nodes renamed
+func TestMergeDedupBeforeRenaming(t *testing.T) {
+	first := writeTestFile(t, "first.txt", "vless://uuid-a@a.example.com:443?security=tls&sni=a.example.com#Node\n"+
+		"trojan://pass@b.example.com:443?sni=b.example.com#Node\n")
+	second := writeTestFile(t, "second.txt", "vless://uuid-a@a.example.com:443?security=tls&sni=a.example.com#Node\n")
+
+	configs, duplicates, err := mergeFiles(splitMergeInputs(first + ", " + second))
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	if duplicates != 1 {
+		t.Errorf("Expected 1 duplicate dropped, got %d", duplicates)
+	}
+
+	if len(configs) != 2 {
+		t.Fatalf("Expected 2 survivors, got %d", len(configs))
+	}
+
+	if configs[0].Server != "a.example.com" || configs[0].Name != "Node" {
+		t.Errorf("Expected first survivor a.example.com named Node, got %s named %s", configs[0].Server, configs[0].Name)
+	}
+	if configs[1].Server != "b.example.com" || configs[1].Name != "Node 2" {
+		t.Errorf("Expected second survivor b.example.com named Node 2, got %s named %s", configs[1].Server, configs[1].Name)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// truncationMark is appended to names shortened by truncateName
+const truncationMark = "…"
+
+// countryEmoji returns the flag emoji for an ISO 3166-1 alpha-2 country code,
+// or an empty string if the code is empty or malformed
+func countryEmoji(iso string) string {
+	iso = strings.ToUpper(strings.TrimSpace(iso))
+	if len(iso) != 2 {
+		return ""
+	}
+
+	var sb strings.Builder
+	for _, c := range iso {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		// Regional indicator symbols start at U+1F1E6 for 'A'
+		sb.WriteRune(0x1F1E6 + (c - 'A'))
+	}
+
+	return sb.String()
+}
+
+// applyEmojiFlags prefixes each config name with its country's flag emoji.
+// Configs without a known country are left unchanged.
+func applyEmojiFlags(configs []*Config) {
+	for _, cfg := range configs {
+		emoji := countryEmoji(cfg.Country)
+		if emoji == "" || strings.HasPrefix(cfg.Name, emoji) {
+			continue
+		}
+		cfg.Name = emoji + " " + cfg.Name
+	}
+}
+
+// groupTagPrefix is the name prefix applyGroupTag adds for a tag
+func groupTagPrefix(tag string) string {
+	return "[" + tag + "] "
+}
+
+// applyGroupTag prefixes each config name with tag in brackets, e.g.
+// "[Iran] Node", which v2rayNG and similar clients use to group imported
+// nodes. Names already carrying the tag are left unchanged.
+func applyGroupTag(configs []*Config, tag string) {
+	prefix := groupTagPrefix(tag)
+	for _, cfg := range configs {
+		if !strings.HasPrefix(cfg.Name, prefix) {
+			cfg.Name = prefix + cfg.Name
+		}
+	}
+}
+
+// uniquifyNames suffixes repeated config names with " 2", " 3", ... in
+// order, since Clash and Sing-box reject duplicate proxy names. Run it after
+// fingerprint dedup so true duplicates are dropped rather than renamed.
+func uniquifyNames(configs []*Config) {
+	used := make(map[string]bool, len(configs))
+	for _, cfg := range configs {
+		used[cfg.Name] = false
+	}
+
+	for _, cfg := range configs {
+		if !used[cfg.Name] {
+			used[cfg.Name] = true
+			continue
+		}
+
+		for n := 2; ; n++ {
+			candidate := fmt.Sprintf("%s %d", cfg.Name, n)
+			if _, taken := used[candidate]; !taken {
+				used[candidate] = true
+				cfg.Name = candidate
+				break
+			}
+		}
+	}
+}
+
+// truncateName shortens name to at most max bytes, cutting on a rune
+// boundary and ending with an ellipsis when it fits. Names within the limit,
+// and any name when max is not positive, are returned unchanged.
+func truncateName(name string, max int) string {
+	if max <= 0 || len(name) <= max {
+		return name
+	}
+
+	mark := truncationMark
+	if max < len(mark) {
+		mark = ""
+	}
+
+	cut := max - len(mark)
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+
+	return name[:cut] + mark
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCountryEmoji tests flag emoji generation from country codes
+func TestCountryEmoji(t *testing.T) {
+	testCases := []struct {
+		iso      string
+		expected string
+	}{
+		{"DE", "🇩🇪"},
+		{"us", "🇺🇸"},
+		{"", ""},
+		{"XYZ", ""},
+		{"1A", ""},
+	}
+
+	for _, tc := range testCases {
+		if got := countryEmoji(tc.iso); got != tc.expected {
+			t.Errorf("countryEmoji(%q) = %q, expected %q", tc.iso, got, tc.expected)
+		}
+	}
+}
+
+// TestApplyEmojiFlags tests that names are prefixed by country flag
+func TestApplyEmojiFlags(t *testing.T) {
+	configs := []*Config{
+		{Name: "Frankfurt", Country: "DE"},
+		{Name: "Unknown"},
+	}
+
+	applyEmojiFlags(configs)
+	applyEmojiFlags(configs)
+
+	if !strings.HasPrefix(configs[0].Name, "🇩🇪") {
+		t.Errorf("Expected DE config name to start with flag, got %q", configs[0].Name)
+	}
+
+	if configs[0].Name != "🇩🇪 Frankfurt" {
+		t.Errorf("Expected flag to be applied once, got %q", configs[0].Name)
+	}
+
+	if configs[1].Name != "Unknown" {
+		t.Errorf("Expected config without country to be unchanged, got %q", configs[1].Name)
+	}
+}
+
+// TestUniquifyNamesAvoidsExisting tests that generated suffixes never collide with existing names
+func TestUniquifyNamesAvoidsExisting(t *testing.T) {
+	configs := []*Config{{Name: "Node"}, {Name: "Node"}, {Name: "Node 2"}}
+
+	uniquifyNames(configs)
+
+	expected := []string{"Node", "Node 3", "Node 2"}
+	for i, cfg := range configs {
+		if cfg.Name != expected[i] {
+			t.Errorf("Expected name %q at %d, got %q", expected[i], i, cfg.Name)
+		}
+	}
+}
+
+// TestGroupTagBase64Subscription tests that the group tag prefixes names
+// and that the base64 subscription decodes to share links carrying it
+func TestGroupTagBase64Subscription(t *testing.T) {
+	parser := NewProtocolParser()
+	var configs []*Config
+	for _, link := range []string{
+		"vless://uuid-1@one.example.com:443?security=tls&sni=one.example.com#One",
+		"trojan://secret@two.example.com:443?sni=two.example.com#%5BIran%5D%20Two",
+	} {
+		cfg, err := parser.ParseConfig(link, "test")
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", link, err)
+		}
+		configs = append(configs, cfg)
+	}
+
+	applyGroupTag(configs, "Iran")
+	if configs[0].Name != "[Iran] One" {
+		t.Errorf("Expected [Iran] One, got %s", configs[0].Name)
+	}
+	if configs[1].Name != "[Iran] Two" {
+		t.Errorf("Expected an already tagged name to be kept, got %s", configs[1].Name)
+	}
+
+	sub, err := NewSubscriptionGenerator("base64").Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate base64 subscription: %v", err)
+	}
+
+	decoded, err := DecodeBase64(strings.TrimSpace(sub))
+	if err != nil {
+		t.Fatalf("Expected valid base64, got %v", err)
+	}
+
+	links := strings.Split(decoded, "\n")
+	if len(links) != 2 {
+		t.Fatalf("Expected 2 links, got %d: %q", len(links), decoded)
+	}
+	for i, link := range links {
+		cfg, err := parser.ParseConfig(link, "test")
+		if err != nil {
+			t.Fatalf("Failed to parse decoded link %q: %v", link, err)
+		}
+		if !strings.HasPrefix(cfg.Name, "[Iran] ") || cfg.Name != configs[i].Name {
+			t.Errorf("Expected decoded link to be named %s, got %s", configs[i].Name, cfg.Name)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Pagination defaults for sources that leave the options unset
+const (
+	defaultNextPath  = "next"
+	defaultItemsPath = "configs"
+	defaultMaxPages  = 10
+)
+
+// fetchPaginated follows a paginated source from its first page. Each page
+// is a JSON object holding an array of share links at ItemsPath and the URL
+// of the next page at NextPath; paging stops when there is no next URL, a
+// URL repeats, or MaxPages pages have been read.
+func (a *Aggregator) fetchPaginated(source ConfigSource) ([]*Config, error) {
+	nextPath := source.NextPath
+	if nextPath == "" {
+		nextPath = defaultNextPath
+	}
+	itemsPath := source.ItemsPath
+	if itemsPath == "" {
+		itemsPath = defaultItemsPath
+	}
+	maxPages := source.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+
+	var configs []*Config
+	visited := make(map[string]bool)
+	pageURL := source.URL
+	for n := 1; ; n++ {
+		visited[pageURL] = true
+
+		body, err := a.fetchBody(source, pageURL)
+		if err != nil {
+			return nil, err
+		}
+		a.dumpRaw(fmt.Sprintf("%s.page%d", source.Name, n), body)
+
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, fmt.Errorf("page %d of %s is not JSON: %w", n, source.Name, err)
+		}
+
+		var links []string
+		if items, ok := lookupJSONPath(doc, itemsPath).([]interface{}); ok {
+			for _, item := range items {
+				if link, ok := item.(string); ok {
+					links = append(links, link)
+				}
+			}
+		}
+
+		parsed, err := a.parsePlainConfigs([]byte(strings.Join(links, "\n")), source.Name)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, parsed...)
+
+		next, _ := lookupJSONPath(doc, nextPath).(string)
+		if next == "" {
+			break
+		}
+		if n >= maxPages {
+			log.Printf("Source %s has more pages, stopping at the %d page limit\n", source.Name, maxPages)
+			break
+		}
+
+		nextURL, err := resolvePageURL(pageURL, next)
+		if err != nil {
+			return nil, fmt.Errorf("invalid next page URL from %s: %w", source.Name, err)
+		}
+		if visited[nextURL] {
+			break
+		}
+		pageURL = nextURL
+	}
+
+	return configs, nil
+}
+
+// lookupJSONPath walks a dotted path of object keys, returning nil when any
+// step is missing
+func lookupJSONPath(doc interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = obj[key]
+	}
+	return doc
+}
+
+// resolvePageURL resolves a possibly relative next page URL against the
+// current page
+func resolvePageURL(current, next string) (string, error) {
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(next)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPaginatedSource tests that configs from every page of a paginated source are collected
+func TestPaginatedSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("page") {
+		case "":
+			fmt.Fprint(w, `{"data": {"links": ["vless://uuid-1@one.com:443", "vless://uuid-2@two.com:443"]}, "paging": {"next": "/api?page=2"}}`)
+		case "2":
+			fmt.Fprint(w, `{"data": {"links": ["trojan://pass@three.com:443"]}, "paging": {"next": ""}}`)
+		default:
+			t.Errorf("Unexpected page request %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	source := ConfigSource{
+		Name:      "api",
+		URL:       server.URL + "/api",
+		Type:      "plain",
+		Enabled:   true,
+		Paginate:  true,
+		NextPath:  "paging.next",
+		ItemsPath: "data.links",
+	}
+	agg := newTestAggregator(t, []ConfigSource{source}, 100)
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	servers := make(map[string]bool)
+	for _, cfg := range configs {
+		servers[cfg.Server] = true
+	}
+	for _, expected := range []string{"one.com", "two.com", "three.com"} {
+		if !servers[expected] {
+			t.Errorf("Expected %s from the paginated source, got %v", expected, servers)
+		}
+	}
+}
+
+// TestPaginatedSourceMaxPages tests that paging stops at the page limit
+func TestPaginatedSourceMaxPages(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, `{"configs": ["vless://uuid-%d@page%d.com:443"], "next": "?page=%d"}`, requests, requests, requests+1)
+	}))
+	defer server.Close()
+
+	source := ConfigSource{Name: "endless", URL: server.URL, Type: "plain", Enabled: true, Paginate: true, MaxPages: 3}
+	agg := newTestAggregator(t, []ConfigSource{source}, 100)
+
+	configs, err := agg.fetchPaginated(source)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if requests != 3 || len(configs) != 3 {
+		t.Errorf("Expected 3 pages and 3 configs, got %d pages and %d configs", requests, len(configs))
+	}
+}
+
+// TestPaginatedSourceCrossOriginHeaders tests that a source's custom headers
+// are sent to its own pages but not to a next page on another host
+func TestPaginatedSourceCrossOriginHeaders(t *testing.T) {
+	var foreignKey, foreignReferer string
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		foreignKey = r.Header.Get("X-Api-Key")
+		foreignReferer = r.Header.Get("Referer")
+		fmt.Fprint(w, `{"configs": ["trojan://pass@three.com:443"], "next": ""}`)
+	}))
+	defer foreign.Close()
+
+	var ownKeys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ownKeys = append(ownKeys, r.Header.Get("X-Api-Key"))
+		if r.URL.Query().Get("page") == "" {
+			fmt.Fprint(w, `{"configs": ["vless://uuid-1@one.com:443"], "next": "?page=2"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"configs": ["vless://uuid-2@two.com:443"], "next": %q}`, foreign.URL+"/api")
+	}))
+	defer server.Close()
+
+	source := ConfigSource{
+		Name:     "api",
+		URL:      server.URL + "/api",
+		Type:     "plain",
+		Enabled:  true,
+		Paginate: true,
+		Headers:  map[string]string{"Referer": "https://example.com/", "X-Api-Key": "s3cr3t"},
+	}
+	agg := newTestAggregator(t, []ConfigSource{source}, 100)
+
+	configs, err := agg.FetchAndProcessConfigs()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(configs) != 3 {
+		t.Errorf("Expected 3 configs across both hosts, got %d", len(configs))
+	}
+
+	if len(ownKeys) != 2 || ownKeys[0] != "s3cr3t" || ownKeys[1] != "s3cr3t" {
+		t.Errorf("Expected the API key on both same-origin pages, got %v", ownKeys)
+	}
+	if foreignKey != "" || foreignReferer != "" {
+		t.Errorf("Expected no custom headers on the cross-origin page, got X-Api-Key %q and Referer %q", foreignKey, foreignReferer)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress display modes for -progress
+const (
+	ProgressAuto = "auto"
+	ProgressBar  = "bar"
+	ProgressLog  = "log"
+	ProgressOff  = "off"
+)
+
+// progressBarWidth is the number of cells in the TTY progress bar
+const progressBarWidth = 30
+
+// progressLogSteps is how many progress lines the log display prints over a run
+const progressLogSteps = 10
+
+// ProgressReporter shows completion of a batch of work, either as a bar
+// redrawn in place on a terminal or as a log line every tenth of the total.
+// Done may be called from many goroutines at once.
+type ProgressReporter struct {
+	out   io.Writer
+	label string
+	bar   bool
+	now   func() time.Time
+
+	total int64
+	done  atomic.Int64
+	start time.Time
+
+	// mu keeps concurrent updates from interleaving their output
+	mu sync.Mutex
+}
+
+// parseProgressMode validates a -progress value
+func parseProgressMode(mode string) (string, error) {
+	switch mode {
+	case ProgressAuto, ProgressBar, ProgressLog, ProgressOff:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown progress mode %q (expected auto, bar, log or off)", mode)
+	}
+}
+
+// NewProgressReporter creates a reporter writing to out. The auto mode
+// draws a bar when out is a terminal and logs otherwise; off returns nil,
+// which reports nothing.
+func NewProgressReporter(out io.Writer, mode, label string) *ProgressReporter {
+	if mode == ProgressOff {
+		return nil
+	}
+
+	bar := mode == ProgressBar
+	if mode == ProgressAuto {
+		bar = isTerminal(out)
+	}
+
+	return &ProgressReporter{out: out, label: label, bar: bar, now: time.Now}
+}
+
+// isTerminal reports whether w is a character device such as a TTY
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start resets the reporter for a batch of total items
+func (p *ProgressReporter) Start(total int) {
+	if p == nil {
+		return
+	}
+	p.total = int64(total)
+	p.done.Store(0)
+	p.start = p.now()
+}
+
+// Done records one finished item and updates the display
+func (p *ProgressReporter) Done() {
+	if p == nil {
+		return
+	}
+
+	n := p.done.Add(1)
+	if p.bar {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		fmt.Fprintf(p.out, "\r%s", p.render(n))
+		if n == p.total {
+			fmt.Fprintln(p.out)
+		}
+		return
+	}
+
+	// Round up so the log stays within ten lines: 19 items step by 2, not 1
+	step := max(1, (p.total+progressLogSteps-1)/progressLogSteps)
+	if n%step == 0 || n == p.total {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		fmt.Fprintf(p.out, "Progress: %d/%d %s (ETA %s)\n", n, p.total, p.label, p.eta(n))
+	}
+}
+
+// render draws the bar line for n finished items
+func (p *ProgressReporter) render(n int64) string {
+	filled := 0
+	if p.total > 0 {
+		filled = int(n * progressBarWidth / p.total)
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	return fmt.Sprintf("[%s] %d/%d %s ETA %s", bar, n, p.total, p.label, p.eta(n))
+}
+
+// eta extrapolates the remaining time from the average time per item so far
+func (p *ProgressReporter) eta(n int64) time.Duration {
+	if n <= 0 || n >= p.total {
+		return 0
+	}
+	elapsed := p.now().Sub(p.start)
+	return (elapsed / time.Duration(n) * time.Duration(p.total-n)).Round(time.Second)
+}
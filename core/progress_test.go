@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestProgressLogCadence tests that the non-TTY display logs once per tenth of the latency test
+func TestProgressLogCadence(t *testing.T) {
+	var out bytes.Buffer
+	progress := NewProgressReporter(&out, ProgressAuto, "configs tested")
+	if progress.bar {
+		t.Fatalf("Expected log display for a non-terminal writer")
+	}
+
+	configs := make([]*Config, 25)
+	for i := range configs {
+		configs[i] = &Config{Protocol: "vless", Server: "127.0.0.1", Port: 1000 + i}
+	}
+
+	tester := NewLatencyTester(time.Second, 8)
+	tester.dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return nil, &net.OpError{Op: "dial", Err: net.UnknownNetworkError("test")}
+	}
+	tester.SetProgress(progress)
+	tester.Test(configs)
+
+	// Concurrent testers may finish their lines out of order, so compare counts
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	logged := make(map[string]bool)
+	for _, line := range lines {
+		count, _, _ := strings.Cut(strings.TrimPrefix(line, "Progress: "), " ")
+		logged[count] = true
+	}
+
+	// Every 3 of 25 configs, plus the final one
+	expected := []string{"3/25", "6/25", "9/25", "12/25", "15/25", "18/25", "21/25", "24/25", "25/25"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d progress lines, got %d: %q", len(expected), len(lines), out.String())
+	}
+	for _, count := range expected {
+		if !logged[count] {
+			t.Errorf("Expected a progress line for %s, got %q", count, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "Progress: 25/25 configs tested (ETA 0s)") {
+		t.Errorf("Expected a final 25/25 line with no ETA left, got %q", out.String())
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// qrPNGSize is the edge length in pixels of generated QR code images
+const qrPNGSize = 512
+
+// EncodeQRPNG renders the config's share URI as a PNG QR code
+func EncodeQRPNG(cfg *Config, size int) ([]byte, error) {
+	png, err := qrcode.Encode(cfg.String(), qrcode.Medium, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code for %s: %w", cfg.Name, err)
+	}
+	return png, nil
+}
+
+// EncodeQRTerminal renders the config's share URI as a QR code drawn with
+// block characters for display in a terminal
+func EncodeQRTerminal(cfg *Config) (string, error) {
+	code, err := qrcode.New(cfg.String(), qrcode.Medium)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode QR code for %s: %w", cfg.Name, err)
+	}
+	return code.ToSmallString(false), nil
+}
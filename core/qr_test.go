@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestEncodeQRPNG tests that a PNG QR code is produced for a config
+func TestEncodeQRPNG(t *testing.T) {
+	cfg := &Config{Protocol: "vless", Server: "server.com", Port: 443, UUID: "uuid", Name: "QR Node"}
+
+	data, err := EncodeQRPNG(cfg, 256)
+	if err != nil {
+		t.Fatalf("Failed to encode QR PNG: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("QR output should be a valid PNG: %v", err)
+	}
+
+	if bounds := img.Bounds(); bounds.Dx() != 256 || bounds.Dy() != 256 {
+		t.Errorf("Expected 256x256 image, got %dx%d", bounds.Dx(), bounds.Dy())
+	}
+}
+
+// TestEncodeQRTerminal tests terminal QR rendering
+func TestEncodeQRTerminal(t *testing.T) {
+	cfg := &Config{Protocol: "trojan", Server: "server.com", Port: 443, Password: "pass", Name: "QR Node"}
+
+	code, err := EncodeQRTerminal(cfg)
+	if err != nil {
+		t.Fatalf("Failed to encode terminal QR: %v", err)
+	}
+
+	if len(code) == 0 {
+		t.Errorf("Terminal QR should not be empty")
+	}
+}
+
+// TestHandleQRWritesPNG tests qr mode writing a PNG from -input
+func TestHandleQRWritesPNG(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "node.png")
+
+	opts := DefaultOptions()
+	opts.Input = "vless://uuid@server.com:443?security=tls#QR%20Node"
+	opts.Output = output
+
+	if err := handleQR(&opts); err != nil {
+		t.Fatalf("qr mode failed: %v", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Expected PNG to be written: %v", err)
+	}
+
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("Written file should be a valid PNG: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rawDumpPath returns where the raw body of a source is dumped: the source
+// name with path separators replaced, so every name stays inside dir
+func rawDumpPath(dir, name string) string {
+	name = strings.NewReplacer("/", "_", `\`, "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		name = "_" + name
+	}
+	return filepath.Join(dir, name+".raw")
+}
+
+// dumpRaw writes a source's raw response body to the -dump-raw directory
+// before it is parsed, so parse failures can be reproduced from the exact
+// bytes. A failed dump is logged and does not fail the fetch.
+func (a *Aggregator) dumpRaw(name string, body []byte) {
+	if a.dumpRawDir == "" {
+		return
+	}
+
+	if err := os.MkdirAll(a.dumpRawDir, 0755); err != nil {
+		log.Printf("Warning: failed to create raw dump directory: %v\n", err)
+		return
+	}
+
+	path := rawDumpPath(a.dumpRawDir, name)
+	if err := os.WriteFile(path, body, 0644); err != nil {
+		log.Printf("Warning: failed to dump raw response of %s: %v\n", name, err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Placeholders substituted for credentials by -redact. They keep each field
+// non-empty and well-formed so redacted output parses and generates the
+// same way as the original.
+const (
+	redactedUUID   = "00000000-0000-0000-0000-000000000000"
+	redactedSecret = "REDACTED"
+	redactedHash   = "0000000000000000000000000000000000000000000000000000000000000000"
+)
+
+// redactKeptParams are stashed link params that identify client behavior
+// rather than the user, so redaction keeps them for reproducing output
+var redactKeptParams = map[string]bool{"fp": true}
+
+// redactConfigs returns copies of configs with UUIDs, passwords and keys
+// replaced by fixed placeholders, leaving server, port, protocol and
+// transport settings intact for reproducing bug reports. Stashed link params
+// are redacted too, since templates expose them and their meaning is
+// unknown, as are plugin options named like credentials.
+func redactConfigs(configs []*Config) []*Config {
+	redacted := make([]*Config, 0, len(configs))
+	for _, cfg := range configs {
+		clone := cfg.Clone()
+
+		if clone.UUID != "" {
+			clone.UUID = redactedUUID
+		}
+		for _, secret := range []*string{
+			&clone.Password,
+			&clone.TrojanSSPassword,
+			&clone.ObfsPassword,
+			&clone.SSRProtocolParam,
+			&clone.PublicKey,
+			&clone.KCPSeed,
+		} {
+			if *secret != "" {
+				*secret = redactedSecret
+			}
+		}
+		if clone.ShortID != "" {
+			clone.ShortID = "0"
+		}
+		if clone.PinnedCertSHA256 != "" {
+			clone.PinnedCertSHA256 = redactedHash
+		}
+
+		for key := range clone.Metadata {
+			param, ok := strings.CutPrefix(key, "param.")
+			if ok && !redactKeptParams[param] {
+				clone.Metadata[key] = redactedSecret
+			}
+		}
+
+		if clone.Plugin != "" {
+			clone.Plugin = redactPlugin(clone.Plugin)
+			clone.PluginName, clone.PluginOpts = splitSSPlugin(clone.Plugin)
+		}
+
+		redacted = append(redacted, clone)
+	}
+
+	return redacted
+}
+
+// redactPlugin replaces the values of SIP002 plugin options named like
+// credentials, such as shadow-tls's password, keeping the option order
+func redactPlugin(plugin string) string {
+	fields := strings.Split(plugin, ";")
+	for i, field := range fields[1:] {
+		key, _, ok := strings.Cut(field, "=")
+		if ok && isSensitiveName(key) {
+			fields[i+1] = key + "=" + redactedSecret
+		}
+	}
+	return strings.Join(fields, ";")
+}
+
+// sensitiveWords mark a header, plugin option or similar name whose value
+// is a credential
+var sensitiveWords = []string{"key", "token", "secret", "auth", "pass", "psk"}
+
+// isSensitiveName reports whether name mentions a credential word
+func isSensitiveName(name string) bool {
+	lower := strings.ToLower(name)
+	for _, word := range sensitiveWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaders renders source headers for logging as "Name: value" pairs
+// sorted by name, replacing the value of any header whose name or value
+// mentions a credential word with a placeholder
+func redactHeaders(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		value := headers[name]
+		if isSensitiveName(name + " " + value) {
+			value = redactedSecret
+		}
+		parts = append(parts, name+": "+value)
+	}
+	return strings.Join(parts, ", ")
+}
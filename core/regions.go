@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Proxy group layouts for -group-by
+const (
+	GroupByNone   = "none"
+	GroupByRegion = "region"
+)
+
+// Region names used for -group-by region, in the order their groups appear
+const (
+	RegionEurope     = "Europe"
+	RegionMiddleEast = "Middle East"
+	RegionAsia       = "Asia"
+	RegionAmericas   = "Americas"
+	RegionAfrica     = "Africa"
+	RegionOceania    = "Oceania"
+	RegionOther      = "Other"
+)
+
+// regionOrder is the fixed order of region groups in generated output
+var regionOrder = []string{
+	RegionEurope,
+	RegionMiddleEast,
+	RegionAsia,
+	RegionAmericas,
+	RegionAfrica,
+	RegionOceania,
+	RegionOther,
+}
+
+// countryRegions maps ISO 3166-1 alpha-2 codes to regions. Countries not
+// listed fall into RegionOther.
+var countryRegions = map[string]string{
+	// Europe
+	"AL": RegionEurope, "AT": RegionEurope, "BA": RegionEurope, "BE": RegionEurope,
+	"BG": RegionEurope, "BY": RegionEurope, "CH": RegionEurope, "CY": RegionEurope,
+	"CZ": RegionEurope, "DE": RegionEurope, "DK": RegionEurope, "EE": RegionEurope,
+	"ES": RegionEurope, "FI": RegionEurope, "FR": RegionEurope, "GB": RegionEurope,
+	"GR": RegionEurope, "HR": RegionEurope, "HU": RegionEurope, "IE": RegionEurope,
+	"IS": RegionEurope, "IT": RegionEurope, "LT": RegionEurope, "LU": RegionEurope,
+	"LV": RegionEurope, "MD": RegionEurope, "ME": RegionEurope, "MK": RegionEurope,
+	"MT": RegionEurope, "NL": RegionEurope, "NO": RegionEurope, "PL": RegionEurope,
+	"PT": RegionEurope, "RO": RegionEurope, "RS": RegionEurope, "RU": RegionEurope,
+	"SE": RegionEurope, "SI": RegionEurope, "SK": RegionEurope, "UA": RegionEurope,
+
+	// Middle East
+	"AE": RegionMiddleEast, "AM": RegionMiddleEast, "AZ": RegionMiddleEast, "BH": RegionMiddleEast,
+	"GE": RegionMiddleEast, "IL": RegionMiddleEast, "IQ": RegionMiddleEast, "IR": RegionMiddleEast,
+	"JO": RegionMiddleEast, "KW": RegionMiddleEast, "LB": RegionMiddleEast, "OM": RegionMiddleEast,
+	"QA": RegionMiddleEast, "SA": RegionMiddleEast, "SY": RegionMiddleEast, "TR": RegionMiddleEast,
+	"YE": RegionMiddleEast,
+
+	// Asia
+	"AF": RegionAsia, "BD": RegionAsia, "CN": RegionAsia, "HK": RegionAsia,
+	"ID": RegionAsia, "IN": RegionAsia, "JP": RegionAsia, "KG": RegionAsia,
+	"KH": RegionAsia, "KR": RegionAsia, "KZ": RegionAsia, "LK": RegionAsia,
+	"MN": RegionAsia, "MO": RegionAsia, "MY": RegionAsia, "NP": RegionAsia,
+	"PH": RegionAsia, "PK": RegionAsia, "SG": RegionAsia, "TH": RegionAsia,
+	"TJ": RegionAsia, "TM": RegionAsia, "TW": RegionAsia, "UZ": RegionAsia,
+	"VN": RegionAsia,
+
+	// Americas
+	"AR": RegionAmericas, "BR": RegionAmericas, "CA": RegionAmericas, "CL": RegionAmericas,
+	"CO": RegionAmericas, "CR": RegionAmericas, "EC": RegionAmericas, "MX": RegionAmericas,
+	"PA": RegionAmericas, "PE": RegionAmericas, "US": RegionAmericas, "UY": RegionAmericas,
+	"VE": RegionAmericas,
+
+	// Africa
+	"DZ": RegionAfrica, "EG": RegionAfrica, "ET": RegionAfrica, "GH": RegionAfrica,
+	"KE": RegionAfrica, "MA": RegionAfrica, "NG": RegionAfrica, "TN": RegionAfrica,
+	"ZA": RegionAfrica,
+
+	// Oceania
+	"AU": RegionOceania, "NZ": RegionOceania,
+}
+
+// regionOf returns the region of an ISO country code
+func regionOf(country string) string {
+	if region, ok := countryRegions[strings.ToUpper(country)]; ok {
+		return region
+	}
+	return RegionOther
+}
+
+// parseGroupBy validates a -group-by value
+func parseGroupBy(groupBy string) (string, error) {
+	switch groupBy {
+	case GroupByNone, GroupByRegion:
+		return groupBy, nil
+	default:
+		return "", fmt.Errorf("unknown group layout %q (expected none or region)", groupBy)
+	}
+}
+
+// groupByRegion returns the names of configs in each region, keyed by
+// region, preserving config order within a region
+func groupByRegion(configs []*Config) map[string][]string {
+	groups := make(map[string][]string)
+	for _, cfg := range configs {
+		region := regionOf(cfg.Country)
+		groups[region] = append(groups[region], cfg.Name)
+	}
+	return groups
+}
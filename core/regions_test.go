@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestClashRegionGroups tests that -group-by region emits one select group per region with its nodes
+func TestClashRegionGroups(t *testing.T) {
+	configs := []*Config{
+		{ID: "de-1", Protocol: "vless", Server: "a.com", Port: 443, UUID: "uuid-a", Name: "Frankfurt", Country: "DE"},
+		{ID: "jp-1", Protocol: "vless", Server: "b.com", Port: 443, UUID: "uuid-b", Name: "Tokyo", Country: "JP"},
+		{ID: "nl-1", Protocol: "trojan", Server: "c.com", Port: 443, Password: "pass", Name: "Amsterdam", Country: "nl"},
+	}
+
+	gen := NewSubscriptionGenerator("clash-meta")
+	gen.SetGroupBy(GroupByRegion)
+
+	sub, err := gen.Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+	groups := sub[strings.Index(sub, "proxy-groups:"):strings.Index(sub, "rules:")]
+
+	expected := []string{
+		"  - name: \"All\"\n    type: select\n    proxies:\n      - Frankfurt\n      - Tokyo\n      - Amsterdam\n",
+		"  - name: \"Europe\"\n    type: select\n    proxies:\n      - Frankfurt\n      - Amsterdam\n",
+		"  - name: \"Asia\"\n    type: select\n    proxies:\n      - Tokyo\n",
+	}
+	for _, group := range expected {
+		if !strings.Contains(groups, group) {
+			t.Errorf("Expected group:\n%s\ngot:\n%s", group, groups)
+		}
+	}
+
+	if strings.Contains(groups, "Americas") || strings.Contains(groups, RegionOther) {
+		t.Errorf("Expected no groups for regions without nodes, got:\n%s", groups)
+	}
+
+	if _, err := parseGroupBy("continent"); err == nil {
+		t.Errorf("Expected error for unknown group layout")
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "sync"
+
+// rotationSeed makes dynamic pattern rotation reproducible when set. It is
+// process-wide because rotation runs in the security module, outside any
+// one aggregator.
+var rotationSeed struct {
+	sync.RWMutex
+	value *int64
+}
+
+// SetPatternRotationSeed makes ApplyDynamicPatternRotation deterministic:
+// the same seed and packet always yield the same bytes
+func SetPatternRotationSeed(seed int64) {
+	setPatternRotationSeed(&seed)
+}
+
+// ClearPatternRotationSeed restores random pattern rotation
+func ClearPatternRotationSeed() {
+	setPatternRotationSeed(nil)
+}
+
+// setPatternRotationSeed sets the rotation seed, or clears it when nil
+func setPatternRotationSeed(seed *int64) {
+	rotationSeed.Lock()
+	defer rotationSeed.Unlock()
+	rotationSeed.value = seed
+}
+
+// patternRotationSeed returns the configured seed, or nil to rotate randomly
+func patternRotationSeed() *int64 {
+	rotationSeed.RLock()
+	defer rotationSeed.RUnlock()
+	return rotationSeed.value
+}
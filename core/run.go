@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Built-in values for options the sources file can also set
+const (
+	defaultFormat     = "clash-meta"
+	defaultMaxConfigs = 5000
+)
+
+// Options configures a Run. Each field corresponds to the command-line flag
+// of the same purpose, so the CLI and programmatic callers share one code
+// path. Format, Max and Concurrency left zero take the sources file's
+// settings, then the built-in defaults.
+type Options struct {
+	Mode         string
+	Format       string
+	Formats      string // comma-separated; overrides Format
+	Sources      string
+	Rules        string
+	TemplateFile string
+	Output       string
+	Max          int
+	NoCache      bool
+	Concurrency  int
+	FailFast     bool
+	MinSources   int
+	DB           string
+	Input        string
+	OnlyIDs      string
+	Verbose      bool
+	DumpRaw      string
+
+	// Serve mode
+	Listen          string
+	RefreshInterval time.Duration
+	CacheTTL        time.Duration
+	StaleBehavior   string
+
+	// Source failure tracking
+	SourceFailThreshold int
+	SourceBackoff       time.Duration
+	StateFile           string
+
+	// Output shaping
+	Sort            string
+	ScoreWeights    string
+	OutputPolicy    string
+	GroupBy         string
+	GroupType       string
+	LBStrategy      string
+	Front           string
+	TrailingNewline bool
+	Base64          bool
+	PruneFormats    bool // drop configs any of Formats would skip
+	UpdateInterval  time.Duration
+	Redact          bool
+	VMessNameMax    int
+	ObfuscateSNI    bool
+	EmojiFlags      bool
+	GroupTag        string
+	StatsFile       string
+	DedupWindow     time.Duration
+	LogFormat       string
+
+	// Enrichment and validation
+	GeoIP               string
+	TLSCheck            bool
+	CertMinValidity     time.Duration
+	IPBlocklist         string
+	ValidateOnlyChanged bool
+	ReachabilityTTL     time.Duration
+	Progress            string
+	MaxLatency          time.Duration
+	DropDead            bool
+	LearnBlacklist      bool
+
+	// Seed makes dynamic pattern rotation reproducible; nil rotates randomly
+	Seed *int64
+}
+
+// Result is the outcome of a Run
+type Result struct {
+	// Configs produced by generate, fetch, merge and export-db, or the
+	// configs newly added by append
+	Configs []*Config
+
+	// Validation is set by validate mode; check its ExitCode for warnings
+	Validation *ValidationResult
+
+	// Counts is set by count mode, sorted by yield
+	Counts []SourceCount
+}
+
+// DefaultOptions returns the options the command line uses when no flags
+// are given
+func DefaultOptions() Options {
+	return Options{
+		Mode:            "generate",
+		Sources:         "config/sources.yaml",
+		Rules:           "config/iran_rules.json",
+		Output:          "subscriptions/main.txt",
+		DB:              "subscriptions/configs.sqlite",
+		Listen:          ":8080",
+		RefreshInterval: time.Hour,
+		CacheTTL:        6 * time.Hour,
+		StaleBehavior:   StaleBehaviorServe,
+		SourceBackoff:   24 * time.Hour,
+		StateFile:       "config/source_state.json",
+		Sort:            "score",
+		OutputPolicy:    OutputPolicyLax,
+		GroupBy:         GroupByNone,
+		GroupType:       GroupTypeSelect,
+		LBStrategy:      LoadBalanceRoundRobin,
+		TrailingNewline: true,
+		ReachabilityTTL: 24 * time.Hour,
+		Progress:        ProgressAuto,
+		LogFormat:       "text",
+	}
+}
+
+// Run executes opts.Mode. It is the entry point main calls after parsing
+// flags, and can be called directly to embed the aggregator in another
+// program.
+func Run(opts Options) (*Result, error) {
+	if opts.Verbose {
+		log.Println("Starting Iran-Proxy-Unified aggregator...")
+		log.Printf("Mode: %s | Format: %s | Max Configs: %d\n", opts.Mode, opts.Format, opts.Max)
+	}
+
+	setPatternRotationSeed(opts.Seed)
+
+	result := &Result{}
+	var err error
+	switch opts.Mode {
+	case "generate":
+		result.Configs, err = handleGenerate(&opts)
+	case "fetch":
+		result.Configs, err = handleFetch(&opts)
+	case "count":
+		result.Counts, err = handleCount(&opts)
+	case "validate":
+		result.Validation = handleValidate(&opts)
+		err = result.Validation.Err
+	case "qr":
+		err = handleQR(&opts)
+	case "serve":
+		err = handleServe(&opts)
+	case "merge":
+		result.Configs, err = handleMerge(&opts)
+	case "append":
+		result.Configs, err = handleAppend(&opts)
+	case "export-db":
+		result.Configs, err = handleExportDB(&opts)
+	default:
+		return nil, fmt.Errorf("unknown mode: %s", opts.Mode)
+	}
+	if err != nil {
+		return result, fmt.Errorf("error in %s mode: %w", opts.Mode, err)
+	}
+
+	if opts.Verbose {
+		log.Println("Aggregator completed successfully.")
+	}
+	return result, nil
+}
+
+// applySettings fills Format, Max and Concurrency left unset from the
+// sources file's settings, then from the built-in defaults
+func (o *Options) applySettings(settings SourceSettings) {
+	if o.Format == "" {
+		o.Format = settings.Format
+	}
+	if o.Format == "" {
+		o.Format = defaultFormat
+	}
+	if o.Max == 0 {
+		o.Max = settings.Max
+	}
+	if o.Max == 0 {
+		o.Max = defaultMaxConfigs
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = settings.Concurrency
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigScorer scores a config for output ordering; higher scores sort first
+type ConfigScorer func(*Config) float64
+
+// ScoreWeights tunes the components of the default composite score
+type ScoreWeights struct {
+	Latency  float64
+	Protocol float64
+	TLS      float64
+	Source   float64
+}
+
+// DefaultScoreWeights favours measured latency, then protocol strength
+var DefaultScoreWeights = ScoreWeights{Latency: 0.4, Protocol: 0.3, TLS: 0.2, Source: 0.1}
+
+// maxScoredLatency is the ping at which the latency component reaches zero
+const maxScoredLatency = 2000
+
+// protocolScores rates how well each protocol holds up against DPI in Iran
+var protocolScores = map[string]float64{
+	"reality": 1.0,
+	"vless":   0.8,
+	"trojan":  0.8,
+	"vmess":   0.6,
+	"ss":      0.5,
+	"ssr":     0.4,
+}
+
+// ParseScoreWeights parses "latency=0.5,protocol=0.2,tls=0.2,source=0.1".
+// Components that are not listed keep their default weight.
+func ParseScoreWeights(spec string) (ScoreWeights, error) {
+	weights := DefaultScoreWeights
+	if strings.TrimSpace(spec) == "" {
+		return weights, nil
+	}
+
+	for _, field := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return weights, fmt.Errorf("invalid score weight %q, expected name=value", field)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 {
+			return weights, fmt.Errorf("invalid score weight %q", field)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "latency":
+			weights.Latency = weight
+		case "protocol":
+			weights.Protocol = weight
+		case "tls":
+			weights.TLS = weight
+		case "source":
+			weights.Source = weight
+		default:
+			return weights, fmt.Errorf("unknown score component %q", name)
+		}
+	}
+
+	return weights, nil
+}
+
+// NewDefaultScorer builds the composite scorer. sourcePriorities maps source
+// names to their configured priority; higher priorities are trusted more.
+func NewDefaultScorer(weights ScoreWeights, sourcePriorities map[string]int) ConfigScorer {
+	maxPriority := 0
+	for _, priority := range sourcePriorities {
+		if priority > maxPriority {
+			maxPriority = priority
+		}
+	}
+
+	return func(cfg *Config) float64 {
+		score := weights.Protocol * protocolScore(cfg)
+
+		if cfg.Ping > 0 {
+			ping := cfg.Ping
+			if ping > maxScoredLatency {
+				ping = maxScoredLatency
+			}
+			score += weights.Latency * (1 - float64(ping)/maxScoredLatency)
+		}
+
+		if usesTLS(cfg) || cfg.PublicKey != "" {
+			score += weights.TLS
+		}
+
+		if maxPriority > 0 {
+			score += weights.Source * float64(sourcePriorities[cfg.Source]) / float64(maxPriority)
+		}
+
+		return score
+	}
+}
+
+// protocolScore rates a config's protocol, treating REALITY as its own tier
+func protocolScore(cfg *Config) float64 {
+	if cfg.PublicKey != "" {
+		return protocolScores["reality"]
+	}
+	if score, ok := protocolScores[cfg.Protocol]; ok {
+		return score
+	}
+	return 0.3
+}
+
+// rankConfigs returns a copy of configs sorted by descending score. Ties keep
+// their input order.
+func rankConfigs(configs []*Config, scorer ConfigScorer) []*Config {
+	scores := make(map[*Config]float64, len(configs))
+	for _, cfg := range configs {
+		scores[cfg] = scorer(cfg)
+	}
+
+	ranked := make([]*Config, len(configs))
+	copy(ranked, configs)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i]] > scores[ranked[j]]
+	})
+
+	return ranked
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCustomScorerOrdersOutput tests that generated output follows a custom scorer
+func TestCustomScorerOrdersOutput(t *testing.T) {
+	configs := []*Config{
+		{ID: "a", Protocol: "trojan", Server: "a.com", Port: 443, Password: "p", ServerName: "a.com", Name: "Node-A", Ping: 300},
+		{ID: "b", Protocol: "trojan", Server: "b.com", Port: 443, Password: "p", ServerName: "b.com", Name: "Node-B", Ping: 100},
+		{ID: "c", Protocol: "trojan", Server: "c.com", Port: 443, Password: "p", ServerName: "c.com", Name: "Node-C", Ping: 200},
+	}
+
+	gen := NewSubscriptionGenerator("clash")
+	gen.SetScorer(func(cfg *Config) float64 {
+		return -float64(cfg.Ping)
+	})
+
+	sub, err := gen.Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	b, c, a := strings.Index(sub, "Node-B"), strings.Index(sub, "Node-C"), strings.Index(sub, "Node-A")
+	if b < 0 || c < 0 || a < 0 || !(b < c && c < a) {
+		t.Errorf("Expected order Node-B, Node-C, Node-A, got positions %d, %d, %d", b, c, a)
+	}
+
+	if configs[0].Name != "Node-A" {
+		t.Errorf("Ranking should not reorder the caller's slice")
+	}
+}
+
+// TestDefaultScorerComponents tests that the default scorer prefers fast, secure, trusted configs
+func TestDefaultScorerComponents(t *testing.T) {
+	scorer := NewDefaultScorer(DefaultScoreWeights, map[string]int{"trusted": 10, "other": 0})
+
+	fast := &Config{Protocol: "vless", Security: "tls", Ping: 50, Source: "trusted"}
+	slow := &Config{Protocol: "vless", Security: "tls", Ping: 1500, Source: "trusted"}
+	plain := &Config{Protocol: "vless", Ping: 50, Source: "trusted"}
+	untrusted := &Config{Protocol: "vless", Security: "tls", Ping: 50, Source: "other"}
+
+	if scorer(fast) <= scorer(slow) {
+		t.Errorf("Expected lower latency to score higher")
+	}
+	if scorer(fast) <= scorer(plain) {
+		t.Errorf("Expected TLS to score higher")
+	}
+	if scorer(fast) <= scorer(untrusted) {
+		t.Errorf("Expected higher source priority to score higher")
+	}
+}
+
+// TestParseScoreWeights tests parsing of the -score-weights flag
+func TestParseScoreWeights(t *testing.T) {
+	weights, err := ParseScoreWeights("latency=0.7, tls=0")
+	if err != nil {
+		t.Fatalf("Failed to parse weights: %v", err)
+	}
+
+	expected := ScoreWeights{Latency: 0.7, Protocol: DefaultScoreWeights.Protocol, TLS: 0, Source: DefaultScoreWeights.Source}
+	if weights != expected {
+		t.Errorf("Expected %+v, got %+v", expected, weights)
+	}
+
+	for _, spec := range []string{"latency", "speed=1", "tls=-1", "protocol=abc"} {
+		if _, err := ParseScoreWeights(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
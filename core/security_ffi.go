@@ -1,3 +1,5 @@
+//go:build cgo
+
 package main
 
 /*
@@ -165,23 +167,48 @@ func ApplySNIObfuscation(sni string) (string, error) {
 	return "", fmt.Errorf("SNI obfuscation produced invalid output")
 }
 
-// ApplyDynamicPatternRotation applies dynamic pattern rotation
+// ApplyDynamicPatternRotation applies dynamic pattern rotation, seeded by
+// SetPatternRotationSeed when a seed is set
 func ApplyDynamicPatternRotation(packet []byte) ([]byte, error) {
+	return applyDynamicPatternRotation(packet, patternRotationSeed())
+}
+
+// ApplyDynamicPatternRotationSeeded applies dynamic pattern rotation driven
+// by seed, so the same seed and packet always yield the same bytes
+func ApplyDynamicPatternRotationSeeded(packet []byte, seed int64) ([]byte, error) {
+	return applyDynamicPatternRotation(packet, &seed)
+}
+
+// applyDynamicPatternRotation rotates with seed, or randomly when it is nil
+func applyDynamicPatternRotation(packet []byte, seed *int64) ([]byte, error) {
 	if len(packet) == 0 {
 		return packet, nil
 	}
 
-	// Prepare output buffer
-	outputSize := len(packet) + 128
+	// Prepare output buffer: rotation splits packets into chunks of at
+	// least 10 bytes and may insert one byte after each
+	outputSize := len(packet) + len(packet)/10 + 128
 	output := make([]byte, outputSize)
 
 	var outputLen C.int
-	result := C.apply_dynamic_pattern_rotation(
-		(*C.uchar)(unsafe.Pointer(&packet[0])),
-		C.int(len(packet)),
-		(*C.uchar)(unsafe.Pointer(&output[0])),
-		&outputLen,
-	)
+	var result C.int
+	if seed != nil {
+		result = C.apply_dynamic_pattern_rotation_seeded(
+			(*C.uchar)(unsafe.Pointer(&packet[0])),
+			C.int(len(packet)),
+			(*C.uchar)(unsafe.Pointer(&output[0])),
+			C.int(len(output)),
+			&outputLen,
+			C.uint64_t(*seed),
+		)
+	} else {
+		result = C.apply_dynamic_pattern_rotation(
+			(*C.uchar)(unsafe.Pointer(&packet[0])),
+			C.int(len(packet)),
+			(*C.uchar)(unsafe.Pointer(&output[0])),
+			&outputLen,
+		)
+	}
 
 	if result != 0 {
 		errMsg := C.GoString(C.get_last_error())
@@ -0,0 +1,98 @@
+//go:build !cgo
+
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrSecurityUnavailable is returned by the security wrappers in builds
+// without cgo, where the Rust module cannot be linked
+var ErrSecurityUnavailable = errors.New("security module unavailable: built without cgo")
+
+// SecurityFFIOptions mirrors the cgo build's options struct
+type SecurityFFIOptions struct {
+	FragmentationBytes     int
+	DelayMS                int
+	RandomizationLevel     int
+	EnableSNIObfuscation   bool
+	EnableTLSFragmentation bool
+}
+
+// SafeProcessOutgoing is unavailable without cgo
+func SafeProcessOutgoing(data []byte, opts *SecurityFFIOptions) ([]byte, error) {
+	return nil, ErrSecurityUnavailable
+}
+
+// SafeProcessIncoming is unavailable without cgo
+func SafeProcessIncoming(data []byte) ([]byte, error) {
+	return nil, ErrSecurityUnavailable
+}
+
+// ApplyTLSFragmentation is unavailable without cgo
+func ApplyTLSFragmentation(handshake []byte, fragmentSize int) ([]byte, error) {
+	return nil, ErrSecurityUnavailable
+}
+
+// ApplySNIObfuscation returns the SNI unchanged: the obfuscation lives in
+// the Rust module, and passing through keeps -obfuscate-sni usable
+func ApplySNIObfuscation(sni string) (string, error) {
+	return sni, nil
+}
+
+// ApplyDynamicPatternRotation applies the Rust module's pattern variation in
+// Go, seeded by SetPatternRotationSeed when a seed is set: packets over 100
+// bytes are split into 10-49 byte chunks with a random byte inserted after
+// roughly 30% of them. With a seed the output is reproducible, though it
+// does not match the Rust module's for the same seed.
+func ApplyDynamicPatternRotation(packet []byte) ([]byte, error) {
+	return applyDynamicPatternRotation(packet, patternRotationSeed())
+}
+
+// ApplyDynamicPatternRotationSeeded applies the pattern variation driven by
+// seed, so the same seed and packet always yield the same bytes
+func ApplyDynamicPatternRotationSeeded(packet []byte, seed int64) ([]byte, error) {
+	return applyDynamicPatternRotation(packet, &seed)
+}
+
+// applyDynamicPatternRotation rotates with seed, or randomly when it is nil
+func applyDynamicPatternRotation(packet []byte, seed *int64) ([]byte, error) {
+	if len(packet) <= 100 {
+		return append([]byte(nil), packet...), nil
+	}
+
+	source := time.Now().UnixNano()
+	if seed != nil {
+		source = *seed
+	}
+	rng := rand.New(rand.NewSource(source))
+
+	chunkSize := 10 + rng.Intn(40)
+	output := make([]byte, 0, len(packet)+len(packet)/chunkSize+1)
+	for start := 0; start < len(packet); start += chunkSize {
+		end := min(start+chunkSize, len(packet))
+		output = append(output, packet[start:end]...)
+		if rng.Float64() < 0.3 {
+			output = append(output, byte(rng.Intn(256)))
+		}
+	}
+
+	return output, nil
+}
+
+// InitSecurityModule is unavailable without cgo
+func InitSecurityModule() error {
+	return ErrSecurityUnavailable
+}
+
+// ShutdownSecurityModule is a no-op without cgo
+func ShutdownSecurityModule() error {
+	return nil
+}
+
+// GetLastError returns no error text without cgo
+func GetLastError() string {
+	return ""
+}
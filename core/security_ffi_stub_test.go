@@ -0,0 +1,84 @@
+//go:build !cgo
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestPatternRotationSeeded tests that the same seed yields the same transformed bytes
+func TestPatternRotationSeeded(t *testing.T) {
+	defer ClearPatternRotationSeed()
+
+	packet := bytes.Repeat([]byte("iran-proxy-unified "), 20)
+
+	SetPatternRotationSeed(42)
+	first, err := ApplyDynamicPatternRotation(packet)
+	if err != nil {
+		t.Fatalf("Rotation failed: %v", err)
+	}
+	second, err := ApplyDynamicPatternRotation(packet)
+	if err != nil {
+		t.Fatalf("Rotation failed: %v", err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Errorf("Expected identical output for the same seed")
+	}
+	if len(first) <= len(packet) {
+		t.Errorf("Expected rotation to insert bytes, got %d from %d", len(first), len(packet))
+	}
+
+	other, err := ApplyDynamicPatternRotationSeeded(packet, 7)
+	if err != nil {
+		t.Fatalf("Rotation failed: %v", err)
+	}
+	if bytes.Equal(first, other) {
+		t.Errorf("Expected different output for a different seed")
+	}
+}
+
+// TestRunPassesSeedToRotation tests that Options.Seed, set from -seed,
+// fixes the rotation for the run
+func TestRunPassesSeedToRotation(t *testing.T) {
+	defer ClearPatternRotationSeed()
+
+	packet := bytes.Repeat([]byte("iran-proxy-unified "), 20)
+	want, err := ApplyDynamicPatternRotationSeeded(packet, 42)
+	if err != nil {
+		t.Fatalf("Rotation failed: %v", err)
+	}
+
+	seed := int64(42)
+	opts := DefaultOptions()
+	opts.Mode = "bogus"
+	opts.Seed = &seed
+	Run(opts)
+
+	got, err := ApplyDynamicPatternRotation(packet)
+	if err != nil {
+		t.Fatalf("Rotation failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected rotation seeded by Options.Seed")
+	}
+
+	opts.Seed = nil
+	Run(opts)
+	if patternRotationSeed() != nil {
+		t.Errorf("Expected a run without a seed to rotate randomly")
+	}
+}
+
+// TestObfuscateSNIsStub tests that -obfuscate-sni passes SNIs through unchanged without cgo
+func TestObfuscateSNIsStub(t *testing.T) {
+	cfg := &Config{Protocol: "vless", Server: "a.com", Port: 443, UUID: "uuid-a", ServerName: "a.example.com"}
+
+	if n := obfuscateSNIs([]*Config{cfg}, ApplySNIObfuscation); n != 0 {
+		t.Errorf("Expected no configs rewritten by the stub, got %d", n)
+	}
+	if cfg.ServerName != "a.example.com" || cfg.GetMeta(metaSNIObfuscated) != "" {
+		t.Errorf("Expected SNI unchanged and unflagged, got %q (flag %q)", cfg.ServerName, cfg.GetMeta(metaSNIObfuscated))
+	}
+}
@@ -0,0 +1,44 @@
+//go:build cgo
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestPatternRotationSeeded tests that the same seed yields the same transformed bytes
+func TestPatternRotationSeeded(t *testing.T) {
+	if err := InitSecurityModule(); err != nil {
+		t.Fatalf("Failed to init security module: %v", err)
+	}
+	defer ShutdownSecurityModule()
+
+	packet := bytes.Repeat([]byte("iran-proxy-unified "), 100)
+
+	first, err := ApplyDynamicPatternRotationSeeded(packet, 42)
+	if err != nil {
+		t.Fatalf("Rotation failed: %v", err)
+	}
+	second, err := ApplyDynamicPatternRotationSeeded(packet, 42)
+	if err != nil {
+		t.Fatalf("Rotation failed: %v", err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Errorf("Expected identical output for the same seed")
+	}
+
+	if len(first) <= len(packet) {
+		t.Errorf("Expected rotation to insert bytes, got %d from %d", len(first), len(packet))
+	}
+
+	other, err := ApplyDynamicPatternRotationSeeded(packet, 7)
+	if err != nil {
+		t.Fatalf("Rotation failed: %v", err)
+	}
+
+	if bytes.Equal(first, other) {
+		t.Errorf("Expected different output for a different seed")
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Stale behaviors decide what serve mode returns once the last successful
+// refresh is older than the cache TTL
+const (
+	StaleBehaviorServe = "serve" // keep serving the last good subscription
+	StaleBehaviorError = "error" // respond 503 Service Unavailable
+	StaleBehaviorEmpty = "empty" // respond 200 with an empty body
+)
+
+// parseStaleBehavior validates a -stale-behavior value
+func parseStaleBehavior(behavior string) (string, error) {
+	switch behavior {
+	case StaleBehaviorServe, StaleBehaviorError, StaleBehaviorEmpty:
+		return behavior, nil
+	default:
+		return "", fmt.Errorf("unknown stale behavior %q (want serve, error or empty)", behavior)
+	}
+}
+
+// subscriptionContentType returns the Content-Type for a subscription
+// format; base64-wrapped output is always plain text
+func subscriptionContentType(format string, base64 bool) string {
+	if base64 && (format == "clash" || format == "clash-meta") {
+		return "text/plain; charset=utf-8"
+	}
+	switch format {
+	case "clash", "clash-meta":
+		return "text/yaml; charset=utf-8"
+	case "singbox", "uri-json":
+		return "application/json"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// shutdownTimeout bounds how long serve mode waits for in-flight requests
+// to finish after a shutdown signal
+const shutdownTimeout = 10 * time.Second
+
+// SubscriptionServer serves the most recently generated subscription over
+// HTTP and refreshes it in the background
+type SubscriptionServer struct {
+	refresh       func() ([]byte, error)
+	ttl           time.Duration
+	staleBehavior string
+	contentType   string
+	headers       map[string]string
+	cacheFile     string
+	now           func() time.Time
+
+	mu        sync.RWMutex
+	body      []byte
+	updatedAt time.Time
+}
+
+// NewSubscriptionServer creates a server whose subscription is produced by
+// refresh and stays fresh for ttl after each successful refresh
+func NewSubscriptionServer(refresh func() ([]byte, error), ttl time.Duration, staleBehavior string) *SubscriptionServer {
+	return &SubscriptionServer{
+		refresh:       refresh,
+		ttl:           ttl,
+		staleBehavior: staleBehavior,
+		contentType:   "text/plain; charset=utf-8",
+		now:           time.Now,
+	}
+}
+
+// SetContentType sets the Content-Type of served subscriptions
+func (s *SubscriptionServer) SetContentType(contentType string) {
+	s.contentType = contentType
+}
+
+// SetHeader adds a response header to served subscriptions
+func (s *SubscriptionServer) SetHeader(key, value string) {
+	if s.headers == nil {
+		s.headers = make(map[string]string)
+	}
+	s.headers[key] = value
+}
+
+// SetCacheFile sets the file the subscription is saved to on shutdown and
+// loaded from by LoadCache, so a restart serves the last good subscription
+// before its first refresh
+func (s *SubscriptionServer) SetCacheFile(path string) {
+	s.cacheFile = path
+}
+
+// LoadCache loads a subscription saved by an earlier run. It counts as
+// refreshed at the file's modification time, so an old file is stale. A
+// missing file is not an error.
+func (s *SubscriptionServer) LoadCache() error {
+	if s.cacheFile == "" {
+		return nil
+	}
+
+	info, err := os.Stat(s.cacheFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read subscription cache: %w", err)
+	}
+	body, err := os.ReadFile(s.cacheFile)
+	if err != nil {
+		return fmt.Errorf("failed to read subscription cache: %w", err)
+	}
+
+	s.mu.Lock()
+	s.body = body
+	s.updatedAt = info.ModTime()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// SaveCache writes the current subscription to the cache file, through a
+// temporary file so an interrupted save keeps the previous one
+func (s *SubscriptionServer) SaveCache() error {
+	s.mu.RLock()
+	body := s.body
+	updatedAt := s.updatedAt
+	s.mu.RUnlock()
+
+	if s.cacheFile == "" || body == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.cacheFile), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmp := s.cacheFile + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return fmt.Errorf("failed to write subscription cache: %w", err)
+	}
+	// The modification time records when the subscription was generated
+	os.Chtimes(tmp, updatedAt, updatedAt)
+	if err := os.Rename(tmp, s.cacheFile); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write subscription cache: %w", err)
+	}
+
+	return nil
+}
+
+// Refresh regenerates the subscription. On failure the previous
+// subscription is kept and ages towards the stale behavior.
+func (s *SubscriptionServer) Refresh() error {
+	body, err := s.refresh()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.body = body
+	s.updatedAt = s.now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// RefreshEvery refreshes the subscription on every tick of interval,
+// logging failures, until ctx is done. It blocks, so run it in its own
+// goroutine.
+func (s *SubscriptionServer) RefreshEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(); err != nil {
+				log.Printf("Refresh failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// Serve serves the subscription on ln and refreshes it every interval until
+// ctx is done. It then stops refreshing, drains in-flight requests (for up
+// to shutdownTimeout) and saves the cache file.
+func (s *SubscriptionServer) Serve(ctx context.Context, ln net.Listener, interval time.Duration) error {
+	refreshCtx, stopRefresh := context.WithCancel(ctx)
+	defer stopRefresh()
+
+	var refreshing sync.WaitGroup
+	refreshing.Add(1)
+	go func() {
+		defer refreshing.Done()
+		s.RefreshEvery(refreshCtx, interval)
+	}()
+
+	server := &http.Server{Handler: s}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ln)
+	}()
+
+	var serveErr error
+	select {
+	case serveErr = <-served:
+	case <-ctx.Done():
+		log.Println("Shutting down, draining in-flight requests...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		serveErr = server.Shutdown(shutdownCtx)
+	}
+	if errors.Is(serveErr, http.ErrServerClosed) {
+		serveErr = nil
+	}
+
+	// Let a refresh in progress finish so the saved cache is the newest
+	stopRefresh()
+	refreshing.Wait()
+
+	if err := s.SaveCache(); err != nil {
+		return errors.Join(serveErr, err)
+	}
+	return serveErr
+}
+
+// ServeHTTP writes the current subscription, applying the stale behavior
+// when there is no subscription younger than the TTL
+func (s *SubscriptionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	body := s.body
+	updatedAt := s.updatedAt
+	s.mu.RUnlock()
+
+	stale := body == nil || s.now().Sub(updatedAt) > s.ttl
+	if stale {
+		switch {
+		case s.staleBehavior == StaleBehaviorEmpty:
+			body = []byte{}
+		case s.staleBehavior == StaleBehaviorError || body == nil:
+			http.Error(w, "subscription unavailable", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", s.contentType)
+	for key, value := range s.headers {
+		w.Header().Set(key, value)
+	}
+	if !updatedAt.IsZero() {
+		w.Header().Set("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+	}
+	w.Write(body)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newStaleTestServer returns a server that refreshed once at start, then
+// failed every refresh until the clock moved past its TTL
+func newStaleTestServer(t *testing.T, behavior string) *SubscriptionServer {
+	t.Helper()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fail := false
+	srv := NewSubscriptionServer(func() ([]byte, error) {
+		if fail {
+			return nil, errors.New("all sources failed")
+		}
+		return []byte("vless://uuid@server.com:443\n"), nil
+	}, time.Hour, behavior)
+	srv.now = func() time.Time { return now }
+
+	if err := srv.Refresh(); err != nil {
+		t.Fatalf("Initial refresh failed: %v", err)
+	}
+
+	fail = true
+	for i := 0; i < 3; i++ {
+		now = now.Add(30 * time.Minute)
+		if err := srv.Refresh(); err == nil {
+			t.Fatalf("Expected simulated refresh failure")
+		}
+	}
+
+	return srv
+}
+
+// TestServeFreshSubscription tests that a failed refresh within the TTL keeps serving
+func TestServeFreshSubscription(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	srv := NewSubscriptionServer(func() ([]byte, error) {
+		calls++
+		if calls > 1 {
+			return nil, errors.New("source down")
+		}
+		return []byte("body"), nil
+	}, time.Hour, StaleBehaviorError)
+	srv.now = func() time.Time { return now }
+
+	srv.Refresh()
+	now = now.Add(30 * time.Minute)
+	srv.Refresh()
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "body" {
+		t.Errorf("Expected fresh subscription to be served, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+// TestServeStaleBehaviors tests each stale behavior after a prolonged fetch failure
+func TestServeStaleBehaviors(t *testing.T) {
+	tests := []struct {
+		behavior string
+		code     int
+		body     string
+	}{
+		{StaleBehaviorServe, http.StatusOK, "vless://uuid@server.com:443\n"},
+		{StaleBehaviorError, http.StatusServiceUnavailable, "subscription unavailable\n"},
+		{StaleBehaviorEmpty, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		srv := newStaleTestServer(t, tt.behavior)
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.behavior, tt.code, rec.Code)
+		}
+		if rec.Body.String() != tt.body {
+			t.Errorf("%s: expected body %q, got %q", tt.behavior, tt.body, rec.Body.String())
+		}
+	}
+}
+
+// TestServeBeforeFirstRefresh tests that serving with no subscription yet is a 503
+func TestServeBeforeFirstRefresh(t *testing.T) {
+	srv := NewSubscriptionServer(func() ([]byte, error) {
+		return nil, errors.New("never succeeds")
+	}, time.Hour, StaleBehaviorServe)
+	srv.Refresh()
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 before any successful refresh, got %d", rec.Code)
+	}
+}
+
+// TestServeGracefulShutdown tests that cancelling the serve context drains
+// a pending request, then saves the subscription cache for the next start
+func TestServeGracefulShutdown(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "sub.txt")
+	srv := NewSubscriptionServer(func() ([]byte, error) {
+		return []byte("vless://uuid@server.com:443\n"), nil
+	}, time.Hour, StaleBehaviorError)
+	srv.SetCacheFile(cacheFile)
+	if err := srv.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	// Hold the next request inside the handler until released
+	var block atomic.Bool
+	var once sync.Once
+	started, release := make(chan struct{}), make(chan struct{})
+	srv.now = func() time.Time {
+		if block.Load() {
+			once.Do(func() { close(started) })
+			<-release
+		}
+		return time.Now()
+	}
+	block.Store(true)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx, ln, time.Hour) }()
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{status: resp.StatusCode, body: string(body), err: err}
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Serve returned before the pending request finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	block.Store(false)
+	close(release)
+
+	res := <-responses
+	if res.err != nil {
+		t.Fatalf("Pending request failed: %v", res.err)
+	}
+	if res.status != http.StatusOK || res.body != "vless://uuid@server.com:443\n" {
+		t.Errorf("Expected the drained request to get the subscription, got %d %q", res.status, res.body)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Serve returned an error: %v", err)
+	}
+
+	saved, err := os.ReadFile(cacheFile)
+	if err != nil {
+		t.Fatalf("Expected the cache to be saved: %v", err)
+	}
+	if string(saved) != "vless://uuid@server.com:443\n" {
+		t.Errorf("Expected the saved cache to hold the subscription, got %q", saved)
+	}
+
+	// A restarted server serves the saved subscription before refreshing
+	restarted := NewSubscriptionServer(func() ([]byte, error) {
+		return nil, errors.New("sources down")
+	}, time.Hour, StaleBehaviorError)
+	restarted.SetCacheFile(cacheFile)
+	if err := restarted.LoadCache(); err != nil {
+		t.Fatalf("LoadCache failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	restarted.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != string(saved) {
+		t.Errorf("Expected the cached subscription after restart, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+// TestServeHeaders tests that headers set on the server are sent with the
+// subscription
+func TestServeHeaders(t *testing.T) {
+	srv := NewSubscriptionServer(func() ([]byte, error) {
+		return []byte("proxies:\n"), nil
+	}, time.Hour, StaleBehaviorError)
+	srv.SetHeader("Profile-Update-Interval", "12")
+	srv.Refresh()
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := rec.Header().Get("Profile-Update-Interval"); got != "12" {
+		t.Errorf("Expected Profile-Update-Interval 12, got %q", got)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestConfigStringRoundTrip tests that share URIs re-parse to the same config
+func TestConfigStringRoundTrip(t *testing.T) {
+	parser := NewProtocolParser()
+
+	configs := []*Config{
+		{Protocol: "vless", Server: "vless.example.com", Port: 443, UUID: "12345678-1234-1234-1234-123456789012", Security: "tls", ServerName: "vless.example.com", Flow: "xtls-rprx-vision", Name: "VLESS Node"},
+		{Protocol: "vless", Server: "reality.example.com", Port: 443, UUID: "uuid-reality", PublicKey: "pbk123", ShortID: "sid123", ServerName: "real.example.com", Name: "REALITY Node"},
+		{Protocol: "trojan", Server: "trojan.example.com", Port: 8443, Password: "secret", TLSServerName: "trojan.example.com", ServerName: "trojan.example.com", Name: "Trojan Node"},
+		{Protocol: "vmess", Server: "vmess.example.com", Port: 443, UUID: "vmess-uuid", AlterId: 0, Cipher: "auto", Name: "VMess Node"},
+	}
+
+	for _, cfg := range configs {
+		link := cfg.String()
+		if !strings.HasPrefix(link, cfg.Protocol+"://") {
+			t.Errorf("Expected %s link, got %s", cfg.Protocol, link)
+		}
+
+		parsed, err := parser.ParseConfig(link, "round-trip")
+		if err != nil {
+			t.Fatalf("Failed to re-parse %s: %v", link, err)
+		}
+
+		if parsed.Key() != cfg.Key() {
+			t.Errorf("Round trip changed config:\n  original %+v\n  parsed   %+v", cfg, parsed)
+		}
+
+		if parsed.Name != cfg.Name {
+			t.Errorf("Expected name %q after round trip, got %q", cfg.Name, parsed.Name)
+		}
+	}
+}
+
+// TestShadowsocksLinkFormat tests that ss links use SIP002 userinfo encoding
+func TestShadowsocksLinkFormat(t *testing.T) {
+	cfg := &Config{Protocol: "ss", Server: "ss.example.com", Port: 8388, Method: "aes-256-gcm", Password: "pass", Name: "SS Node"}
+
+	link := cfg.String()
+	if link != "ss://YWVzLTI1Ni1nY206cGFzcw@ss.example.com:8388#SS%20Node" {
+		t.Errorf("Unexpected ss link: %s", link)
+	}
+}
+
+// TestVMessNameTruncation tests that long VMess names are cut to the limit on a rune boundary
+func TestVMessNameTruncation(t *testing.T) {
+	subGen := NewSubscriptionGenerator("v2ray")
+	subGen.SetVMessNameLimit(64)
+
+	// 2-byte runes, so a naive byte cut before the ellipsis would land mid-rune
+	name := strings.Repeat("ایران", 30)
+	if len(name) != 300 {
+		t.Fatalf("Expected a 300 byte name, got %d", len(name))
+	}
+
+	cfg := &Config{Protocol: "vmess", Server: "long.example.com", Port: 443, UUID: "vmess-uuid", Name: name}
+	sub, err := subGen.Generate([]*Config{cfg})
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	parsed, err := NewProtocolParser().ParseConfig(strings.TrimSpace(sub), "test")
+	if err != nil {
+		t.Fatalf("Failed to re-parse truncated link: %v", err)
+	}
+
+	if len(parsed.Name) > 64 {
+		t.Errorf("Expected name of at most 64 bytes, got %d", len(parsed.Name))
+	}
+	if !utf8.ValidString(parsed.Name) {
+		t.Errorf("Expected truncation on a rune boundary, got %q", parsed.Name)
+	}
+	if !strings.HasSuffix(parsed.Name, "…") || !strings.HasPrefix(name, strings.TrimSuffix(parsed.Name, "…")) {
+		t.Errorf("Expected a prefix of the name ending with …, got %q", parsed.Name)
+	}
+	if cfg.Name != name {
+		t.Errorf("Expected the config's own name to be left intact")
+	}
+
+	// The limit belongs to the generator, not to every share link
+	full, err := NewProtocolParser().ParseConfig(cfg.String(), "test")
+	if err != nil {
+		t.Fatalf("Failed to re-parse link: %v", err)
+	}
+	if full.Name != name {
+		t.Errorf("Expected String to leave the name untouched, got %q", full.Name)
+	}
+}
+
+// TestTruncateNameShortLimit tests that limits too small for the ellipsis
+// still cut the name to at most that many bytes
+func TestTruncateNameShortLimit(t *testing.T) {
+	for max := 1; max <= 4; max++ {
+		got := truncateName("ایران", max)
+		if len(got) > max {
+			t.Errorf("truncateName with max %d returned %q (%d bytes)", max, got, len(got))
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncateName with max %d cut mid-rune: %q", max, got)
+		}
+	}
+}
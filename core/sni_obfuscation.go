@@ -0,0 +1,39 @@
+package main
+
+import "log"
+
+// metaSNIObfuscated marks configs whose SNI was rewritten by -obfuscate-sni
+const metaSNIObfuscated = "sni_obfuscated"
+
+// obfuscateSNIs runs each config's SNI (ServerName, and TLSServerName for
+// trojan) through obfuscate, storing the result and flagging the config in
+// Metadata when the value changed. Configs whose obfuscation fails keep
+// their SNI. It returns the number of configs rewritten.
+func obfuscateSNIs(configs []*Config, obfuscate func(string) (string, error)) int {
+	rewritten := 0
+	for _, cfg := range configs {
+		changed := false
+		for _, field := range []*string{&cfg.ServerName, &cfg.TLSServerName} {
+			if *field == "" {
+				continue
+			}
+
+			sni, err := obfuscate(*field)
+			if err != nil {
+				log.Printf("Warning: keeping SNI %s of %s: %v\n", *field, cfg.Name, err)
+				continue
+			}
+			if sni != "" && sni != *field {
+				*field = sni
+				changed = true
+			}
+		}
+
+		if changed {
+			cfg.SetMeta(metaSNIObfuscated, "true")
+			rewritten++
+		}
+	}
+
+	return rewritten
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestObfuscateSNIs tests that SNIs go through the obfuscator and rewritten configs are flagged
+func TestObfuscateSNIs(t *testing.T) {
+	configs := []*Config{
+		{Protocol: "vless", Server: "a.com", Port: 443, UUID: "uuid-a", ServerName: "a.example.com"},
+		{Protocol: "trojan", Server: "b.com", Port: 443, Password: "pass", TLSServerName: "b.example.com"},
+		{Protocol: "vless", Server: "c.com", Port: 80, UUID: "uuid-c"},
+		{Protocol: "vless", Server: "d.com", Port: 443, UUID: "uuid-d", ServerName: "fail.example.com"},
+	}
+
+	var calls []string
+	obfuscate := func(sni string) (string, error) {
+		calls = append(calls, sni)
+		if sni == "fail.example.com" {
+			return "", errors.New("module error")
+		}
+		return "x-" + sni, nil
+	}
+
+	if n := obfuscateSNIs(configs, obfuscate); n != 2 {
+		t.Errorf("Expected 2 configs rewritten, got %d", n)
+	}
+	if len(calls) != 3 {
+		t.Errorf("Expected the obfuscator to be called for the 3 configs with an SNI, got %v", calls)
+	}
+
+	if configs[0].ServerName != "x-a.example.com" || configs[0].GetMeta(metaSNIObfuscated) != "true" {
+		t.Errorf("Expected obfuscated and flagged ServerName, got %q (flag %q)", configs[0].ServerName, configs[0].GetMeta(metaSNIObfuscated))
+	}
+	if configs[1].TLSServerName != "x-b.example.com" {
+		t.Errorf("Expected obfuscated trojan SNI, got %q", configs[1].TLSServerName)
+	}
+	if configs[2].GetMeta(metaSNIObfuscated) != "" {
+		t.Errorf("Expected a config without SNI to be left unflagged")
+	}
+	if configs[3].ServerName != "fail.example.com" || configs[3].GetMeta(metaSNIObfuscated) != "" {
+		t.Errorf("Expected a failed obfuscation to keep the SNI unflagged, got %q", configs[3].ServerName)
+	}
+}
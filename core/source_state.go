@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SourceHealth is the persisted failure history of one source
+type SourceHealth struct {
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastFailure         time.Time `json:"last_failure,omitempty"`
+	SkipUntil           time.Time `json:"skip_until,omitempty"`
+}
+
+// SourceState tracks source failures across runs in a JSON file, so
+// sources that keep failing can be skipped for a backoff period
+type SourceState struct {
+	path      string
+	threshold int
+	backoff   time.Duration
+
+	mu      sync.Mutex
+	sources map[string]*SourceHealth
+}
+
+// LoadSourceState reads the state file at path. A missing file is an empty
+// state. A source is skipped for backoff once it has failed threshold times
+// in a row.
+func LoadSourceState(path string, threshold int, backoff time.Duration) (*SourceState, error) {
+	state := &SourceState{
+		path:      path,
+		threshold: threshold,
+		backoff:   backoff,
+		sources:   make(map[string]*SourceHealth),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &state.sources); err != nil {
+		return nil, fmt.Errorf("failed to parse source state: %w", err)
+	}
+	if state.sources == nil {
+		state.sources = make(map[string]*SourceHealth)
+	}
+
+	return state, nil
+}
+
+// Skip reports whether the source is in its backoff period at now, and
+// its consecutive failure count
+func (s *SourceState) Skip(name string, now time.Time) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	health := s.sources[name]
+	if health == nil {
+		return false, 0
+	}
+	return now.Before(health.SkipUntil), health.ConsecutiveFailures
+}
+
+// RecordFailure counts a failed fetch, starting a backoff period once the
+// threshold is reached. A failure after the backoff starts another one.
+func (s *SourceState) RecordFailure(name string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	health := s.sources[name]
+	if health == nil {
+		health = &SourceHealth{}
+		s.sources[name] = health
+	}
+
+	health.ConsecutiveFailures++
+	health.LastFailure = now
+	if s.threshold > 0 && health.ConsecutiveFailures >= s.threshold {
+		health.SkipUntil = now.Add(s.backoff)
+	}
+}
+
+// RecordSuccess clears the source's failure history
+func (s *SourceState) RecordSuccess(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sources, name)
+}
+
+// Save writes the state file via a temporary file and rename
+func (s *SourceState) Save() error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.sources, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode source state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write source state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write source state: %w", err)
+	}
+
+	return nil
+}
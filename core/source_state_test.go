@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSourceFailThreshold tests that a source failing three runs in a row is skipped on the fourth
+func TestSourceFailThreshold(t *testing.T) {
+	var deadHits, liveHits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dead" {
+			atomic.AddInt32(&deadHits, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		atomic.AddInt32(&liveHits, 1)
+		fmt.Fprintln(w, "vless://uuid-live@live.com:443")
+	}))
+	defer server.Close()
+
+	sources := []ConfigSource{
+		{Name: "dead", URL: server.URL + "/dead", Type: "plain", Enabled: true},
+		{Name: "live", URL: server.URL + "/live", Type: "plain", Enabled: true},
+	}
+	statePath := filepath.Join(t.TempDir(), "source_state.json")
+
+	run := func() {
+		t.Helper()
+
+		// Each run is a fresh process, sharing only the state file
+		state, err := LoadSourceState(statePath, 3, time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to load source state: %v", err)
+		}
+
+		agg := newTestAggregator(t, sources, 100)
+		agg.SetNoCache(true)
+		agg.SetSourceState(state)
+
+		if _, err := agg.FetchAndProcessConfigs(); err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		run()
+	}
+
+	hitsBefore := atomic.LoadInt32(&deadHits)
+	if hitsBefore == 0 {
+		t.Fatalf("Expected the dead source to be fetched during the first three runs")
+	}
+
+	run()
+
+	if hits := atomic.LoadInt32(&deadHits); hits != hitsBefore {
+		t.Errorf("Expected the fourth run to skip the dead source, got %d more requests", hits-hitsBefore)
+	}
+
+	if hits := atomic.LoadInt32(&liveHits); hits != 4 {
+		t.Errorf("Expected the live source to be fetched every run, got %d", hits)
+	}
+
+	state, err := LoadSourceState(statePath, 3, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to reload source state: %v", err)
+	}
+	if skip, failures := state.Skip("dead", time.Now()); !skip || failures != 3 {
+		t.Errorf("Expected dead source skipped after 3 failures, got skip=%v failures=%d", skip, failures)
+	}
+	if skip, _ := state.Skip("live", time.Now()); skip {
+		t.Errorf("Expected the live source not to be skipped")
+	}
+}
+
+// TestSourceStateBackoffExpires tests that a source is retried once its backoff has passed
+func TestSourceStateBackoffExpires(t *testing.T) {
+	state, err := LoadSourceState(filepath.Join(t.TempDir(), "state.json"), 2, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to load source state: %v", err)
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	state.RecordFailure("flaky", now)
+	if skip, _ := state.Skip("flaky", now); skip {
+		t.Errorf("Expected no skip below the threshold")
+	}
+
+	state.RecordFailure("flaky", now)
+	if skip, _ := state.Skip("flaky", now.Add(30*time.Minute)); !skip {
+		t.Errorf("Expected skip within the backoff period")
+	}
+	if skip, _ := state.Skip("flaky", now.Add(2*time.Hour)); skip {
+		t.Errorf("Expected a retry after the backoff period")
+	}
+
+	state.RecordSuccess("flaky")
+	if _, failures := state.Skip("flaky", now); failures != 0 {
+		t.Errorf("Expected success to reset failures, got %d", failures)
+	}
+}
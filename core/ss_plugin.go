@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+)
+
+// splitSSPlugin splits a SIP002 plugin value such as
+// "obfs-local;obfs=http;obfs-host=example.com" into the plugin name and its
+// options. Flag options without a value, such as v2ray-plugin's "tls", map
+// to an empty string.
+func splitSSPlugin(plugin string) (string, map[string]string) {
+	fields := strings.Split(plugin, ";")
+	opts := make(map[string]string, len(fields)-1)
+	for _, field := range fields[1:] {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(field, "=")
+		opts[key] = value
+	}
+	return strings.TrimSpace(fields[0]), opts
+}
+
+// ssObfsDisabled reports whether a Shadowsocks link explicitly turns
+// obfuscation off with obfs=none, either as a link parameter or as a plugin
+// option. Such links get no plugin at all, whatever other plugin fields say.
+func ssObfsDisabled(params map[string]string) bool {
+	if strings.EqualFold(params["obfs"], "none") {
+		return true
+	}
+	_, opts := splitSSPlugin(params["plugin"])
+	return strings.EqualFold(opts["obfs"], "none")
+}
+
+// clashSSPlugin renders a Shadowsocks plugin as Clash plugin and
+// plugin-opts fields, or an empty string for plugins Clash does not support
+func clashSSPlugin(plugin string) string {
+	name, opts := splitSSPlugin(plugin)
+
+	var sb strings.Builder
+	switch name {
+	case "obfs-local", "simple-obfs", "obfs":
+		sb.WriteString("    plugin: obfs\n")
+		sb.WriteString("    plugin-opts:\n")
+		sb.WriteString("      mode: " + yamlScalar(opts["obfs"]) + "\n")
+		if host := opts["obfs-host"]; host != "" {
+			sb.WriteString("      host: " + yamlScalar(host) + "\n")
+		}
+	case "v2ray-plugin":
+		mode := opts["mode"]
+		if mode == "" {
+			mode = "websocket"
+		}
+		sb.WriteString("    plugin: v2ray-plugin\n")
+		sb.WriteString("    plugin-opts:\n")
+		sb.WriteString("      mode: " + yamlScalar(mode) + "\n")
+		if _, ok := opts["tls"]; ok {
+			sb.WriteString("      tls: true\n")
+		}
+		if host := opts["host"]; host != "" {
+			sb.WriteString("      host: " + yamlScalar(host) + "\n")
+		}
+		if path := opts["path"]; path != "" {
+			sb.WriteString("      path: " + yamlScalar(path) + "\n")
+		}
+	}
+	return sb.String()
+}
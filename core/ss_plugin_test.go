@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSSObfsNoneSuppressesPlugin tests that obfs=none drops the plugin
+// block from Clash output even when other plugin fields are present
+func TestSSObfsNoneSuppressesPlugin(t *testing.T) {
+	parser := NewProtocolParser()
+
+	obfs, err := parser.ParseConfig("ss://aes-256-gcm:pass@obfs.example.com:8388?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dcdn.example.com#Obfs", "test")
+	if err != nil {
+		t.Fatalf("Failed to parse ss link: %v", err)
+	}
+	sub, err := NewSubscriptionGenerator("clash").Generate([]*Config{obfs})
+	if err != nil {
+		t.Fatalf("Failed to generate Clash: %v", err)
+	}
+	if !strings.Contains(sub, "    plugin: obfs\n    plugin-opts:\n      mode: http\n      host: cdn.example.com\n") {
+		t.Errorf("Expected an obfs plugin block, got:\n%s", sub)
+	}
+
+	for _, link := range []string{
+		"ss://aes-256-gcm:pass@none.example.com:8388?plugin=obfs-local%3Bobfs%3Dnone%3Bobfs-host%3Dcdn.example.com#None",
+		"ss://aes-256-gcm:pass@none.example.com:8388?obfs=none&plugin=obfs-local%3Bobfs%3Dhttp#NoneParam",
+	} {
+		cfg, err := parser.ParseConfig(link, "test")
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", link, err)
+		}
+		if cfg.Plugin != "" {
+			t.Errorf("Expected obfs=none to clear the plugin, got %q", cfg.Plugin)
+		}
+
+		sub, err := NewSubscriptionGenerator("clash").Generate([]*Config{cfg})
+		if err != nil {
+			t.Fatalf("Failed to generate Clash: %v", err)
+		}
+		if strings.Contains(sub, "plugin") || strings.Contains(sub, "obfs") {
+			t.Errorf("Expected no plugin or obfs fields for %s, got:\n%s", cfg.Name, sub)
+		}
+		if strings.Contains(cfg.String(), "plugin=") {
+			t.Errorf("Expected no plugin in the share link, got %s", cfg.String())
+		}
+	}
+}
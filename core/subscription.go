@@ -2,42 +2,318 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 )
 
+// Clash proxy group types and load-balance strategies
+const (
+	GroupTypeSelect      = "select"
+	GroupTypeLoadBalance = "load-balance"
+
+	LoadBalanceRoundRobin        = "round-robin"
+	LoadBalanceConsistentHashing = "consistent-hashing"
+)
+
+// formatFields whitelists the optional fields each format's generator
+// emits. Connection essentials (server, port, credentials) are always
+// written; anything else a format does not understand is left out so strict
+// client parsers never see it. Formats without an entry emit everything.
+var formatFields = map[string]map[string]bool{
+	"clash": {
+		"alpn":      true,
+		"grpc-mode": true,
+		"obfs":      true,
+		"plugin":    true,
+		"trojan-ss": true,
+	},
+	"clash-meta": {
+		"alpn":            true,
+		"flow":            true,
+		"grpc-mode":       true,
+		"obfs":            true,
+		"packet-encoding": true,
+		"plugin":          true,
+		"trojan-ss":       true,
+	},
+	"singbox": {
+		"alpn":            true,
+		"flow":            true,
+		"insecure":        true,
+		"packet-encoding": true,
+		"pin":             true,
+		"plugin":          true,
+		"utls":            true,
+	},
+}
+
+// outputFormats lists the formats a SubscriptionGenerator can produce.
+// clash is the classic Clash dialect; clash-meta adds the protocols only
+// Clash.Meta (mihomo) understands, such as VLESS and REALITY.
+var outputFormats = []string{"clash", "clash-meta", "singbox", "v2ray", "raw", "base64", "uri-json", "template"}
+
+// healthCheckURL is probed by Clash to keep load-balance members healthy
+const healthCheckURL = "http://www.gstatic.com/generate_204"
+
 // SubscriptionGenerator handles converting configs to various subscription formats
 type SubscriptionGenerator struct {
-	format string
+	format          string
+	trailingNewline bool
+	base64          bool
+	updateInterval  time.Duration
+	scorer          ConfigScorer
+	outputPolicy    string
+	frontID         string
+	groupType       string
+	groupStrategy   string
+	groupBy         string
+	vmessNameMax    int
+	template        *template.Template
 }
 
 // NewSubscriptionGenerator creates a new subscription generator
 func NewSubscriptionGenerator(format string) *SubscriptionGenerator {
 	return &SubscriptionGenerator{
-		format: format,
+		format:          format,
+		trailingNewline: true,
+		outputPolicy:    OutputPolicyLax,
+		groupType:       GroupTypeSelect,
+		groupBy:         GroupByNone,
 	}
 }
 
+// SetGroupType sets the type of the Clash "All" group. strategy is the
+// load-balance strategy and is ignored for other group types.
+func (sg *SubscriptionGenerator) SetGroupType(groupType, strategy string) {
+	sg.groupType = groupType
+	sg.groupStrategy = strategy
+}
+
+// SetGroupBy adds Clash select groups by the given layout alongside the
+// "All" group; GroupByRegion adds one group per region with nodes
+func (sg *SubscriptionGenerator) SetGroupBy(groupBy string) {
+	sg.groupBy = groupBy
+}
+
+// SetTemplate sets the template rendered by the template format
+func (sg *SubscriptionGenerator) SetTemplate(tmpl *template.Template) {
+	sg.template = tmpl
+}
+
+// emits reports whether the output format carries the optional field
+func (sg *SubscriptionGenerator) emits(field string) bool {
+	fields, ok := formatFields[sg.format]
+	return !ok || fields[field]
+}
+
+// parseGroupType validates -group-type and -lb-strategy values
+func parseGroupType(groupType, strategy string) (string, string, error) {
+	switch groupType {
+	case GroupTypeSelect:
+		return groupType, "", nil
+	case GroupTypeLoadBalance:
+	default:
+		return "", "", fmt.Errorf("unknown group type %q (expected %s or %s)", groupType, GroupTypeSelect, GroupTypeLoadBalance)
+	}
+
+	if strategy != LoadBalanceRoundRobin && strategy != LoadBalanceConsistentHashing {
+		return "", "", fmt.Errorf("unknown load-balance strategy %q (expected %s or %s)", strategy, LoadBalanceRoundRobin, LoadBalanceConsistentHashing)
+	}
+	return groupType, strategy, nil
+}
+
+// SetOutputPolicy sets the completeness policy configs must satisfy to be
+// emitted (strict or lax)
+func (sg *SubscriptionGenerator) SetOutputPolicy(policy string) {
+	sg.outputPolicy = policy
+}
+
+// SetTrailingNewline sets whether generated output ends with a newline
+func (sg *SubscriptionGenerator) SetTrailingNewline(enabled bool) {
+	sg.trailingNewline = enabled
+}
+
+// SetBase64 sets whether Clash output is base64-encoded as a whole, for
+// managed clients that expect an encoded subscription. Other formats are
+// unaffected; the base64 format already encodes the share link list.
+func (sg *SubscriptionGenerator) SetBase64(enabled bool) {
+	sg.base64 = enabled
+}
+
+// SetUpdateInterval sets how often clients should refresh the subscription.
+// Clash output carries it as a Profile-Update-Interval hint; zero omits it.
+func (sg *SubscriptionGenerator) SetUpdateInterval(interval time.Duration) {
+	sg.updateInterval = interval
+}
+
+// profileUpdateHours converts an update interval to the whole hours that
+// Profile-Update-Interval takes, rounding up so the hint is never zero
+func profileUpdateHours(interval time.Duration) int {
+	return int((interval + time.Hour - 1) / time.Hour)
+}
+
+// wrapsBase64 reports whether output is base64-encoded after rendering
+func (sg *SubscriptionGenerator) wrapsBase64() bool {
+	return sg.base64 && (sg.format == "clash" || sg.format == "clash-meta")
+}
+
+// SetVMessNameLimit caps the byte length of names in VMess share links,
+// since long names push the base64 payload past what some clients accept.
+// Zero leaves names untouched.
+func (sg *SubscriptionGenerator) SetVMessNameLimit(max int) {
+	sg.vmessNameMax = max
+}
+
+// shareLink returns the config's share URI with the VMess name limit applied
+func (sg *SubscriptionGenerator) shareLink(cfg *Config) string {
+	if cfg.Protocol == "vmess" {
+		return cfg.vmessLink(sg.vmessNameMax)
+	}
+	return cfg.String()
+}
+
+// SetScorer sets the scorer used to order configs; nil keeps input order
+func (sg *SubscriptionGenerator) SetScorer(scorer ConfigScorer) {
+	sg.scorer = scorer
+}
+
+// SetFront designates the config, by ID, that other Sing-box outbounds
+// chain through via detour
+func (sg *SubscriptionGenerator) SetFront(id string) {
+	sg.frontID = id
+}
+
 // Generate creates a subscription from configs
 func (sg *SubscriptionGenerator) Generate(configs []*Config) (string, error) {
+	var sb strings.Builder
+	if err := sg.GenerateTo(&sb, configs); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// GenerateTo writes a subscription from configs to w. Raw output is streamed
+// link by link; structured formats are rendered in memory first.
+func (sg *SubscriptionGenerator) GenerateTo(w io.Writer, configs []*Config) error {
+	var output string
+	var err error
+
+	configs = sg.Select(configs)
+
 	switch sg.format {
-	case "clash":
-		return sg.generateClash(configs)
+	case "clash", "clash-meta":
+		output, err = sg.generateClash(configs)
 	case "singbox":
-		return sg.generateSingbox(configs)
+		output, err = sg.generateSingbox(configs)
 	case "v2ray":
-		return sg.generateV2Ray()
+		output = sg.generateV2Ray(configs)
 	case "raw":
-		return sg.generateRaw(configs)
+		return sg.writeRaw(w, configs)
+	case "base64":
+		output = sg.generateBase64(configs)
+	case "uri-json":
+		output, err = sg.generateURIJSON(configs)
+	case "template":
+		output, err = sg.generateTemplate(configs)
 	default:
-		return "", fmt.Errorf("unsupported format: %s", sg.format)
+		return fmt.Errorf("unsupported format: %s", sg.format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if sg.wrapsBase64() {
+		// Encode the YAML exactly as it would be written unwrapped
+		output = EncodeBase64(sg.finalizeOutput(output))
+	}
+
+	_, err = io.WriteString(w, sg.finalizeOutput(output))
+	return err
+}
+
+// Select applies the output policy and ranking, the steps of GenerateTo that
+// do not depend on the format. Formats generated from one Select result
+// carry the same configs in the same order, less those a format skips.
+func (sg *SubscriptionGenerator) Select(configs []*Config) []*Config {
+	configs = completeConfigs(configs, sg.outputPolicy)
+	if sg.scorer != nil {
+		configs = rankConfigs(configs, sg.scorer)
+	}
+	return configs
+}
+
+// Unsupported returns why the format skips cfg, or an empty string if it is
+// written
+func (sg *SubscriptionGenerator) Unsupported(cfg *Config) string {
+	switch sg.format {
+	case "clash", "clash-meta":
+		return clashUnsupported(cfg, sg.format)
+	case "singbox":
+		return singboxUnsupported(cfg)
+	}
+	return ""
+}
+
+// pruneUnsupported drops configs that any of formats would skip, so every
+// format is generated from the same nodes
+func pruneUnsupported(configs []*Config, formats []string) []*Config {
+	generators := make([]*SubscriptionGenerator, len(formats))
+	for i, format := range formats {
+		generators[i] = NewSubscriptionGenerator(format)
+	}
+
+	kept := make([]*Config, 0, len(configs))
+	for _, cfg := range configs {
+		reason := ""
+		for _, gen := range generators {
+			if reason = gen.Unsupported(cfg); reason != "" {
+				log.Printf("Pruning %s from every format: %s\n", cfg.Name, reason)
+				break
+			}
+		}
+		if reason == "" {
+			kept = append(kept, cfg)
+		}
 	}
+	return kept
+}
+
+// finalizeOutput applies the trailing newline policy so every format ends
+// the same way: exactly one newline when enabled, none otherwise
+func (sg *SubscriptionGenerator) finalizeOutput(output string) string {
+	output = strings.TrimRight(output, "\n")
+	if sg.trailingNewline && output != "" {
+		output += "\n"
+	}
+	return output
 }
 
 // generateClash creates a Clash subscription format
 func (sg *SubscriptionGenerator) generateClash(configs []*Config) (string, error) {
 	var sb strings.Builder
 
+	supported := make([]*Config, 0, len(configs))
+	for _, cfg := range configs {
+		if reason := clashUnsupported(cfg, sg.format); reason != "" {
+			log.Printf("Skipping %s for Clash: %s\n", cfg.Name, reason)
+			continue
+		}
+		supported = append(supported, cfg)
+	}
+	configs = supported
+
+	if sg.updateInterval > 0 {
+		sb.WriteString(fmt.Sprintf("# Profile-Update-Interval: %d\n", profileUpdateHours(sg.updateInterval)))
+	}
 	sb.WriteString("proxies:\n")
 
 	for i, cfg := range configs {
@@ -45,75 +321,153 @@ func (sg *SubscriptionGenerator) generateClash(configs []*Config) (string, error
 			sb.WriteString("\n")
 		}
 
-		sb.WriteString("  - name: " + cfg.Name + "\n")
+		sb.WriteString("  - name: " + yamlScalar(cfg.Name) + "\n")
 		sb.WriteString("    type: " + sg.mapProtocol(cfg.Protocol) + "\n")
-		sb.WriteString("    server: " + cfg.Server + "\n")
+		sb.WriteString("    server: " + yamlScalar(cfg.Server) + "\n")
 		sb.WriteString(fmt.Sprintf("    port: %d\n", cfg.Port))
 
 		// Protocol-specific fields
 		switch cfg.Protocol {
 		case "vless":
 			if cfg.UUID != "" {
-				sb.WriteString("    uuid: " + cfg.UUID + "\n")
+				sb.WriteString("    uuid: " + yamlScalar(cfg.UUID) + "\n")
 			}
-			if cfg.Flow != "" {
-				sb.WriteString("    flow: " + cfg.Flow + "\n")
+			if flow := sg.vlessFlow(cfg); flow != "" && sg.emits("flow") {
+				sb.WriteString("    flow: " + yamlScalar(flow) + "\n")
+			}
+			if encoding := packetEncoding(cfg); encoding != "" && sg.emits("packet-encoding") {
+				sb.WriteString("    packet-encoding: " + encoding + "\n")
 			}
 			if cfg.Security != "" {
-				sb.WriteString("    security: " + cfg.Security + "\n")
+				sb.WriteString("    security: " + yamlScalar(cfg.Security) + "\n")
 			}
 			// REALITY protocol support
 			if cfg.PublicKey != "" {
 				sb.WriteString("    reality-opts:\n")
-				sb.WriteString("      public-key: " + cfg.PublicKey + "\n")
-				sb.WriteString("      short-id: " + cfg.ShortID + "\n")
-				sb.WriteString("      server-name: " + cfg.ServerName + "\n")
+				sb.WriteString("      public-key: " + yamlScalar(cfg.PublicKey) + "\n")
+				sb.WriteString("      short-id: " + yamlScalar(cfg.ShortID) + "\n")
+				sb.WriteString("      server-name: " + yamlScalar(cfg.ServerName) + "\n")
 			}
 			// XHTTP protocol support
 			if cfg.HTTPMethod != "" {
 				sb.WriteString("    http-opts:\n")
-				sb.WriteString("      method: " + cfg.HTTPMethod + "\n")
+				sb.WriteString("      method: " + yamlScalar(cfg.HTTPMethod) + "\n")
 				if cfg.HTTPHost != "" {
-					sb.WriteString("      host: " + cfg.HTTPHost + "\n")
+					sb.WriteString("      host: " + yamlScalar(cfg.HTTPHost) + "\n")
 				}
 				if cfg.HTTPPath != "" {
-					sb.WriteString("      path: " + cfg.HTTPPath + "\n")
+					sb.WriteString("      path: " + yamlScalar(cfg.HTTPPath) + "\n")
 				}
 			}
 			if cfg.ServerName != "" && cfg.PublicKey == "" {
-				sb.WriteString("    sni: " + cfg.ServerName + "\n")
+				sb.WriteString("    sni: " + yamlScalar(cfg.ServerName) + "\n")
 			}
 
 		case "vmess":
 			if cfg.UUID != "" {
-				sb.WriteString("    uuid: " + cfg.UUID + "\n")
-			}
-			if cfg.AlterId > 0 {
-				sb.WriteString(fmt.Sprintf("    alterId: %d\n", cfg.AlterId))
+				sb.WriteString("    uuid: " + yamlScalar(cfg.UUID) + "\n")
 			}
+			// Clash requires alterId, and 0 selects AEAD
+			sb.WriteString(fmt.Sprintf("    alterId: %d\n", cfg.AlterId))
 			if cfg.Cipher != "" {
-				sb.WriteString("    cipher: " + cfg.Cipher + "\n")
+				sb.WriteString("    cipher: " + yamlScalar(cfg.Cipher) + "\n")
+			}
+			if cfg.Security == "tls" {
+				sb.WriteString("    tls: true\n")
+				if cfg.ServerName != "" {
+					sb.WriteString("    servername: " + yamlScalar(cfg.ServerName) + "\n")
+				}
+			}
+			// HTTP header obfuscation (headerType http)
+			if cfg.HTTPMethod != "" {
+				sb.WriteString("    network: http\n")
+				sb.WriteString("    http-opts:\n")
+				sb.WriteString("      method: " + yamlScalar(cfg.HTTPMethod) + "\n")
+				if cfg.HTTPPath != "" {
+					sb.WriteString("      path:\n")
+					sb.WriteString("        - " + yamlScalar(cfg.HTTPPath) + "\n")
+				}
+				if cfg.HTTPHost != "" {
+					sb.WriteString("      headers:\n")
+					sb.WriteString("        Host:\n")
+					sb.WriteString("          - " + yamlScalar(cfg.HTTPHost) + "\n")
+				}
 			}
 
 		case "trojan":
 			if cfg.Password != "" {
-				sb.WriteString("    password: " + cfg.Password + "\n")
+				sb.WriteString("    password: " + yamlScalar(cfg.Password) + "\n")
 			}
 			if cfg.TLSServerName != "" {
-				sb.WriteString("    sni: " + cfg.TLSServerName + "\n")
+				sb.WriteString("    sni: " + yamlScalar(cfg.TLSServerName) + "\n")
+			}
+			// Trojan-Go shadowsocks layer
+			if cfg.TrojanSSMethod != "" && sg.emits("trojan-ss") {
+				sb.WriteString("    ss-opts:\n")
+				sb.WriteString("      enabled: true\n")
+				sb.WriteString("      method: " + yamlScalar(cfg.TrojanSSMethod) + "\n")
+				sb.WriteString("      password: " + yamlScalar(cfg.TrojanSSPassword) + "\n")
 			}
 
 		case "ss", "shadowsocks":
 			if cfg.Password != "" {
-				sb.WriteString("    password: " + cfg.Password + "\n")
+				sb.WriteString("    password: " + yamlScalar(cfg.Password) + "\n")
 			}
 			if cfg.Method != "" {
-				sb.WriteString("    cipher: " + cfg.Method + "\n")
+				sb.WriteString("    cipher: " + yamlScalar(cfg.Method) + "\n")
+			}
+			if cfg.Plugin != "" && sg.emits("plugin") {
+				sb.WriteString(clashSSPlugin(cfg.Plugin))
+			}
+		}
+
+		// WebSocket transport
+		if cfg.TransportType == "ws" {
+			sb.WriteString("    network: ws\n")
+			sb.WriteString("    ws-opts:\n")
+			if cfg.HTTPPath != "" {
+				sb.WriteString("      path: " + yamlScalar(cfg.HTTPPath) + "\n")
+			}
+			if cfg.HTTPHost != "" {
+				sb.WriteString("      headers:\n")
+				sb.WriteString("        Host: " + yamlScalar(cfg.HTTPHost) + "\n")
+			}
+		}
+
+		// HTTP/2 transport
+		if cfg.TransportType == "h2" {
+			sb.WriteString("    network: h2\n")
+			sb.WriteString("    h2-opts:\n")
+			if cfg.HTTPHost != "" {
+				sb.WriteString("      host:\n")
+				sb.WriteString("        - " + yamlScalar(cfg.HTTPHost) + "\n")
+			}
+			if cfg.HTTPPath != "" {
+				sb.WriteString("      path: " + yamlScalar(cfg.HTTPPath) + "\n")
+			}
+		}
+
+		// gRPC transport
+		if cfg.TransportType == "grpc" {
+			sb.WriteString("    network: grpc\n")
+			sb.WriteString("    grpc-opts:\n")
+			sb.WriteString("      grpc-service-name: " + yamlScalar(cfg.GRPCServiceName) + "\n")
+			if cfg.GRPCMode != "" && sg.emits("grpc-mode") {
+				sb.WriteString("      _grpc-type: " + yamlScalar(cfg.GRPCMode) + "\n")
 			}
 		}
 
 		// Common fields
-		if cfg.Obfuscation {
+		if alpn := tlsALPN(cfg); len(alpn) > 0 && sg.emits("alpn") {
+			sb.WriteString("    alpn:\n")
+			for _, proto := range alpn {
+				sb.WriteString("      - " + yamlScalar(proto) + "\n")
+			}
+		}
+		if cfg.PinnedCertSHA256 != "" && sg.emits("pin") {
+			sb.WriteString("    fingerprint: " + yamlScalar(cfg.PinnedCertSHA256) + "\n")
+		}
+		if cfg.Obfuscation && sg.emits("obfs") {
 			sb.WriteString("    obfs: http\n")
 		}
 
@@ -123,11 +477,31 @@ func (sg *SubscriptionGenerator) generateClash(configs []*Config) (string, error
 	// Add proxy groups
 	sb.WriteString("\nproxy-groups:\n")
 	sb.WriteString("  - name: \"All\"\n")
-	sb.WriteString("    type: select\n")
+	sb.WriteString("    type: " + sg.groupType + "\n")
+	if sg.groupType == GroupTypeLoadBalance {
+		sb.WriteString("    strategy: " + sg.groupStrategy + "\n")
+		sb.WriteString("    url: " + healthCheckURL + "\n")
+		sb.WriteString("    interval: 300\n")
+	}
 	sb.WriteString("    proxies:\n")
 
 	for _, cfg := range configs {
-		sb.WriteString("      - " + cfg.Name + "\n")
+		sb.WriteString("      - " + yamlScalar(cfg.Name) + "\n")
+	}
+
+	if sg.groupBy == GroupByRegion {
+		groups := groupByRegion(configs)
+		for _, region := range regionOrder {
+			if len(groups[region]) == 0 {
+				continue
+			}
+			sb.WriteString("  - name: \"" + region + "\"\n")
+			sb.WriteString("    type: " + GroupTypeSelect + "\n")
+			sb.WriteString("    proxies:\n")
+			for _, name := range groups[region] {
+				sb.WriteString("      - " + yamlScalar(name) + "\n")
+			}
+		}
 	}
 
 	// Add rules (Iran-optimized)
@@ -139,139 +513,500 @@ func (sg *SubscriptionGenerator) generateClash(configs []*Config) (string, error
 	return sb.String(), nil
 }
 
+// clashUnsupported returns why the given Clash format cannot load cfg, or an
+// empty string if it can. Classic Clash rejects the whole file on an unknown
+// proxy type, so those are only written for clash-meta.
+func clashUnsupported(cfg *Config, format string) string {
+	if reason := outputPending(cfg); reason != "" {
+		return reason
+	}
+	if cfg.TransportType == "kcp" {
+		return "Clash.Meta has no mKCP transport"
+	}
+	if format != "clash" {
+		return ""
+	}
+	if cfg.PublicKey != "" {
+		return "REALITY requires Clash.Meta"
+	}
+	switch cfg.Protocol {
+	case "vless", "reality", "xhttp":
+		return "VLESS requires Clash.Meta"
+	}
+	return ""
+}
+
+// parseFormats validates a comma-separated -formats list, dropping repeats
+func parseFormats(spec string) ([]string, error) {
+	var formats []string
+	for _, format := range strings.Split(spec, ",") {
+		format = strings.TrimSpace(format)
+		if format == "" || slices.Contains(formats, format) {
+			continue
+		}
+		if !slices.Contains(outputFormats, format) {
+			return nil, fmt.Errorf("unknown format %q (expected one of %s)", format, strings.Join(outputFormats, ", "))
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// formatOutputPath derives the output file of one format in a multi-format
+// run by inserting the format before the extension, e.g. main-clash-meta.txt
+func formatOutputPath(output, format string) string {
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + "-" + format + ext
+}
+
+// yamlScalar renders s as a YAML scalar. Names, passwords, hosts and other
+// values from links are written plain when YAML reads them back unchanged and
+// double-quoted otherwise, e.g. "Node #2" (which would lose " #2" as a
+// comment), "a: b" or a numeric short-id. Quoting escapes only
+// backslashes, quotes and control characters, so emoji and RTL text stay
+// byte-for-byte.
+func yamlScalar(s string) string {
+	if yamlPlainSafe(s) {
+		return s
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&sb, `\x%02x`, r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// yamlPlainSafe reports whether s reads back as the same string when
+// written as a plain YAML scalar
+func yamlPlainSafe(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) {
+		return false
+	}
+	if strings.ContainsRune("-?:,[]{}#&*!|>'\"%@`", rune(s[0])) {
+		return false
+	}
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+
+	// Scalars that resolve to booleans, null or numbers
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null", "~", ".inf", "-.inf", ".nan":
+		return false
+	}
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return false
+	}
+
+	return true
+}
+
+// generateTemplate renders the user-supplied template with the configs
+func (sg *SubscriptionGenerator) generateTemplate(configs []*Config) (string, error) {
+	if sg.template == nil {
+		return "", fmt.Errorf("template format requires a template file")
+	}
+
+	// Bind link to this generator so it honors the VMess name limit
+	tmpl, err := sg.template.Clone()
+	if err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	tmpl.Funcs(template.FuncMap{"link": sg.shareLink})
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, configs); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+
+	return sb.String(), nil
+}
+
 // generateSingbox creates a Sing-box subscription format
 func (sg *SubscriptionGenerator) generateSingbox(configs []*Config) (string, error) {
+	supported := make([]*Config, 0, len(configs))
+	for _, cfg := range configs {
+		if reason := singboxUnsupported(cfg); reason != "" {
+			log.Printf("Skipping %s for Sing-box: %s\n", cfg.Name, reason)
+			continue
+		}
+		supported = append(supported, cfg)
+	}
+	configs = supported
+
+	front := sg.findFront(configs)
+
+	doc := singboxConfig{Outbounds: make([]singboxOutbound, 0, len(configs))}
+	for _, cfg := range configs {
+		detour := ""
+		if front != nil && cfg != front {
+			detour = front.Name
+		}
+		doc.Outbounds = append(doc.Outbounds, sg.configToSingboxOutbound(cfg, detour))
+	}
+
+	// Names often contain &, < or >, which need no escaping in a config file
 	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return "", fmt.Errorf("failed to encode Sing-box config: %w", err)
+	}
 
-	sb.WriteString("{\"outbounds\":[")
+	return sb.String(), nil
+}
 
-	for i, cfg := range configs {
-		if i > 0 {
-			sb.WriteString(",")
+// singboxUnsupported returns why Sing-box cannot represent cfg, or an
+// empty string if it can
+func singboxUnsupported(cfg *Config) string {
+	if cfg.TrojanSSMethod != "" {
+		return "trojan has no Trojan-Go shadowsocks layer"
+	}
+	if reason := outputPending(cfg); reason != "" {
+		return reason
+	}
+	if cfg.TransportType == "kcp" {
+		return "Sing-box has no mKCP transport"
+	}
+	return ""
+}
+
+// outputPending returns why Clash and Sing-box output skip cfg for a
+// protocol that is parsed but not yet generated, or an empty string
+func outputPending(cfg *Config) string {
+	switch cfg.Protocol {
+	case "hysteria2":
+		return "Hysteria2 output is not supported yet"
+	case "tuic":
+		return "TUIC output is not supported yet"
+	case "ssr":
+		return "ShadowsocksR output is not supported yet"
+	}
+	return ""
+}
+
+// findFront returns the designated front config, or nil if none is set or
+// it is not among the generated configs
+func (sg *SubscriptionGenerator) findFront(configs []*Config) *Config {
+	if sg.frontID == "" {
+		return nil
+	}
+
+	for _, cfg := range configs {
+		if cfg.ID == sg.frontID {
+			return cfg
 		}
+	}
+
+	log.Printf("Warning: front config %s not found, outbounds will not be chained\n", sg.frontID)
+	return nil
+}
 
-		outbound := sg.configToSingboxOutbound(cfg)
-		sb.WriteString(outbound)
+// packetEncoding returns the UDP packet encoding of a VLESS config as Clash.Meta
+// and Sing-box name it: xudp, or packetaddr for Xray's "packet". Unknown
+// values are dropped so clients fall back to their default.
+func packetEncoding(cfg *Config) string {
+	switch strings.ToLower(cfg.PacketEncoding) {
+	case "xudp":
+		return "xudp"
+	case "packet", "packetaddr":
+		return "packetaddr"
+	default:
+		return ""
 	}
+}
 
-	sb.WriteString("]}")
+// defaultRealityFingerprint is the uTLS fingerprint for REALITY links
+// without an fp param
+const defaultRealityFingerprint = "chrome"
 
-	return sb.String(), nil
+// realityFingerprint returns the uTLS fingerprint of a REALITY config, from
+// the link's fp param
+func realityFingerprint(cfg *Config) string {
+	if fp := cfg.GetMeta("param.fp"); fp != "" {
+		return fp
+	}
+	return defaultRealityFingerprint
 }
 
-func (sg *SubscriptionGenerator) configToSingboxOutbound(cfg *Config) string {
-	var sb strings.Builder
+// tlsALPN returns the ALPN list a TLS config offers. An explicit list is
+// kept, with h2 added for the h2 transport which cannot negotiate without
+// it; otherwise the transport picks the default: h2 for h2 and gRPC,
+// http/1.1 for WebSocket and both for plain TCP. Non-TLS configs get none.
+func tlsALPN(cfg *Config) []string {
+	if !usesTLS(cfg) {
+		return nil
+	}
 
-	sb.WriteString("{")
-	sb.WriteString(fmt.Sprintf(`"type":"%s",`, sg.mapProtocol(cfg.Protocol)))
-	sb.WriteString(fmt.Sprintf(`"tag":"%s",`, cfg.Name))
-	sb.WriteString(fmt.Sprintf(`"server":"%s",`, cfg.Server))
-	sb.WriteString(fmt.Sprintf(`"server_port":%d`, cfg.Port))
+	if cfg.ALPN != "" {
+		alpn := strings.Split(cfg.ALPN, ",")
+		if cfg.TransportType == "h2" && !slices.Contains(alpn, "h2") {
+			alpn = append([]string{"h2"}, alpn...)
+		}
+		return alpn
+	}
+
+	switch cfg.TransportType {
+	case "h2", "grpc":
+		return []string{"h2"}
+	case "ws":
+		return []string{"http/1.1"}
+	default:
+		return []string{"h2", "http/1.1"}
+	}
+}
+
+// singboxConfig is the Sing-box document: the outbound list
+type singboxConfig struct {
+	Outbounds []singboxOutbound `json:"outbounds"`
+}
+
+// singboxOutbound is one Sing-box outbound. Fields are declared in the order
+// they are written, and only those a protocol uses are set.
+type singboxOutbound struct {
+	Type           string            `json:"type"`
+	Tag            string            `json:"tag"`
+	Server         string            `json:"server"`
+	ServerPort     int               `json:"server_port"`
+	UUID           string            `json:"uuid,omitempty"`
+	Flow           string            `json:"flow,omitempty"`
+	PacketEncoding string            `json:"packet_encoding,omitempty"`
+	AlterID        int               `json:"alter_id,omitempty"`
+	Security       string            `json:"security,omitempty"` // VMess cipher
+	Password       string            `json:"password,omitempty"`
+	Method         string            `json:"method,omitempty"`
+	Plugin         string            `json:"plugin,omitempty"`
+	PluginOpts     string            `json:"plugin_opts,omitempty"`
+	TLS            *singboxTLS       `json:"tls,omitempty"`
+	Transport      *singboxTransport `json:"transport,omitempty"`
+	Detour         string            `json:"detour,omitempty"`
+}
+
+// singboxTLS is an outbound's TLS block, including REALITY
+type singboxTLS struct {
+	Enabled                    bool            `json:"enabled"`
+	ServerName                 string          `json:"server_name,omitempty"`
+	Insecure                   bool            `json:"insecure,omitempty"`
+	UTLS                       *singboxUTLS    `json:"utls,omitempty"`
+	Reality                    *singboxReality `json:"reality,omitempty"`
+	CertificatePublicKeySHA256 []string        `json:"certificate_public_key_sha256,omitempty"`
+	ALPN                       []string        `json:"alpn,omitempty"`
+}
+
+// singboxUTLS selects the uTLS fingerprint
+type singboxUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// singboxReality holds the REALITY client settings
+type singboxReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id"`
+}
+
+// singboxTransport is an outbound's V2Ray transport: http, ws or grpc
+type singboxTransport struct {
+	Type        string            `json:"type"`
+	Method      string            `json:"method,omitempty"`
+	Host        []string          `json:"host,omitempty"`
+	Path        string            `json:"path,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"`
+}
+
+// singboxTLSBlock returns the TLS block for serverName with the optional
+// pinned certificate hash and ALPN list
+func (sg *SubscriptionGenerator) singboxTLSBlock(cfg *Config, serverName string) *singboxTLS {
+	tls := &singboxTLS{Enabled: true, ServerName: serverName}
+	if cfg.PinnedCertSHA256 != "" && sg.emits("pin") {
+		tls.CertificatePublicKeySHA256 = []string{cfg.PinnedCertSHA256}
+	}
+	if alpn := tlsALPN(cfg); len(alpn) > 0 && sg.emits("alpn") {
+		tls.ALPN = alpn
+	}
+	return tls
+}
+
+// singboxHosts returns the host list of an HTTP transport
+func singboxHosts(host string) []string {
+	if host == "" {
+		return nil
+	}
+	return []string{host}
+}
+
+// configToSingboxOutbound builds one outbound. A non-empty detour chains
+// the outbound through the outbound with that tag.
+func (sg *SubscriptionGenerator) configToSingboxOutbound(cfg *Config, detour string) singboxOutbound {
+	out := singboxOutbound{
+		Type:       sg.singboxProtocol(cfg.Protocol),
+		Tag:        cfg.Name,
+		Server:     cfg.Server,
+		ServerPort: cfg.Port,
+		Detour:     detour,
+	}
 
 	// Protocol-specific configuration
 	switch cfg.Protocol {
 	case "vless":
-		if cfg.UUID != "" {
-			sb.WriteString(fmt.Sprintf(`,uuid:"%s"`, cfg.UUID))
-		}
-		if cfg.Flow != "" {
-			sb.WriteString(fmt.Sprintf(`,flow:"%s"`, cfg.Flow))
+		// Sing-box's VLESS outbound has no security or encryption field;
+		// TLS and REALITY go in the tls block
+		out.UUID = cfg.UUID
+		if sg.emits("flow") {
+			out.Flow = sg.vlessFlow(cfg)
 		}
-		if cfg.Security != "" {
-			sb.WriteString(fmt.Sprintf(`,encryption:"%s"`, cfg.Security))
+		if sg.emits("packet-encoding") {
+			out.PacketEncoding = packetEncoding(cfg)
 		}
 
-		// REALITY protocol support (native in Sing-box)
+		// REALITY protocol support (native in Sing-box, which refuses a
+		// REALITY client without uTLS)
 		if cfg.PublicKey != "" {
-			sb.WriteString(`,"tls":{"enabled":true,"server_name":"`)
-			sb.WriteString(cfg.ServerName)
-			sb.WriteString(`"`)
-			if cfg.PublicKey != "" {
-				sb.WriteString(`,"reality":{"enabled":true,"public_key":"`)
-				sb.WriteString(cfg.PublicKey)
-				sb.WriteString(`","short_id":"`)
-				sb.WriteString(cfg.ShortID)
-				sb.WriteString(`"}`)
+			out.TLS = sg.singboxTLSBlock(cfg, cfg.ServerName)
+			if sg.emits("utls") {
+				out.TLS.UTLS = &singboxUTLS{Enabled: true, Fingerprint: realityFingerprint(cfg)}
 			}
-			sb.WriteString("}")
-		} else if cfg.ServerName != "" {
-			sb.WriteString(`,"tls":{"enabled":true,"server_name":"`)
-			sb.WriteString(cfg.ServerName)
-			sb.WriteString(`"}`)
+			out.TLS.Reality = &singboxReality{Enabled: true, PublicKey: cfg.PublicKey, ShortID: cfg.ShortID}
+		} else if cfg.Security == "tls" || cfg.ServerName != "" || cfg.PinnedCertSHA256 != "" {
+			out.TLS = sg.singboxTLSBlock(cfg, cfg.ServerName)
 		}
 
-		// XHTTP protocol support
+		// XHTTP runs over Sing-box's HTTP transport
 		if cfg.HTTPMethod != "" {
-			sb.WriteString(fmt.Sprintf(`,"http":{"method":"%s"`, cfg.HTTPMethod))
-			if cfg.HTTPHost != "" {
-				sb.WriteString(fmt.Sprintf(`,"host":"%s"`, cfg.HTTPHost))
-			}
-			if cfg.HTTPPath != "" {
-				sb.WriteString(fmt.Sprintf(`,"path":"%s"`, cfg.HTTPPath))
-			}
-			sb.WriteString("}")
+			out.Transport = &singboxTransport{Type: "http", Method: cfg.HTTPMethod, Host: singboxHosts(cfg.HTTPHost), Path: cfg.HTTPPath}
 		}
 
 	case "vmess":
-		if cfg.UUID != "" {
-			sb.WriteString(fmt.Sprintf(`,uuid:"%s"`, cfg.UUID))
-		}
-		if cfg.AlterId > 0 {
-			sb.WriteString(fmt.Sprintf(`,alter_id:%d`, cfg.AlterId))
+		out.UUID = cfg.UUID
+		out.AlterID = cfg.AlterId
+		out.Security = cfg.Cipher
+		if cfg.Security == "tls" {
+			out.TLS = sg.singboxTLSBlock(cfg, cfg.ServerName)
 		}
-		if cfg.Cipher != "" {
-			sb.WriteString(fmt.Sprintf(`,cipher:"%s"`, cfg.Cipher))
+
+		// HTTP header obfuscation (headerType http)
+		if cfg.HTTPMethod != "" {
+			out.Transport = &singboxTransport{Type: "http", Method: cfg.HTTPMethod, Host: singboxHosts(cfg.HTTPHost), Path: cfg.HTTPPath}
 		}
 
 	case "trojan":
-		if cfg.Password != "" {
-			sb.WriteString(fmt.Sprintf(`,password:"%s"`, cfg.Password))
+		out.Password = cfg.Password
+		if cfg.TLSServerName != "" || cfg.PinnedCertSHA256 != "" {
+			out.TLS = sg.singboxTLSBlock(cfg, cfg.TLSServerName)
 		}
-		if cfg.TLSServerName != "" {
-			sb.WriteString(`,"tls":{"enabled":true,"server_name":"`)
-			sb.WriteString(cfg.TLSServerName)
-			sb.WriteString(`"}`)
-		}
-		if cfg.AllowInsecure {
-			sb.WriteString(`,"tls":{"insecure":true}`)
+		if cfg.AllowInsecure && sg.emits("insecure") {
+			if out.TLS == nil {
+				out.TLS = &singboxTLS{Enabled: true}
+			}
+			out.TLS.Insecure = true
 		}
 
 	case "ss", "shadowsocks":
-		if cfg.Password != "" {
-			sb.WriteString(fmt.Sprintf(`,password:"%s"`, cfg.Password))
+		out.Password = cfg.Password
+		out.Method = cfg.Method
+		if sg.emits("plugin") {
+			out.Plugin, out.PluginOpts, _ = strings.Cut(cfg.Plugin, ";")
 		}
-		if cfg.Method != "" {
-			sb.WriteString(fmt.Sprintf(`,method:"%s"`, cfg.Method))
+	}
+
+	// WebSocket transport, with the Host header for CDN fronting
+	if cfg.TransportType == "ws" {
+		out.Transport = &singboxTransport{Type: "ws", Path: cfg.HTTPPath}
+		if cfg.HTTPHost != "" {
+			out.Transport.Headers = map[string]string{"Host": cfg.HTTPHost}
 		}
 	}
 
-	sb.WriteString("}")
+	// HTTP/2 transport; Sing-box's http transport runs over h2 with TLS
+	if cfg.TransportType == "h2" {
+		out.Transport = &singboxTransport{Type: "http", Host: singboxHosts(cfg.HTTPHost), Path: cfg.HTTPPath}
+	}
 
-	return sb.String()
-}
+	// gRPC transport; Sing-box has no equivalent of the gun/multi mode
+	if cfg.TransportType == "grpc" {
+		out.Transport = &singboxTransport{Type: "grpc", ServiceName: cfg.GRPCServiceName}
+	}
 
-// generateV2Ray creates a V2Ray config format
-func (sg *SubscriptionGenerator) generateV2Ray() (string, error) {
-	var sb strings.Builder
+	return out
+}
 
-	sb.WriteString("{\"v\":\"2\",\"ps\":\"\",\"add\":\"\",\"port\":\"443\",\"id\":\"\",\"aid\":\"0\",\"net\":\"\",\"type\":\"\",\"host\":\"\",\"path\":\"\",\"tls\":\"\",\"sni\":\"\",\"alpn\":\"\",\"fp\":\"\"}")
+// generateV2Ray creates a V2Ray subscription: one share link per line, a
+// vmess:// JSON link with server, port, UUID, alterId, network and TLS for
+// VMess, and vless://, trojan:// or ss:// URIs for the other protocols
+func (sg *SubscriptionGenerator) generateV2Ray(configs []*Config) string {
+	links := make([]string, 0, len(configs))
+	for _, cfg := range configs {
+		links = append(links, sg.shareLink(cfg))
+	}
+	return strings.Join(links, "\n")
+}
 
-	// Simple implementation - returns base structure
-	// Real implementation would convert full config details
+// generateURIJSON creates a JSON array of the configs' share links, for web
+// frontends that list nodes themselves
+func (sg *SubscriptionGenerator) generateURIJSON(configs []*Config) (string, error) {
+	links := make([]string, 0, len(configs))
+	for _, cfg := range configs {
+		links = append(links, sg.shareLink(cfg))
+	}
 
+	// Keep & in query strings readable rather than \u0026
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(links); err != nil {
+		return "", fmt.Errorf("failed to encode share links: %w", err)
+	}
 	return sb.String(), nil
 }
 
-// generateRaw creates a raw proxy list (one per line in v2ray:// format)
-func (sg *SubscriptionGenerator) generateRaw(configs []*Config) (string, error) {
-	var lines []string
+// writeRaw streams a raw proxy list (one per line in v2ray:// format) to w,
+// applying the trailing newline policy without buffering the whole list
+func (sg *SubscriptionGenerator) writeRaw(w io.Writer, configs []*Config) error {
+	for i, cfg := range configs {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, sg.configToV2RayLink(cfg)); err != nil {
+			return err
+		}
+	}
 
-	for _, cfg := range configs {
-		line := sg.configToV2RayLink(cfg)
-		lines = append(lines, line)
+	if sg.trailingNewline && len(configs) > 0 {
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
 	}
 
-	return strings.Join(lines, "\n"), nil
+	return nil
+}
+
+// generateBase64 creates the standard share link subscription: the V2Ray
+// link list base64-encoded as a whole, as imported by v2rayNG and v2rayN
+func (sg *SubscriptionGenerator) generateBase64(configs []*Config) string {
+	return EncodeBase64(sg.generateV2Ray(configs))
 }
 
 func (sg *SubscriptionGenerator) configToV2RayLink(cfg *Config) string {
@@ -282,6 +1017,26 @@ func (sg *SubscriptionGenerator) configToV2RayLink(cfg *Config) string {
 	return "v2ray://" + encoded
 }
 
+// vlessFlow returns the flow to emit for a VLESS config. Flow control such as
+// xtls-rprx-vision only works on top of TLS or REALITY, so it is suppressed
+// (with a warning) for plain connections.
+func (sg *SubscriptionGenerator) vlessFlow(cfg *Config) string {
+	if cfg.Flow == "" {
+		return ""
+	}
+
+	switch strings.ToLower(cfg.Security) {
+	case "tls", "reality":
+		return cfg.Flow
+	}
+	if cfg.PublicKey != "" {
+		return cfg.Flow
+	}
+
+	log.Printf("Warning: dropping flow %q from %s: flow requires tls or reality security\n", cfg.Flow, cfg.Name)
+	return ""
+}
+
 // mapProtocol maps standard protocol names to format-specific names
 func (sg *SubscriptionGenerator) mapProtocol(proto string) string {
 	switch proto {
@@ -306,6 +1061,17 @@ func (sg *SubscriptionGenerator) mapProtocol(proto string) string {
 	}
 }
 
+// singboxProtocol maps a config protocol to its Sing-box outbound type.
+// Sing-box spells Shadowsocks out and rejects Clash's "ss".
+func (sg *SubscriptionGenerator) singboxProtocol(proto string) string {
+	switch proto {
+	case "ss", "shadowsocks":
+		return "shadowsocks"
+	default:
+		return sg.mapProtocol(proto)
+	}
+}
+
 // EncodeBase64 encodes a subscription to base64
 func EncodeBase64(data string) string {
 	return base64.StdEncoding.EncodeToString([]byte(data))
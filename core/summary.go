@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary holds aggregate statistics about a generated config set
+type Summary struct {
+	Total          int            `json:"total"`
+	ByProtocol     map[string]int `json:"by_protocol"`
+	BySource       map[string]int `json:"by_source"`
+	ByCountry      map[string]int `json:"by_country,omitempty"`
+	Tested         int            `json:"tested,omitempty"`
+	AverageLatency float64        `json:"average_latency_ms,omitempty"`
+	Duplicates     int            `json:"duplicates_removed"`
+	Unsupported    map[string]int `json:"unsupported,omitempty"`  // recognized schemes that were skipped
+	GeneratedAt    string         `json:"generated_at,omitempty"` // RFC 3339, set when written as a stats file
+}
+
+// NewSummary computes a summary from the final config set
+func NewSummary(configs []*Config, duplicates int) *Summary {
+	s := &Summary{
+		Total:      len(configs),
+		ByProtocol: make(map[string]int),
+		BySource:   make(map[string]int),
+		ByCountry:  make(map[string]int),
+		Duplicates: duplicates,
+	}
+
+	totalPing := 0
+	for _, cfg := range configs {
+		s.ByProtocol[cfg.Protocol]++
+		s.BySource[cfg.Source]++
+
+		// Country is only known when geo resolution ran
+		if cfg.Country != "" {
+			s.ByCountry[cfg.Country]++
+		}
+
+		// Ping is only set when latency testing ran
+		if cfg.Ping > 0 {
+			s.Tested++
+			totalPing += cfg.Ping
+		}
+	}
+
+	if s.Tested > 0 {
+		s.AverageLatency = float64(totalPing) / float64(s.Tested)
+	}
+
+	return s
+}
+
+// String renders the summary in a human-readable form
+func (s *Summary) String() string {
+	var sb strings.Builder
+
+	sb.WriteString("Summary:\n")
+	sb.WriteString(fmt.Sprintf("  Total configs: %d\n", s.Total))
+	sb.WriteString(fmt.Sprintf("  Duplicates removed: %d\n", s.Duplicates))
+	sb.WriteString("  Protocols: " + formatCounts(s.ByProtocol) + "\n")
+	sb.WriteString("  Sources: " + formatCounts(s.BySource) + "\n")
+
+	if len(s.ByCountry) > 0 {
+		sb.WriteString("  Countries: " + formatCounts(s.ByCountry) + "\n")
+	}
+
+	if len(s.Unsupported) > 0 {
+		sb.WriteString("  Unsupported (skipped): " + formatCounts(s.Unsupported) + "\n")
+	}
+
+	if s.Tested > 0 {
+		sb.WriteString(fmt.Sprintf("  Average latency: %.0fms (%d tested)\n", s.AverageLatency, s.Tested))
+	}
+
+	return sb.String()
+}
+
+// Print writes the summary to w as text or JSON
+func (s *Summary) Print(w io.Writer, format string) error {
+	if format == "json" {
+		data, err := json.Marshal(s)
+		if err != nil {
+			return fmt.Errorf("failed to encode summary: %w", err)
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+// WriteFile writes the summary as an indented JSON stats file stamped with
+// generatedAt, replacing path only once the write has succeeded
+func (s *Summary) WriteFile(path string, generatedAt time.Time) error {
+	s.GeneratedAt = generatedAt.UTC().Format(time.RFC3339)
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode stats: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create stats directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+
+	return nil
+}
+
+// formatCounts renders a count map as "key=n" pairs sorted by key
+func formatCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		name := key
+		if name == "" {
+			name = "unknown"
+		}
+		parts = append(parts, fmt.Sprintf("%s=%d", name, counts[key]))
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSummaryCounts tests that summary counts match the config set
+func TestSummaryCounts(t *testing.T) {
+	configs := []*Config{
+		{Protocol: "vless", Source: "source-a", Country: "DE", Ping: 100},
+		{Protocol: "vless", Source: "source-a", Country: "DE", Ping: 300},
+		{Protocol: "trojan", Source: "source-b", Country: "US"},
+		{Protocol: "ss", Source: "source-b"},
+	}
+
+	summary := NewSummary(configs, 3)
+
+	if summary.Total != 4 {
+		t.Errorf("Expected total 4, got %d", summary.Total)
+	}
+
+	if summary.ByProtocol["vless"] != 2 || summary.ByProtocol["trojan"] != 1 || summary.ByProtocol["ss"] != 1 {
+		t.Errorf("Unexpected protocol counts: %v", summary.ByProtocol)
+	}
+
+	if summary.BySource["source-a"] != 2 || summary.BySource["source-b"] != 2 {
+		t.Errorf("Unexpected source counts: %v", summary.BySource)
+	}
+
+	if summary.ByCountry["DE"] != 2 || summary.ByCountry["US"] != 1 || len(summary.ByCountry) != 2 {
+		t.Errorf("Unexpected country counts: %v", summary.ByCountry)
+	}
+
+	if summary.Tested != 2 {
+		t.Errorf("Expected 2 tested configs, got %d", summary.Tested)
+	}
+
+	if summary.AverageLatency != 200 {
+		t.Errorf("Expected average latency 200, got %f", summary.AverageLatency)
+	}
+
+	if summary.Duplicates != 3 {
+		t.Errorf("Expected 3 duplicates, got %d", summary.Duplicates)
+	}
+}
+
+// TestSummaryPrint tests text and JSON summary output
+func TestSummaryPrint(t *testing.T) {
+	summary := NewSummary([]*Config{{Protocol: "vless", Source: "source-a"}}, 1)
+	summary.Unsupported = map[string]int{"wireguard": 2}
+
+	var text bytes.Buffer
+	if err := summary.Print(&text, "text"); err != nil {
+		t.Fatalf("Failed to print text summary: %v", err)
+	}
+
+	if !strings.Contains(text.String(), "vless=1") {
+		t.Errorf("Text summary should include protocol counts, got %q", text.String())
+	}
+
+	if !strings.Contains(text.String(), "wireguard=2") {
+		t.Errorf("Text summary should include unsupported counts, got %q", text.String())
+	}
+
+	var out bytes.Buffer
+	if err := summary.Print(&out, "json"); err != nil {
+		t.Fatalf("Failed to print JSON summary: %v", err)
+	}
+
+	var decoded Summary
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("JSON summary should be valid JSON: %v", err)
+	}
+
+	if decoded.Total != 1 || decoded.Duplicates != 1 {
+		t.Errorf("Unexpected decoded summary: %+v", decoded)
+	}
+}
+
+// TestSummaryStatsFile tests that the stats sidecar is written with correct counts
+func TestSummaryStatsFile(t *testing.T) {
+	configs := []*Config{
+		{Protocol: "vless", Source: "source-a", Country: "DE", Ping: 100},
+		{Protocol: "vless", Source: "source-a", Country: "IR", Ping: 200},
+		{Protocol: "trojan", Source: "source-b", Country: "DE"},
+	}
+
+	path := filepath.Join(t.TempDir(), "stats", "stats.json")
+	generatedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := NewSummary(configs, 1).WriteFile(path, generatedAt); err != nil {
+		t.Fatalf("Failed to write stats file: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read stats file: %v", err)
+	}
+
+	var stats Summary
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatalf("Stats file is not valid JSON: %v", err)
+	}
+
+	if stats.Total != 3 {
+		t.Errorf("Expected total 3, got %d", stats.Total)
+	}
+	if stats.ByProtocol["vless"] != 2 || stats.ByProtocol["trojan"] != 1 {
+		t.Errorf("Unexpected protocol counts: %v", stats.ByProtocol)
+	}
+	if stats.ByCountry["DE"] != 2 || stats.ByCountry["IR"] != 1 {
+		t.Errorf("Unexpected country counts: %v", stats.ByCountry)
+	}
+	if stats.AverageLatency != 150 {
+		t.Errorf("Expected average latency 150, got %f", stats.AverageLatency)
+	}
+	if stats.GeneratedAt != "2024-03-01T12:00:00Z" {
+		t.Errorf("Expected generation time 2024-03-01T12:00:00Z, got %s", stats.GeneratedAt)
+	}
+}
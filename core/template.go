@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+)
+
+// templateFuncs are the helpers available to -template-file templates
+func templateFuncs() template.FuncMap {
+	sg := &SubscriptionGenerator{}
+	return template.FuncMap{
+		// base64 encodes a string with standard padded base64
+		"base64": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		// protocol maps a config protocol to its client type name (e.g. reality -> vless)
+		"protocol": sg.mapProtocol,
+		// link renders the config's share URI
+		"link": func(cfg *Config) string {
+			return cfg.String()
+		},
+		// quote renders a double-quoted string literal
+		"quote": strconv.Quote,
+	}
+}
+
+// LoadOutputTemplate parses a text/template file for the template format.
+// The template is executed with the []*Config being generated.
+func LoadOutputTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs()).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	return tmpl, nil
+}
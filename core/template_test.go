@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestTemplateFormat tests rendering a user template with the helper funcs
+func TestTemplateFormat(t *testing.T) {
+	path := writeTestFile(t, "client.tmpl", `{{range .}}{{.Name}} {{protocol .Protocol}} {{.Server}}:{{.Port}} {{base64 .Password}}
+{{end}}`)
+
+	tmpl, err := LoadOutputTemplate(path)
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	configs := []*Config{
+		{ID: "reality-1", Protocol: "reality", Server: "a.com", Port: 443, UUID: "uuid-a", Name: "Node A"},
+		{ID: "trojan-1", Protocol: "trojan", Server: "b.com", Port: 8443, Password: "pass", Name: "Node B"},
+	}
+
+	gen := NewSubscriptionGenerator("template")
+	gen.SetTemplate(tmpl)
+
+	sub, err := gen.Generate(configs)
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	expected := "Node A vless a.com:443 \nNode B trojan b.com:8443 cGFzcw==\n"
+	if sub != expected {
+		t.Errorf("Expected %q, got %q", expected, sub)
+	}
+
+	if _, err := NewSubscriptionGenerator("template").Generate(configs); err == nil {
+		t.Errorf("Expected error for the template format without a template")
+	}
+}
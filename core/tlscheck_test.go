@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTLSServer starts a local TLS server whose certificate expires after validity
+func startTLSServer(t *testing.T, validity time.Duration) *Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "node.test"},
+		DNSNames:     []string{"node.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	return &Config{
+		Protocol:   "trojan",
+		Server:     "127.0.0.1",
+		Port:       ln.Addr().(*net.TCPAddr).Port,
+		Password:   "pass",
+		ServerName: "node.test",
+	}
+}
+
+// TestTLSCheckFlagsExpiringCert tests that a short-lived certificate is flagged within the validity window
+func TestTLSCheckFlagsExpiringCert(t *testing.T) {
+	cfg := startTLSServer(t, time.Hour)
+
+	flagged := NewTLSChecker(2*time.Second, 24*time.Hour, 1).Check([]*Config{cfg})
+
+	if len(flagged) != 1 || flagged[0] != cfg {
+		t.Fatalf("Expected the short-lived cert to be flagged, got %v", flagged)
+	}
+
+	if cfg.ValidationStatus != StatusCertExpiring {
+		t.Errorf("Expected status %q, got %q", StatusCertExpiring, cfg.ValidationStatus)
+	}
+
+	if until := time.Until(cfg.CertNotAfter); until <= 0 || until > time.Hour {
+		t.Errorf("Expected NotAfter within the next hour, got %v", cfg.CertNotAfter)
+	}
+}
+
+// TestTLSCheckKeepsValidCert tests that a certificate outside the validity window is kept
+func TestTLSCheckKeepsValidCert(t *testing.T) {
+	cfg := startTLSServer(t, time.Hour)
+	reality := &Config{Protocol: "vless", Server: "127.0.0.1", Port: 1, Security: "reality", PublicKey: "pbk"}
+
+	flagged := NewTLSChecker(2*time.Second, 0, 2).Check([]*Config{cfg, reality})
+
+	if len(flagged) != 0 {
+		t.Errorf("Expected no flagged configs, got %v", flagged)
+	}
+
+	if cfg.CertNotAfter.IsZero() {
+		t.Errorf("Expected NotAfter to be recorded")
+	}
+
+	if !reality.CertNotAfter.IsZero() {
+		t.Errorf("Expected REALITY config to be skipped")
+	}
+}
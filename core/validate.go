@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Exit codes returned by validate mode
+const (
+	ExitOK      = 0 // files are valid
+	ExitFatal   = 1 // a file is missing or unparseable
+	ExitWarning = 2 // files parse but contain suspicious entries
+)
+
+// ValidationResult holds the outcome of validating the configuration files
+type ValidationResult struct {
+	Warnings []string
+	Err      error
+}
+
+// ExitCode maps the validation result to a process exit code
+func (vr *ValidationResult) ExitCode() int {
+	if vr.Err != nil {
+		return ExitFatal
+	}
+	if len(vr.Warnings) > 0 {
+		return ExitWarning
+	}
+	return ExitOK
+}
+
+func (vr *ValidationResult) warn(format string, args ...interface{}) {
+	vr.Warnings = append(vr.Warnings, fmt.Sprintf(format, args...))
+}
+
+// validateConfigFiles checks that the sources and rules files exist and parse,
+// and collects warnings for entries that are ignored or not understood
+func validateConfigFiles(sourcesFile, rulesFile string) *ValidationResult {
+	result := &ValidationResult{}
+
+	if _, err := os.Stat(sourcesFile); err != nil {
+		result.Err = fmt.Errorf("sources file not found: %w", err)
+		return result
+	}
+
+	if _, err := expandRulesFiles(rulesFile); err != nil {
+		result.Err = fmt.Errorf("rules file not found: %w", err)
+		return result
+	}
+
+	sources, _, err := loadSources(sourcesFile)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to parse sources file: %w", err)
+		return result
+	}
+
+	rules, err := loadRules(rulesFile)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to parse rules file: %w", err)
+		return result
+	}
+
+	for _, source := range sources {
+		if !source.Enabled {
+			result.warn("source %q is disabled", source.Name)
+		}
+		switch source.Type {
+		case "base64", "json", "plain":
+		default:
+			result.warn("source %q has unknown type %q", source.Name, source.Type)
+		}
+	}
+
+	for _, rule := range rules {
+		switch rule.Type {
+		case "country", "protocol", "domain":
+		default:
+			result.warn("rule %q has unknown type %q", rule.Name, rule.Type)
+		}
+		switch rule.Action {
+		case "include", "exclude":
+		default:
+			result.warn("rule %q has unknown action %q", rule.Name, rule.Action)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestValidateClean tests that valid files return exit code 0
+func TestValidateClean(t *testing.T) {
+	sources := writeTestFile(t, "sources.yaml", `
+- name: source-a
+  url: https://example.com/a
+  type: base64
+  enabled: true
+`)
+	rules := writeTestFile(t, "rules.json", `[{"name":"vless","type":"protocol","pattern":"vless","action":"include","enabled":true}]`)
+
+	result := validateConfigFiles(sources, rules)
+	if code := result.ExitCode(); code != ExitOK {
+		t.Errorf("Expected exit code %d, got %d (warnings: %v, err: %v)", ExitOK, code, result.Warnings, result.Err)
+	}
+}
+
+// TestValidateWarnings tests that parseable files with suspicious entries return exit code 2
+func TestValidateWarnings(t *testing.T) {
+	sources := writeTestFile(t, "sources.yaml", `
+- name: source-a
+  url: https://example.com/a
+  type: base64
+  enabled: false
+`)
+	rules := writeTestFile(t, "rules.json", `[{"name":"odd","type":"asn","pattern":"13335","action":"exclude","enabled":true}]`)
+
+	result := validateConfigFiles(sources, rules)
+	if result.Err != nil {
+		t.Fatalf("Expected no fatal error, got %v", result.Err)
+	}
+
+	if len(result.Warnings) != 2 {
+		t.Errorf("Expected 2 warnings, got %v", result.Warnings)
+	}
+
+	if code := result.ExitCode(); code != ExitWarning {
+		t.Errorf("Expected exit code %d, got %d", ExitWarning, code)
+	}
+}
+
+// TestValidateFatal tests that missing or unparseable files return exit code 1
+func TestValidateFatal(t *testing.T) {
+	rules := writeTestFile(t, "rules.json", "[]")
+
+	missing := validateConfigFiles(filepath.Join(t.TempDir(), "missing.yaml"), rules)
+	if code := missing.ExitCode(); code != ExitFatal {
+		t.Errorf("Expected exit code %d for missing file, got %d", ExitFatal, code)
+	}
+
+	sources := writeTestFile(t, "sources.yaml", "- name: source-a\n")
+	broken := writeTestFile(t, "broken.json", "{not json")
+
+	unparseable := validateConfigFiles(sources, broken)
+	if code := unparseable.ExitCode(); code != ExitFatal {
+		t.Errorf("Expected exit code %d for unparseable file, got %d", ExitFatal, code)
+	}
+}
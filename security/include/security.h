@@ -122,6 +122,27 @@ int apply_dynamic_pattern_rotation(
     int* output_len
 );
 
+/**
+ * Apply dynamic pattern rotation with a fixed seed
+ * Same seed and packet always produce the same output
+ * @param packet Network packet
+ * @param packet_len Length of packet
+ * @param output Output buffer with rotated pattern
+ * @param output_capacity Size of the output buffer; fails if the rotated
+ *        pattern does not fit
+ * @param output_len Pointer to output length
+ * @param seed Seed for the pattern variation
+ * @return 0 on success, -1 on failure
+ */
+int apply_dynamic_pattern_rotation_seeded(
+    const unsigned char* packet,
+    int packet_len,
+    unsigned char* output,
+    int output_capacity,
+    int* output_len,
+    uint64_t seed
+);
+
 /**
  * Get error message for last error
  * @return Error message string
@@ -388,6 +388,61 @@ pub extern "C" fn apply_dynamic_pattern_rotation(
     }
 }
 
+/// Apply dynamic pattern rotation with a fixed seed for reproducible output
+#[no_mangle]
+pub extern "C" fn apply_dynamic_pattern_rotation_seeded(
+    packet: *const u8,
+    packet_len: c_int,
+    output: *mut u8,
+    output_capacity: c_int,
+    output_len: *mut c_int,
+    seed: u64,
+) -> c_int {
+    if packet.is_null() || output.is_null() || output_len.is_null() {
+        set_error("Null pointer passed to apply_dynamic_pattern_rotation_seeded");
+        return -1;
+    }
+
+    if output_capacity < 0 {
+        set_error("Negative output capacity passed to apply_dynamic_pattern_rotation_seeded");
+        return -1;
+    }
+    let output_capacity = output_capacity as usize;
+
+    let packet_len = packet_len as usize;
+    let packet_slice = unsafe { std::slice::from_raw_parts(packet, packet_len) };
+
+    match std::panic::catch_unwind(|| {
+        unsafe {
+            if let Some(ref state) = SECURITY_STATE {
+                if let Ok(rotated) = state.pattern_rotator.rotate_pattern_seeded(packet_slice, seed) {
+                    if rotated.len() <= output_capacity {
+                        let out_slice = std::slice::from_raw_parts_mut(output, rotated.len());
+                        out_slice.copy_from_slice(&rotated);
+                        *output_len = rotated.len() as c_int;
+                        return 0;
+                    }
+
+                    set_error("Rotated pattern larger than output buffer");
+                    return -1;
+                }
+
+                set_error("Pattern rotation failed");
+                return -1;
+            }
+
+            set_error("Security module not initialized");
+            -1
+        }
+    }) {
+        Ok(result) => result,
+        Err(_) => {
+            set_error("Panic in apply_dynamic_pattern_rotation_seeded");
+            -1
+        }
+    }
+}
+
 /// Helper function to set error message
 fn set_error(message: &str) {
     if let Ok(mut err) = ERROR_MESSAGE.lock() {
@@ -422,4 +477,43 @@ mod tests {
             -1
         );
     }
+
+    #[test]
+    fn test_seeded_rotation_respects_output_capacity() {
+        assert_eq!(security_init(), 0);
+
+        let packet = vec![0x42u8; 1000];
+        let mut output_len = 0;
+        let mut output = vec![0u8; 1000];
+
+        // A buffer smaller than the packet cannot hold the rotated output
+        assert_eq!(
+            apply_dynamic_pattern_rotation_seeded(
+                packet.as_ptr(),
+                packet.len() as c_int,
+                output.as_mut_ptr(),
+                10,
+                &mut output_len,
+                42
+            ),
+            -1
+        );
+        assert_eq!(output_len, 0);
+
+        let mut output = vec![0u8; 2000];
+        assert_eq!(
+            apply_dynamic_pattern_rotation_seeded(
+                packet.as_ptr(),
+                packet.len() as c_int,
+                output.as_mut_ptr(),
+                output.len() as c_int,
+                &mut output_len,
+                42
+            ),
+            0
+        );
+        assert!(output_len as usize >= packet.len());
+
+        assert_eq!(security_shutdown(), 0);
+    }
 }
@@ -2,7 +2,8 @@
 //! Rotates protocol signatures and connection patterns to avoid being classified
 
 use crate::error::{Error, Result};
-use rand::Rng;
+use rand::rngs::StdRng;
+use rand::{Rng, SeedableRng};
 use std::time::{SystemTime, UNIX_EPOCH};
 
 pub struct PatternRotator {
@@ -52,9 +53,20 @@ impl PatternRotator {
         Ok(data.to_vec())
     }
 
+    /// Apply pattern variation driven by a fixed seed, so the same seed and
+    /// input always produce the same output
+    pub fn rotate_pattern_seeded(&self, data: &[u8], seed: u64) -> Result<Vec<u8>> {
+        let mut rng = StdRng::seed_from_u64(seed);
+        Ok(Self::vary_pattern(data, &mut rng))
+    }
+
     fn apply_pattern_variation(&self, data: &[u8]) -> Result<Vec<u8>> {
-        let mut result = Vec::new();
         let mut rng = rand::thread_rng();
+        Ok(Self::vary_pattern(data, &mut rng))
+    }
+
+    fn vary_pattern<R: Rng>(data: &[u8], rng: &mut R) -> Vec<u8> {
+        let mut result = Vec::new();
 
         // Vary packet order
         if data.len() > 100 {
@@ -70,7 +82,7 @@ impl PatternRotator {
             result = data.to_vec();
         }
 
-        Ok(result)
+        result
     }
 
     fn apply_current_pattern(&self, data: &[u8]) -> Vec<u8> {